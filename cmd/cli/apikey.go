@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/fx"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+)
+
+const apikeyUsage = `Usage: %[1]s apikey <command> [options] <arguments>
+
+Commands:
+  create [-name n] [-scopes s] [-rate-limit r] [-ttl d] <username>  issue a key acting as the user
+  list                                                           list all keys
+  revoke <prefix>                                                revoke a key
+
+The key is only shown once by create, only its hash is stored.
+`
+
+// runAPIKeyCommand handles `apikey` sub-commands and returns the exit code
+func runAPIKeyCommand(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		fmt.Fprintf(os.Stderr, apikeyUsage, os.Args[0])
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "create":
+		err = apikeyCreate(args[1:])
+	case "list":
+		err = apikeyList(args[1:])
+	case "revoke":
+		err = apikeyRevoke(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown apikey command: %s\n", args[0])
+		fmt.Fprintf(os.Stderr, apikeyUsage, os.Args[0])
+		return 2
+	}
+	return exitCode(err)
+}
+
+func newAPIKeyManager() (*bootstrap.APIKeyManager, error) {
+	var db *bootstrap.DBToolKit
+	if err := populate([]fx.Option{bootstrap.DBModule}, &db); err != nil {
+		return nil, err
+	}
+	return bootstrap.NewAPIKeyManager(db), nil
+}
+
+func apikeyCreate(args []string) error {
+	fs := newConfigFlagSet("apikey create")
+	name := fs.String("name", "", "name of the key, e.g. the client using it")
+	scopes := fs.String("scopes", "", "comma separated scopes of the key")
+	rateLimit := fs.Int("rate-limit", 0, "requests per minute, 0 for unlimited")
+	ttl := fs.Duration("ttl", 0, "lifetime of the key, 0 for never expiring")
+	if err := parseUserArgs(fs, args, "username"); err != nil {
+		return err
+	}
+	if *rateLimit < 0 || *ttl < 0 {
+		return fmt.Errorf("rate-limit and ttl must not be negative")
+	}
+
+	km, err := newAPIKeyManager()
+	if err != nil {
+		return err
+	}
+	opts := bootstrap.APIKeyOptions{RateLimit: *rateLimit, TTL: *ttl}
+	for _, scope := range strings.Split(*scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			opts.Scopes = append(opts.Scopes, scope)
+		}
+	}
+	key, _, err := km.Create(context.Background(), fs.Arg(0), *name, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "API key of "+fs.Arg(0)+" created, it is not shown again:")
+	fmt.Println(key)
+	return nil
+}
+
+func apikeyList(args []string) error {
+	fs := newConfigFlagSet("apikey list")
+	if err := parseUserArgs(fs, args); err != nil {
+		return err
+	}
+
+	km, err := newAPIKeyManager()
+	if err != nil {
+		return err
+	}
+	keys, err := km.List(context.Background())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	fmt.Printf("%-14s %-16s %-20s %-8s %-10s %-20s %-20s %s\n", "PREFIX", "USERNAME", "NAME", "LIMIT", "STATUS", "EXPIRES", "LAST USED", "SCOPES")
+	for _, key := range keys {
+		status := "active"
+		switch {
+		case key.RevokedAt != nil:
+			status = "revoked"
+		case !key.Active(now):
+			status = "expired"
+		}
+		fmt.Printf("%-14s %-16s %-20s %-8d %-10s %-20s %-20s %s\n", key.Prefix, key.Username, key.Name, key.RateLimit,
+			status, formatTime(key.ExpiresAt), formatTime(key.LastUsedAt), strings.Join(key.Scopes, ","))
+	}
+	return nil
+}
+
+func apikeyRevoke(args []string) error {
+	fs := newConfigFlagSet("apikey revoke")
+	if err := parseUserArgs(fs, args, "prefix"); err != nil {
+		return err
+	}
+
+	km, err := newAPIKeyManager()
+	if err != nil {
+		return err
+	}
+	if err = km.Revoke(context.Background(), fs.Arg(0)); err != nil {
+		return err
+	}
+
+	fmt.Println("API key " + fs.Arg(0) + " revoked")
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/robinmin/gin-starter/config"
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+)
+
+const configUsage = `Usage: %[1]s config <command> [options]
+
+Commands:
+  init    write a fully defaulted config file
+  print   show the effective config with secrets redacted
+  check   validate a config file, exit with non-zero code on errors
+  schema  emit the JSON Schema of the config file
+  keygen  generate a random key for encrypted values
+  encrypt encrypt a value into an ENC[...] marker
+  decrypt decrypt an ENC[...] marker
+
+Encrypted values are decrypted with the key in $%[3]s_CONFIG_KEY, or in the file named by $%[3]s_CONFIG_KEY_FILE.
+
+Run '%[1]s config <command> -h' for the options of each command.
+`
+
+// runConfigCommand handles `config` sub-commands and returns the exit code
+func runConfigCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, configUsage, os.Args[0], os.Args[0], config.EnvPrefix)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "init":
+		err = configInit(args[1:])
+	case "print":
+		err = configPrint(args[1:])
+	case "check":
+		err = configCheck(args[1:])
+	case "schema":
+		err = configSchema(args[1:])
+	case "keygen":
+		err = configKeygen(args[1:])
+	case "encrypt", "decrypt":
+		err = configCrypt(args[0], args[1:])
+	case "-h", "help":
+		fmt.Fprintf(os.Stderr, configUsage, os.Args[0], os.Args[0], config.EnvPrefix)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown config command: %s\n", args[0])
+		fmt.Fprintf(os.Stderr, configUsage, os.Args[0], os.Args[0], config.EnvPrefix)
+		return 2
+	}
+
+	return exitCode(err)
+}
+
+// newConfigFlagSet registers the options shared by the commands reading a config file
+func newConfigFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&config_file, "f", config_file, "config file")
+	fs.Var(&overrides, "set", "override config item by path, e.g. -set basic.redis.db=1 (repeatable)")
+	return fs
+}
+
+func configInit(args []string) error {
+	fs := flag.NewFlagSet("config init", flag.ContinueOnError)
+	output := fs.String("o", "config/app_config.yaml", "output file")
+	force := fs.Bool("force", false, "overwrite the output file if it exists")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(*output); err == nil && !*force {
+		return fmt.Errorf("%s already exists, use -force to overwrite it", *output)
+	}
+
+	cfg := bootstrap.NewInstance[config.MyAppConfig]()
+	if cfg == nil {
+		return errors.New("failed to apply default values")
+	}
+	if err := bootstrap.SaveConfig(cfg, *output); err != nil {
+		return err
+	}
+
+	fmt.Println("Config file written to " + *output)
+	return nil
+}
+
+func configPrint(args []string) error {
+	fs := newConfigFlagSet("config print")
+	showSources := fs.Bool("sources", false, "show which layer supplied each config item instead")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, loader, err := loadMyAppConfig()
+	if cfg == nil {
+		return err
+	}
+	if err != nil {
+		// still show the config, the violations help to locate the problems
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+
+	if *showSources {
+		printConfigSources(loader)
+		return nil
+	}
+
+	data, err := yaml.Marshal(bootstrap.RedactConfig(cfg))
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(data))
+	return nil
+}
+
+func configCheck(args []string) error {
+	fs := newConfigFlagSet("config check")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if _, _, err := loadMyAppConfig(); err != nil {
+		return err
+	}
+
+	fmt.Println("Config file " + config_file + " is valid")
+	return nil
+}
+
+func configSchema(args []string) error {
+	fs := flag.NewFlagSet("config schema", flag.ContinueOnError)
+	output := fs.String("o", "", "output file, print to stdout if not specified")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	schema := bootstrap.ConfigSchema(bootstrap.NewInstance[config.MyAppConfig](), config.AppName+" config")
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		fmt.Println(string(data))
+		return nil
+	}
+	return os.WriteFile(*output, append(data, '\n'), 0o644)
+}
+
+func configKeygen(args []string) error {
+	fs := flag.NewFlagSet("config keygen", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	key, err := bootstrap.GenerateSecretKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println(key)
+	return nil
+}
+
+// configCrypt encrypts or decrypts the value given as argument, or read from stdin to keep it out of the shell history
+func configCrypt(action string, args []string) error {
+	fs := flag.NewFlagSet("config "+action, flag.ContinueOnError)
+	keyFile := fs.String("key-file", "", "file of the config key, use $"+config.EnvPrefix+"_CONFIG_KEY(_FILE) if not specified")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var (
+		sc  *bootstrap.SecretCipher
+		err error
+	)
+	if *keyFile != "" {
+		var key []byte
+		if key, err = os.ReadFile(*keyFile); err == nil {
+			sc, err = bootstrap.NewSecretCipher(key)
+		}
+	} else {
+		sc, err = bootstrap.LoadSecretCipher(config.EnvPrefix)
+	}
+	if err != nil {
+		return err
+	}
+	if sc == nil {
+		return errors.New("no config key, set $" + config.EnvPrefix + "_CONFIG_KEY or use -key-file")
+	}
+
+	value := fs.Arg(0)
+	if value == "" || value == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimRight(string(data), "\r\n")
+	}
+
+	var result string
+	if action == "encrypt" {
+		result, err = sc.Encrypt(value)
+	} else {
+		result, err = sc.Decrypt(value)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(result)
+	return nil
+}
+
+func printConfigSources(loader *bootstrap.ConfigLoader[config.MyAppConfig]) {
+	sources := loader.Sources()
+	paths := make([]string, 0, len(sources))
+	for path := range sources {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Printf("%-50s %s\n", path, sources[path])
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"os"
+	"sort"
 
 	"github.com/robinmin/gin-starter/config"
 	"github.com/robinmin/gin-starter/pkg/bootstrap"
 	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+	"github.com/robinmin/gin-starter/pkg/handler"
 	"go.uber.org/fx"
 	"go.uber.org/fx/fxevent"
 )
@@ -15,6 +19,7 @@ var (
 	help        bool
 	config_file string
 	verbose     bool
+	overrides   bootstrap.ConfigOverrides
 )
 
 func init() {
@@ -22,38 +27,161 @@ func init() {
 	flag.BoolVar(&help, "h", false, "show the help message")
 	flag.StringVar(&config_file, "f", "", "config file")
 	flag.BoolVar(&verbose, "v", false, "show detail information")
+	flag.Var(&overrides, "set", "override config item by path, e.g. -set basic.redis.db=1 (repeatable)")
 }
 
 // /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-func newMyAppConfig() *config.MyAppConfig {
+func loadMyAppConfig() (*config.MyAppConfig, *bootstrap.ConfigLoader[config.MyAppConfig], error) {
 	if config_file == "" {
 		config_file = "config/app_config.yaml"
 	}
-	cfg, err := bootstrap.LoadConfig[config.MyAppConfig](config_file)
+	loader := bootstrap.NewConfigLoader[config.MyAppConfig](config.EnvPrefix, overrides)
+	cfg, err := loader.Load(config_file)
+	if err != nil {
+		return nil, loader, err
+	}
+	return cfg, loader, loader.Validate(cfg)
+}
+
+func newMyAppConfig() (*config.MyAppConfig, error) {
+	cfg, loader, err := loadMyAppConfig()
 	if err != nil {
 		fmt.Println("Failed to load yaml config file: " + err.Error())
-		return nil
+		return nil, err
 	}
 
-	return cfg
+	if verbose {
+		printConfigSources(loader)
+	}
+
+	return cfg, nil
 }
 
-func main() {
-	// ctx := context.Background()
-	// Initialize logger.
-	cleanLoggerFn, err := bootstrap.InitLogger()
+func newAppConfig(cfg *config.MyAppConfig) types.AppConfig {
+	sc := cfg.Basic
+	sc.Sentry.EventsMeta = config.SentryEventsMeta
+	// sc.Log.Config = sloggin.Config{
+	// 	WithSpanID:  true,
+	// 	WithTraceID: true,
+	// }
+	return sc
+}
+
+// newConfigReloader enables hot-reload of the config file with the same layers as the startup
+func newConfigReloader() *bootstrap.ConfigReloader {
+	return &bootstrap.ConfigReloader{
+		File: config_file,
+		Load: func() (types.AppConfig, error) {
+			cfg, _, err := loadMyAppConfig()
+			if err != nil {
+				return types.AppConfig{}, err
+			}
+			return newAppConfig(cfg), nil
+		},
+	}
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type command struct {
+	summary string
+	run     func(args []string) int
+}
+
+var commands = map[string]command{
+	"serve":   {"run the HTTP server (default)", runServeCommand},
+	"config":  {"manage config files", runConfigCommand},
+	"migrate": {"apply or roll back the database migrations", runMigrateCommand},
+	"user":    {"manage users and their roles", runUserCommand},
+	"apikey":  {"manage API keys of machine clients", runAPIKeyCommand},
+	"policy":  {"manage casbin policy rules", runPolicyCommand},
+	"routes":  {"list the HTTP routes", runRoutesCommand},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] [command] [command options]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s%s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nOptions:\n")
+	flag.PrintDefaults()
+}
+
+// newFxLogger keeps the admin commands quiet unless -v is specified
+func newFxLogger(log *bootstrap.AppLogger) fxevent.Logger {
+	if verbose {
+		return &fxevent.ZapLogger{Logger: log.Logger}
+	}
+	return fxevent.NopLogger
+}
+
+// populate builds the fx graph from the core and the given modules only, and fills targets without starting anything
+func populate(modules []fx.Option, targets ...interface{}) error {
+	opts := []fx.Option{
+		fx.WithLogger(newFxLogger),
+		fx.Provide(newMyAppConfig),
+		fx.Provide(newAppConfig),
+		fx.Provide(newConfigReloader),
+		bootstrap.CoreModule,
+	}
+	opts = append(opts, modules...)
+	opts = append(opts, fx.Populate(targets...))
+	return fx.New(opts...).Err()
+}
+
+// exitCode reports err and turns it into the exit code of a command
+func exitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
 	}
-	defer cleanLoggerFn()
+	return 0
+}
 
+func main() {
 	// parse command line arguments and show help only if specified
+	flag.Usage = usage
 	flag.Parse()
 	if help {
 		flag.Usage()
 		return
 	}
 
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", name)
+		flag.Usage()
+		os.Exit(2)
+	}
+	os.Exit(cmd.run(args))
+}
+
+func runServeCommand(args []string) int {
+	fs := newConfigFlagSet("serve")
+	if err := fs.Parse(args); err != nil {
+		return exitCode(err)
+	}
+
+	// ctx := context.Background()
+	// Initialize logger.
+	cleanLoggerFn, err := bootstrap.InitLogger()
+	if err != nil {
+		panic(err)
+	}
+	defer cleanLoggerFn()
+
 	// set error information
 	bootstrap.SetErrorInfo(config.ErrorCodeMapping)
 
@@ -63,18 +191,12 @@ func main() {
 		}),
 		// configurations for logger and config file items
 		fx.Provide(newMyAppConfig),
-		fx.Provide(func(cfg *config.MyAppConfig) types.AppConfig {
-			sc := cfg.Basic
-			sc.Sentry.EventsMeta = config.SentryEventsMeta
-			// sc.Log.Config = sloggin.Config{
-			// 	WithSpanID:  true,
-			// 	WithTraceID: true,
-			// }
-			return sc
-		}),
+		fx.Provide(newAppConfig),
+		fx.Provide(newConfigReloader),
 
 		// enable inported modules
 		bootstrap.Module,
+		handler.Module,
 
 		// run application
 		fx.Invoke(func(app *bootstrap.Application, logger *bootstrap.AppLogger) {
@@ -85,4 +207,5 @@ func main() {
 			}
 		}),
 	).Run()
+	return 0
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.uber.org/fx"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+const migrateUsage = `Usage: %[1]s migrate [command] [options]
+
+Commands:
+  up [-to version]  apply the pending migrations, the default command
+  down              roll back the latest migration
+  status            list the migrations and whether they are applied
+  redo              roll back the latest migration and apply it again
+`
+
+// runMigrateCommand handles `migrate` sub-commands and returns the exit code
+func runMigrateCommand(args []string) int {
+	action := "up"
+	if len(args) > 0 && args[0][0] != '-' {
+		action, args = args[0], args[1:]
+	}
+
+	var err error
+	switch action {
+	case "up", "down", "status", "redo":
+		err = migrate(action, args)
+	case "help":
+		fmt.Fprintf(os.Stderr, migrateUsage, os.Args[0])
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown migrate command: %s\n", action)
+		fmt.Fprintf(os.Stderr, migrateUsage, os.Args[0])
+		return 2
+	}
+	return exitCode(err)
+}
+
+func migrate(action string, args []string) error {
+	fs := newConfigFlagSet("migrate " + action)
+	to := int64(0)
+	if action == "up" {
+		fs.Int64Var(&to, "to", 0, "apply the migrations up to this version only")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// the migrations are run here explicitly, whatever auto_migrate says
+	overrides = append(overrides, "basic.database.auto_migrate=false")
+	var (
+		cfg types.AppConfig
+		db  *bootstrap.DBToolKit
+	)
+	if err := populate([]fx.Option{bootstrap.DBModule}, &cfg, &db); err != nil {
+		return err
+	}
+
+	migrator, err := bootstrap.NewSchemaMigrator(db, cfg.Database.Type)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch action {
+	case "up":
+		applied, err := migrator.UpTo(ctx, to)
+		for _, migration := range applied {
+			fmt.Printf("Applied %05d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("Database schema is up to date")
+		}
+		return err
+	case "down", "redo":
+		var migration *bootstrap.Migration
+		if action == "down" {
+			migration, err = migrator.Down(ctx)
+		} else {
+			migration, err = migrator.Redo(ctx)
+		}
+		if errors.Is(err, bootstrap.ErrNoMigration) {
+			fmt.Println("No migration applied yet")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if action == "down" {
+			fmt.Printf("Rolled back %05d_%s\n", migration.Version, migration.Name)
+		} else {
+			fmt.Printf("Reapplied %05d_%s\n", migration.Version, migration.Name)
+		}
+		return nil
+	default:
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-8s %-32s %s\n", "VERSION", "NAME", "APPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%05d    %-32s %s\n", status.Version, status.Name, appliedAt)
+		}
+		return nil
+	}
+}
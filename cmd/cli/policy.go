@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/fx"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+const policyUsage = `Usage: %[1]s policy <command> [options] <arguments>
+
+Commands:
+  list                          list all policy rules
+  add p <sub> <obj> <act>       add a permission rule
+  add g <user> <role>           add a role inheritance rule
+  remove p <sub> <obj> <act>    remove a permission rule
+  remove g <user> <role>        remove a role inheritance rule
+  test [-model file] [-policy file] [-v] <suite.yaml>
+                                run a YAML test suite, see bootstrap.PolicySuite
+  explain [-model file] [-policy file] <sub> <obj> <act>
+                                show the decision of a request and the rule which decided it
+
+The model and the policy are taken from the config and the database unless given by the flags or the suite, the policy
+file is in the CSV format of casbin, e.g. the output of list.
+`
+
+// policyActor records the changes by the command in the audit trail of the policy
+const policyActor = "cli"
+
+// runPolicyCommand handles `policy` sub-commands and returns the exit code
+func runPolicyCommand(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		fmt.Fprintf(os.Stderr, policyUsage, os.Args[0])
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "list":
+		err = policyList(args[1:])
+	case "add", "remove":
+		err = policyChange(args[0], args[1:])
+	case "test":
+		err = policyTest(args[1:])
+	case "explain":
+		err = policyExplain(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown policy command: %s\n", args[0])
+		fmt.Fprintf(os.Stderr, policyUsage, os.Args[0])
+		return 2
+	}
+	return exitCode(err)
+}
+
+func newPolicyManager() (*bootstrap.PolicyManager, error) {
+	var (
+		cfg types.AppConfig
+		db  *bootstrap.DBToolKit
+	)
+	if err := populate([]fx.Option{bootstrap.DBModule}, &cfg, &db); err != nil {
+		return nil, err
+	}
+	author, err := bootstrap.NewAuthorizerWithDB(cfg.Middlewares.Auth.ModelFile, cfg.Middlewares.Auth.TableName, db)
+	if err != nil {
+		return nil, err
+	}
+	return bootstrap.NewPolicyManager(author, db), nil
+}
+
+func policyList(args []string) error {
+	fs := newConfigFlagSet("policy list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	pm, err := newPolicyManager()
+	if err != nil {
+		return err
+	}
+	return bootstrap.WritePolicyCSV(os.Stdout, pm.Rules(bootstrap.PolicyFilter{}))
+}
+
+func policyChange(action string, args []string) error {
+	fs := newConfigFlagSet("policy " + action)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return errors.New("usage: policy " + action + " p <sub> <obj> <act> | g <user> <role>")
+	}
+	rule := bootstrap.PolicyRule{PType: fs.Arg(0), Rule: fs.Args()[1:]}
+
+	pm, err := newPolicyManager()
+	if err != nil {
+		return err
+	}
+
+	var n int
+	if action == "add" {
+		n, err = pm.Add(context.Background(), policyActor, rule)
+	} else {
+		n, err = pm.Remove(context.Background(), policyActor, rule)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case n == 0 && action == "add":
+		fmt.Println("Rule already exists: " + rule.String())
+	case n == 0:
+		fmt.Println("Rule not found: " + rule.String())
+	default:
+		fmt.Printf("Rule %sed: %s\n", strings.TrimSuffix(action, "e"), rule.String())
+	}
+	return nil
+}
+
+func policyTest(args []string) error {
+	fs := newConfigFlagSet("policy test")
+	modelFile := fs.String("model", "", "model file, the one of the suite or the config by default")
+	policyFile := fs.String("policy", "", "policy file, the one of the suite or the database by default")
+	all := fs.Bool("v", false, "show the passed cases too")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: policy test [-model file] [-policy file] [-v] <suite.yaml>")
+	}
+
+	suite, err := bootstrap.LoadPolicySuite(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *modelFile == "" {
+		*modelFile = suite.Model
+	}
+	if *policyFile == "" {
+		*policyFile = suite.Policy
+	}
+	author, err := loadAuthorizer(*modelFile, *policyFile)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range suite.Run(author) {
+		if result.Passed() {
+			if *all {
+				fmt.Printf("PASS %s\n", result.Name)
+			}
+			continue
+		}
+
+		// a diff of the expected and the actual decision
+		failed++
+		fmt.Printf("FAIL %s\n", result.Name)
+		fmt.Printf("  request: %s\n", strings.Join(result.Request, ", "))
+		fmt.Printf("- %s\n", decision(result.Allow))
+		if result.Err != nil {
+			fmt.Printf("+ error: %s\n", result.Err)
+			continue
+		}
+		fmt.Printf("+ %s\n", decision(result.Allowed))
+		fmt.Printf("  matched: %s\n", matched(result.Matched))
+	}
+
+	total := len(suite.Cases)
+	fmt.Printf("%d passed, %d failed\n", total-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d cases failed", failed, total)
+	}
+	return nil
+}
+
+func policyExplain(args []string) error {
+	fs := newConfigFlagSet("policy explain")
+	modelFile := fs.String("model", "", "model file, the one of the config by default")
+	policyFile := fs.String("policy", "", "policy file, the database by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("usage: policy explain [-model file] [-policy file] <sub> <obj> <act>")
+	}
+
+	author, err := loadAuthorizer(*modelFile, *policyFile)
+	if err != nil {
+		return err
+	}
+	allowed, rule, err := author.Explain(fs.Args()...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s by %s\n", decision(allowed), matched(rule))
+	return nil
+}
+
+// loadAuthorizer loads the model and the policy from the files, or from the config and the database if not given
+func loadAuthorizer(modelFile, policyFile string) (*bootstrap.Authorizer, error) {
+	if modelFile != "" && policyFile != "" {
+		return bootstrap.NewAuthorizerWithFiles(modelFile, policyFile)
+	}
+
+	var (
+		cfg types.AppConfig
+		db  *bootstrap.DBToolKit
+	)
+	modules := []fx.Option{}
+	targets := []interface{}{&cfg}
+	if policyFile == "" {
+		modules, targets = append(modules, bootstrap.DBModule), append(targets, &db)
+	}
+	if err := populate(modules, targets...); err != nil {
+		return nil, err
+	}
+	if modelFile == "" {
+		modelFile = cfg.Middlewares.Auth.ModelFile
+	}
+	if policyFile != "" {
+		return bootstrap.NewAuthorizerWithFiles(modelFile, policyFile)
+	}
+	return bootstrap.NewAuthorizerWithDB(modelFile, cfg.Middlewares.Auth.TableName, db)
+}
+
+func decision(allowed bool) string {
+	if allowed {
+		return "allow"
+	}
+	return "deny"
+}
+
+func matched(rule *bootstrap.PolicyRule) string {
+	if rule == nil {
+		return "no matching rule"
+	}
+	return rule.String()
+}
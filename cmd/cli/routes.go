@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/fx"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/handler"
+)
+
+func runRoutesCommand(args []string) int {
+	fs := newConfigFlagSet("routes")
+	if err := fs.Parse(args); err != nil {
+		return exitCode(err)
+	}
+
+	if !verbose {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	// the application is built but never started, so redis is not connected
+	var app *bootstrap.Application
+	modules := []fx.Option{
+		bootstrap.DBModule, bootstrap.RedisModule, bootstrap.SentryModule, bootstrap.ServerModule, bootstrap.AuthModule, bootstrap.MailerModule,
+		handler.Module,
+	}
+	if err := populate(modules, &app); err != nil {
+		return exitCode(err)
+	}
+
+	for _, route := range app.Routes() {
+		fmt.Printf("%-8s %-40s %s\n", route.Method, route.Path, route.Handler)
+	}
+	return 0
+}
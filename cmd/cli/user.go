@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/fx"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/utility"
+)
+
+const userUsage = `Usage: %[1]s user <command> [options] <arguments>
+
+Commands:
+  add [-email e] [-password p] <username>  add a user
+  list [-tenant t]                          list all users and their roles
+  passwd [-password p] <username>           change the password of a user
+  delete <username>                         delete a user
+  role add [-tenant t] <username> <role>    assign a role to a user
+  role remove [-tenant t] <username> <role> revoke a role from a user
+  mfa require|optional <role>               require MFA of the users of a role, or make it optional
+  mfa reset <username>                      turn MFA of a user off, e.g. if the device is lost
+
+The password is read from stdin if -password is not specified. The roles are those of the tenant given by -tenant,
+or of no tenant if the deployment serves a single one.
+`
+
+// runUserCommand handles `user` sub-commands and returns the exit code
+func runUserCommand(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		fmt.Fprintf(os.Stderr, userUsage, os.Args[0])
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "add":
+		err = userAdd(args[1:])
+	case "list":
+		err = userList(args[1:])
+	case "passwd":
+		err = userPasswd(args[1:])
+	case "delete":
+		err = userDelete(args[1:])
+	case "role":
+		err = userRole(args[1:])
+	case "mfa":
+		err = userMFA(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown user command: %s\n", args[0])
+		fmt.Fprintf(os.Stderr, userUsage, os.Args[0])
+		return 2
+	}
+	return exitCode(err)
+}
+
+// parseUserArgs parses the options of a user command and checks the number of arguments
+func parseUserArgs(fs *flag.FlagSet, args []string, names ...string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != len(names) {
+		return fmt.Errorf("usage: %s [options] <%s>", fs.Name(), strings.Join(names, "> <"))
+	}
+	return nil
+}
+
+func newUserManager() (*bootstrap.UserManager, error) {
+	var db *bootstrap.DBToolKit
+	if err := populate([]fx.Option{bootstrap.DBModule}, &db); err != nil {
+		return nil, err
+	}
+	return bootstrap.NewUserManager(db), nil
+}
+
+func newMFAManager() (*bootstrap.MFAManager, error) {
+	var db *bootstrap.DBToolKit
+	if err := populate([]fx.Option{bootstrap.DBModule}, &db); err != nil {
+		return nil, err
+	}
+	return bootstrap.NewMFAManager(db, ""), nil
+}
+
+// tenantContext returns the context of the tenant, the roles of users are assigned per tenant
+func tenantContext(tenant string) (context.Context, error) {
+	if tenant != "" && !bootstrap.ValidTenant(tenant) {
+		return nil, fmt.Errorf("invalid tenant %q", tenant)
+	}
+	return utility.NewTenant(context.Background(), tenant), nil
+}
+
+// readPassword returns the password option, or reads it from stdin to keep it out of the shell history
+func readPassword(password string) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	if line = strings.TrimRight(line, "\r\n"); line == "" {
+		return "", errors.New("empty password")
+	}
+	return line, nil
+}
+
+func userAdd(args []string) error {
+	fs := newConfigFlagSet("user add")
+	email := fs.String("email", "", "email of the user")
+	password := fs.String("password", "", "password of the user")
+	if err := parseUserArgs(fs, args, "username"); err != nil {
+		return err
+	}
+
+	um, err := newUserManager()
+	if err != nil {
+		return err
+	}
+	pwd, err := readPassword(*password)
+	if err != nil {
+		return err
+	}
+	if err = um.CreateUser(context.Background(), fs.Arg(0), pwd, *email); err != nil {
+		return err
+	}
+
+	fmt.Println("User " + fs.Arg(0) + " added")
+	return nil
+}
+
+func userList(args []string) error {
+	fs := newConfigFlagSet("user list")
+	tenant := fs.String("tenant", "", "tenant of the roles")
+	if err := parseUserArgs(fs, args); err != nil {
+		return err
+	}
+	ctx, err := tenantContext(*tenant)
+	if err != nil {
+		return err
+	}
+
+	um, err := newUserManager()
+	if err != nil {
+		return err
+	}
+	users, err := um.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-6s %-24s %-32s %s\n", "ID", "USERNAME", "EMAIL", "ROLES")
+	for _, user := range users {
+		roles, err := um.UserRoles(ctx, user.Username)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-6d %-24s %-32s %s\n", user.ID, user.Username, user.Email, strings.Join(roles, ","))
+	}
+	return nil
+}
+
+func userPasswd(args []string) error {
+	fs := newConfigFlagSet("user passwd")
+	password := fs.String("password", "", "new password of the user")
+	if err := parseUserArgs(fs, args, "username"); err != nil {
+		return err
+	}
+
+	um, err := newUserManager()
+	if err != nil {
+		return err
+	}
+	pwd, err := readPassword(*password)
+	if err != nil {
+		return err
+	}
+	if err = um.SetPassword(context.Background(), fs.Arg(0), pwd); err != nil {
+		return err
+	}
+
+	fmt.Println("Password of " + fs.Arg(0) + " changed")
+	return nil
+}
+
+func userDelete(args []string) error {
+	fs := newConfigFlagSet("user delete")
+	if err := parseUserArgs(fs, args, "username"); err != nil {
+		return err
+	}
+
+	um, err := newUserManager()
+	if err != nil {
+		return err
+	}
+	if err = um.DeleteUser(context.Background(), fs.Arg(0)); err != nil {
+		return err
+	}
+
+	fmt.Println("User " + fs.Arg(0) + " deleted")
+	return nil
+}
+
+func userRole(args []string) error {
+	if len(args) == 0 || (args[0] != "add" && args[0] != "remove") {
+		return errors.New("usage: user role add|remove <username> <role>")
+	}
+
+	fs := newConfigFlagSet("user role " + args[0])
+	tenant := fs.String("tenant", "", "tenant of the role")
+	if err := parseUserArgs(fs, args[1:], "username", "role"); err != nil {
+		return err
+	}
+	ctx, err := tenantContext(*tenant)
+	if err != nil {
+		return err
+	}
+
+	um, err := newUserManager()
+	if err != nil {
+		return err
+	}
+	if args[0] == "add" {
+		if err = um.AddRole(ctx, fs.Arg(0), fs.Arg(1)); err == nil {
+			fmt.Printf("Role %s assigned to %s\n", fs.Arg(1), fs.Arg(0))
+		}
+	} else {
+		if err = um.RemoveRole(ctx, fs.Arg(0), fs.Arg(1)); err == nil {
+			fmt.Printf("Role %s revoked from %s\n", fs.Arg(1), fs.Arg(0))
+		}
+	}
+	return err
+}
+
+func userMFA(args []string) error {
+	if len(args) == 0 || (args[0] != "require" && args[0] != "optional" && args[0] != "reset") {
+		return errors.New("usage: user mfa require|optional <role> or user mfa reset <username>")
+	}
+
+	fs := newConfigFlagSet("user mfa " + args[0])
+	name := "role"
+	if args[0] == "reset" {
+		name = "username"
+	}
+	if err := parseUserArgs(fs, args[1:], name); err != nil {
+		return err
+	}
+
+	mfa, err := newMFAManager()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "require":
+		if err = mfa.SetRoleRequired(context.Background(), fs.Arg(0), true); err == nil {
+			fmt.Printf("MFA required of role %s, its users enroll on their next login\n", fs.Arg(0))
+		}
+	case "optional":
+		if err = mfa.SetRoleRequired(context.Background(), fs.Arg(0), false); err == nil {
+			fmt.Printf("MFA optional for role %s\n", fs.Arg(0))
+		}
+	default:
+		if err = mfa.Disable(context.Background(), fs.Arg(0)); err == nil {
+			fmt.Println("MFA of " + fs.Arg(0) + " reset")
+		}
+	}
+	return err
+}
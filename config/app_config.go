@@ -5,6 +5,9 @@ import "github.com/robinmin/gin-starter/pkg/bootstrap/types"
 const (
 	AppName    = "gin-stater"
 	AppVersion = "0.0.1"
+
+	// prefix of environment variables overriding config items, e.g. APP_BASIC_REDIS_PASSWORD
+	EnvPrefix = "APP"
 )
 
 type MyAppConfig struct {
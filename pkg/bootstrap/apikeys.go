@@ -0,0 +1,244 @@
+package bootstrap
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/robinmin/gin-starter/pkg/internal/dbo"
+	"github.com/robinmin/gin-starter/pkg/utility"
+)
+
+const (
+	// APIKeyPrefix starts every API key, so that they are told from access tokens, e.g. gsk_<prefix>_<secret>
+	APIKeyPrefix = "gsk_"
+
+	// the last use of a key is recorded at most once per interval
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey  = errors.New("invalid, expired or revoked API key")
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+// APIKey is a credential of a machine client, it acts as its user with the roles of the user
+type APIKey struct {
+	ID         int
+	Username   string
+	Name       string
+	Prefix     string
+	Scopes     []string
+	RateLimit  int // requests per minute, 0 for unlimited
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  *time.Time
+
+	Roles []string // roles of the user, only set by Authenticate
+}
+
+// Active reports whether the key is neither revoked nor expired
+func (key *APIKey) Active(now time.Time) bool {
+	return key.RevokedAt == nil && (key.ExpiresAt == nil || now.Before(*key.ExpiresAt))
+}
+
+// APIKeyOptions are the optional settings of a new key
+type APIKeyOptions struct {
+	Scopes    []string
+	RateLimit int
+	TTL       time.Duration // never expires if 0
+}
+
+// APIKeyManager issues and verifies the API keys, only their hashes are stored
+type APIKeyManager struct {
+	db       *DBToolKit
+	limiters sync.Map // key ID -> *rate.Limiter
+}
+
+func NewAPIKeyManager(db *DBToolKit) *APIKeyManager {
+	return &APIKeyManager{db: db}
+}
+
+// Create issues a key for the user, the returned key is shown once and can't be recovered
+func (km *APIKeyManager) Create(ctx context.Context, username, name string, opts APIKeyOptions) (string, *APIKey, error) {
+	q := km.db.Queries()
+	user, err := q.GetUserByUsername(ctx, username)
+	if err != nil {
+		return "", nil, notFound(err, ErrUserNotFound, username)
+	}
+
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err = rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	if _, err = rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	prefix := hex.EncodeToString(id)
+	key := APIKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	var expiresAt *time.Time
+	if opts.TTL > 0 {
+		t := time.Now().Add(opts.TTL).UTC()
+		expiresAt = &t
+	}
+	err = q.CreateAPIKey(ctx, dbo.CreateAPIKeyParams{
+		UserID:    user.ID,
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hashAPIKey(key),
+		Scopes:    strings.Join(opts.Scopes, " "),
+		RateLimit: int64(opts.RateLimit),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return key, &APIKey{
+		Username:  username,
+		Name:      name,
+		Prefix:    prefix,
+		Scopes:    opts.Scopes,
+		RateLimit: opts.RateLimit,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// List returns all keys including the revoked and expired ones
+func (km *APIKeyManager) List(ctx context.Context) ([]APIKey, error) {
+	rows, err := km.db.Queries().ListAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]APIKey, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, newAPIKey(dbo.GetAPIKeyByPrefixRow(row)))
+	}
+	return keys, nil
+}
+
+// Revoke invalidates the key with the prefix
+func (km *APIKeyManager) Revoke(ctx context.Context, prefix string) error {
+	n, err := km.db.Queries().RevokeAPIKey(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", ErrAPIKeyNotFound, prefix)
+	}
+	return nil
+}
+
+// Authenticate returns the active key with the roles of its user in the tenant of the context, or ErrInvalidAPIKey
+func (km *APIKeyManager) Authenticate(ctx context.Context, key string) (*APIKey, error) {
+	prefix, ok := parseAPIKey(key)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	q := km.db.Queries()
+	row, err := q.GetAPIKeyByPrefix(ctx, prefix)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(row.Hash), []byte(hashAPIKey(key))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	apiKey := newAPIKey(row)
+	now := time.Now()
+	if !apiKey.Active(now) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyTouchInterval {
+		t := now.UTC()
+		if err = q.TouchAPIKey(ctx, &t, row.ID); err != nil {
+			return nil, err
+		}
+		apiKey.LastUsedAt = &t
+	}
+
+	names, err := q.GetRoleNamesByUsername(ctx, row.Username, utility.FromTenant(ctx))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if name != nil {
+			apiKey.Roles = append(apiKey.Roles, *name)
+		}
+	}
+	return &apiKey, nil
+}
+
+// Allow reports whether a request of the key may proceed now by its rate limit, or how long to wait if not
+func (km *APIKeyManager) Allow(key *APIKey) (bool, time.Duration) {
+	if key.RateLimit <= 0 {
+		return true, 0
+	}
+
+	limit := rate.Every(time.Minute / time.Duration(key.RateLimit))
+	v, _ := km.limiters.LoadOrStore(key.ID, rate.NewLimiter(limit, key.RateLimit))
+	limiter := v.(*rate.Limiter)
+	if limiter.Limit() != limit {
+		limiter.SetLimit(limit)
+		limiter.SetBurst(key.RateLimit)
+	}
+
+	r := limiter.Reserve()
+	if delay := r.Delay(); delay > 0 {
+		r.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// parseAPIKey returns the prefix of the key
+func parseAPIKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(strings.TrimPrefix(key, APIKeyPrefix), "_")
+	return prefix, ok && prefix != "" && secret != ""
+}
+
+// hashAPIKey hashes the key by SHA-256, which suffices for random keys unlike passwords
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func newAPIKey(row dbo.GetAPIKeyByPrefixRow) APIKey {
+	return APIKey{
+		ID:         int(row.ID),
+		Username:   row.Username,
+		Name:       row.Name,
+		Prefix:     row.Prefix,
+		Scopes:     strings.Fields(row.Scopes),
+		RateLimit:  int(row.RateLimit),
+		ExpiresAt:  row.ExpiresAt,
+		LastUsedAt: row.LastUsedAt,
+		RevokedAt:  row.RevokedAt,
+		CreatedAt:  row.CreatedAt,
+	}
+}
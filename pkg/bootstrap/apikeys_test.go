@@ -0,0 +1,67 @@
+package bootstrap_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+)
+
+func TestAPIKeyManager(t *testing.T) {
+	db := newTestDB(t, true)
+	ctx := context.Background()
+	um := bootstrap.NewUserManager(db)
+	require.NoError(t, um.CreateUser(ctx, "svc", "secret", "svc@example.com"))
+	require.NoError(t, um.AddRole(ctx, "svc", "reader"))
+
+	km := bootstrap.NewAPIKeyManager(db)
+	_, _, err := km.Create(ctx, "nobody", "ci", bootstrap.APIKeyOptions{})
+	assert.ErrorIs(t, err, bootstrap.ErrUserNotFound)
+
+	key, created, err := km.Create(ctx, "svc", "ci", bootstrap.APIKeyOptions{Scopes: []string{"orders:read"}, RateLimit: 2})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, bootstrap.APIKeyPrefix+created.Prefix+"_"))
+
+	apiKey, err := km.Authenticate(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "svc", apiKey.Username)
+	assert.Equal(t, []string{"orders:read"}, apiKey.Scopes)
+	assert.Equal(t, []string{"reader"}, apiKey.Roles)
+	assert.NotNil(t, apiKey.LastUsedAt)
+
+	// only the hash is stored
+	keys, err := km.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.NotNil(t, keys[0].LastUsedAt)
+
+	for _, bogus := range []string{"", "gsk_", created.Prefix, key + "x", bootstrap.APIKeyPrefix + "unknown_secret"} {
+		_, err = km.Authenticate(ctx, bogus)
+		assert.ErrorIs(t, err, bootstrap.ErrInvalidAPIKey, bogus)
+	}
+
+	// 2 requests per minute
+	ok, _ := km.Allow(apiKey)
+	assert.True(t, ok)
+	ok, _ = km.Allow(apiKey)
+	assert.True(t, ok)
+	ok, wait := km.Allow(apiKey)
+	assert.False(t, ok)
+	assert.Greater(t, wait, 20*time.Second)
+
+	require.NoError(t, km.Revoke(ctx, created.Prefix))
+	_, err = km.Authenticate(ctx, key)
+	assert.ErrorIs(t, err, bootstrap.ErrInvalidAPIKey)
+	assert.ErrorIs(t, km.Revoke(ctx, created.Prefix), bootstrap.ErrAPIKeyNotFound)
+
+	expiring, _, err := km.Create(ctx, "svc", "temp", bootstrap.APIKeyOptions{TTL: time.Millisecond})
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	_, err = km.Authenticate(ctx, expiring)
+	assert.ErrorIs(t, err, bootstrap.ErrInvalidAPIKey)
+}
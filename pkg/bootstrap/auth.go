@@ -3,27 +3,30 @@ package bootstrap
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/casbin/casbin/v2"
-	"github.com/gin-contrib/authz"
 	"github.com/gin-gonic/gin"
-	"github.com/jmoiron/sqlx"
+	"github.com/gomodule/redigo/redis"
 	cadapter "github.com/memwey/casbin-sqlx-adapter"
 	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
-	"golang.org/x/crypto/bcrypt"
-
-	"github.com/robinmin/gin-starter/pkg/internal/dbo"
-	_ "github.com/robinmin/gin-starter/pkg/internal/dbo"
+	"github.com/robinmin/gin-starter/pkg/utility"
 )
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 // 用户结构
 type User struct {
-	ID           int
-	Username     string
-	PasswordHash string
-	Email        string
+	ID              int
+	Username        string
+	PasswordHash    string
+	Email           string
+	EmailVerifiedAt *time.Time // nil until the email is verified
 }
 
 // 角色结构
@@ -40,53 +43,269 @@ type Permission struct {
 	Description string
 }
 
+// 认证事件, reported through AppSentry.ReportEvent
+const (
+	EVT_AUTH_LOGIN_SUCCEEDED types.UserDefinedEvent = 1000 + iota
+	EVT_AUTH_LOGIN_FAILED
+	EVT_AUTH_LOCKED
+	EVT_AUTH_PASSWORD_REHASHED
+	EVT_AUTH_REFRESH_REUSED
+)
+
+// AuthEventsMeta describes the authentication events, the entries in sentry EventsMeta take precedence
+var AuthEventsMeta = types.UserDefinedEventMap{
+	EVT_AUTH_LOGIN_SUCCEEDED:   types.UserDefinedEventMeta{Name: "evt_auth_login_succeeded", Level: "info", Group: "auth"},
+	EVT_AUTH_LOGIN_FAILED:      types.UserDefinedEventMeta{Name: "evt_auth_login_failed", Level: "warn", Group: "auth"},
+	EVT_AUTH_LOCKED:            types.UserDefinedEventMeta{Name: "evt_auth_locked", Level: "warn", Group: "auth"},
+	EVT_AUTH_PASSWORD_REHASHED: types.UserDefinedEventMeta{Name: "evt_auth_password_rehashed", Level: "debug", Group: "auth"},
+	EVT_AUTH_REFRESH_REUSED:    types.UserDefinedEventMeta{Name: "evt_auth_refresh_reused", Level: "error", Group: "auth"},
+}
+
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// LockedError tells that the user or the client IP is locked after too many failed logins
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed logins, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
 // 认证器
 type Authenticator struct {
-	db *DBToolKit
+	db       *DBToolKit
+	cfg      types.AppConfig
+	hasher   *PasswordHasher
+	attempts attemptStore
+	sentry   *AppSentry
+	logger   *AppLogger
+
+	// hash verified for unknown users, so that they take as long as the known ones
+	dummyOnce sync.Once
+	dummyHash string
 }
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-// NewAuthenticator 创建认证器
-func NewAuthenticator(db *DBToolKit) *Authenticator {
+// NewAuthenticator 创建认证器, failed logins are counted in redis, or in memory if rds is nil
+func NewAuthenticator(cfg types.AppConfig, db *DBToolKit, rds *RedisPool, sty *AppSentry, logger *AppLogger) *Authenticator {
+	var attempts attemptStore = newMemoryAttemptStore()
+	if rds != nil {
+		attempts = &redisAttemptStore{pool: (*redis.Pool)(rds)}
+	}
+
 	return &Authenticator{
-		db: db,
+		db:       db,
+		cfg:      cfg,
+		hasher:   NewPasswordHasher(cfg),
+		attempts: attempts,
+		sentry:   sty,
+		logger:   logger,
 	}
 }
 
+// Hasher returns the hasher of new passwords
+func (a *Authenticator) Hasher() *PasswordHasher {
+	return a.hasher
+}
+
 // Authenticate 用户认证
 func (a *Authenticator) Authenticate(username, password string) (bool, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	_, err := a.Login(context.Background(), username, password, "")
+	if errors.Is(err, ErrInvalidCredentials) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Login verifies the password of the user logging in from the client IP, which may be empty. It returns
+// ErrInvalidCredentials if the user does not exist or the password is wrong, and a *LockedError if the user or the IP
+// failed too often. The stored hash is upgraded if it does not match the configured algorithm or parameters.
+func (a *Authenticator) Login(ctx context.Context, username, password, ip string) (*User, error) {
+	keys := a.lockKeys(username, ip)
+	if err := a.checkLocked(ctx, keys); err != nil {
+		return nil, err
+	}
+
+	row, err := a.db.Queries().GetUserByUsername(ctx, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		a.dummyOnce.Do(func() {
+			a.dummyHash, _ = a.hasher.Hash("dummy password")
+		})
+		_, _, _ = a.hasher.Verify(a.dummyHash, password)
+		return nil, a.fail(ctx, username, ip, keys)
+	}
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	// users of external providers login there
+	if row.Password == NoPassword {
+		return nil, a.fail(ctx, username, ip, keys)
 	}
-	q := dbo.New(a.db)
-	_, err = q.GetValidUserInfo(context.Background(), username, string(hashedPassword))
+
+	ok, rehash, err := a.hasher.Verify(row.Password, password)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return false, nil
+		return nil, err
+	}
+	if !ok {
+		return nil, a.fail(ctx, username, ip, keys)
+	}
+
+	if a.cfg.Middlewares.Auth.Lockout.Enable {
+		if err = a.attempts.Reset(ctx, keys[0]); err != nil {
+			a.logger.Warn("Failed to reset failed logins: " + err.Error())
+		}
+	}
+	if rehash {
+		a.rehash(ctx, username, password)
+	}
+
+	a.report(EVT_AUTH_LOGIN_SUCCEEDED, "login succeeded", map[string]interface{}{"username": username, "ip": ip})
+	return &User{
+		ID:              int(row.ID),
+		Username:        row.Username,
+		PasswordHash:    row.Password,
+		Email:           row.Email,
+		EmailVerifiedAt: row.EmailVerifiedAt,
+	}, nil
+}
+
+func (a *Authenticator) lockKeys(username, ip string) []string {
+	keys := []string{"user:" + username}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+func (a *Authenticator) checkLocked(ctx context.Context, keys []string) error {
+	if !a.cfg.Middlewares.Auth.Lockout.Enable {
+		return nil
+	}
+
+	for _, key := range keys {
+		d, err := a.attempts.LockedFor(ctx, key)
+		if err != nil {
+			// do not lock everyone out if redis is unavailable
+			a.logger.Warn("Failed to check login lock: " + err.Error())
+			continue
+		}
+		if d > 0 {
+			return &LockedError{RetryAfter: d}
+		}
+	}
+	return nil
+}
+
+// fail counts the failed login and locks the user or the IP exceeding their limits
+func (a *Authenticator) fail(ctx context.Context, username, ip string, keys []string) error {
+	lockout := a.cfg.Middlewares.Auth.Lockout
+	payload := map[string]interface{}{"username": username, "ip": ip}
+	a.report(EVT_AUTH_LOGIN_FAILED, "login failed", payload)
+	if !lockout.Enable {
+		return ErrInvalidCredentials
+	}
+
+	for _, key := range keys {
+		limit := lockout.MaxAttempts
+		if key != keys[0] {
+			limit = lockout.MaxAttemptsPerIP
 		}
-		return false, err
+		a.count(ctx, key, key, limit, payload)
+	}
+	return ErrInvalidCredentials
+}
+
+// FailSecondFactor counts an invalid second factor of the user. The user is locked after too many of them like after
+// too many failed logins, it returns a *LockedError then. The successful logins do not reset the count.
+func (a *Authenticator) FailSecondFactor(ctx context.Context, username, ip string) error {
+	lockout := a.cfg.Middlewares.Auth.Lockout
+	payload := map[string]interface{}{"username": username, "ip": ip}
+	a.report(EVT_AUTH_LOGIN_FAILED, "invalid second factor", payload)
+	if !lockout.Enable {
+		return nil
 	}
-	// TODO: cache user information
-	return true, nil
+
+	a.count(ctx, "mfa:"+username, "user:"+username, lockout.MaxAttempts, payload)
+	return a.checkLocked(ctx, a.lockKeys(username, ""))
+}
+
+// SecondFactorLocked returns a *LockedError if the user is locked, so that no second factor is verified for it
+func (a *Authenticator) SecondFactorLocked(ctx context.Context, username string) error {
+	return a.checkLocked(ctx, a.lockKeys(username, ""))
+}
+
+// SecondFactorSucceeded resets the count of the invalid second factors of the user
+func (a *Authenticator) SecondFactorSucceeded(ctx context.Context, username string) {
+	if !a.cfg.Middlewares.Auth.Lockout.Enable {
+		return
+	}
+	if err := a.attempts.Reset(ctx, "mfa:"+username); err != nil {
+		a.logger.Warn("Failed to reset invalid second factors: " + err.Error())
+	}
+}
+
+// count counts a failure of the key, and locks lockKey once the failures reach the limit, doubling the backoff for
+// each further failure
+func (a *Authenticator) count(ctx context.Context, key, lockKey string, limit int, payload map[string]interface{}) {
+	lockout := a.cfg.Middlewares.Auth.Lockout
+	n, err := a.attempts.Fail(ctx, key, lockout.Window)
+	if err != nil {
+		a.logger.Warn("Failed to count failed logins: " + err.Error())
+		return
+	}
+	if n < limit {
+		return
+	}
+
+	d := lockout.Backoff
+	for i := limit; i < n && d < lockout.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > lockout.MaxBackoff {
+		d = lockout.MaxBackoff
+	}
+	if err = a.attempts.Lock(ctx, lockKey, d); err != nil {
+		a.logger.Warn("Failed to lock " + lockKey + ": " + err.Error())
+		return
+	}
+	a.report(EVT_AUTH_LOCKED, "locked "+lockKey+" for "+d.String(), payload)
+}
+
+func (a *Authenticator) rehash(ctx context.Context, username, password string) {
+	hashed, err := a.hasher.Hash(password)
+	if err == nil {
+		_, err = a.db.Queries().UpdateUserPassword(ctx, hashed, username)
+	}
+	if err != nil {
+		a.logger.Warn("Failed to rehash password of " + username + ": " + err.Error())
+		return
+	}
+	a.report(EVT_AUTH_PASSWORD_REHASHED, "password rehashed", map[string]interface{}{"username": username, "algorithm": a.hasher.Algorithm})
+}
+
+func (a *Authenticator) report(event types.UserDefinedEvent, message string, payload map[string]interface{}) {
+	if a.sentry != nil {
+		a.sentry.ReportEvent(event, message, payload)
+	}
+}
+
+// HashPassword 生成用于保存的密码哈希
+func HashPassword(password string) (string, error) {
+	return DefaultPasswordHasher.Hash(password)
 }
 
 // /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 // 授权器
 type Authorizer struct {
-	enforcer *casbin.Enforcer
+	enforcer   *casbin.SyncedEnforcer // the policy is changed while the requests are authorized
+	pathPrefix string                 // of the tenants in the paths, stripped from the objects, see TenantResolverPath
 }
 
 func NewAuthorizer(cfg types.AppConfig, logger *AppLogger) (*Authorizer, error) {
-	param := DBParams(types.AppDBConfig{
-		Type:     cfg.Database.Type,
-		Host:     cfg.Database.Host,
-		Port:     cfg.Database.Port,
-		Database: cfg.Database.Database,
-		User:     cfg.Database.User,
-		Password: cfg.Database.Password,
-	})
+	param := DBParams(cfg.Database)
 	connection_str, err := param.GetDSN()
 	if err != nil {
 		logger.Error("Failed to get DB connection string: " + err.Error())
@@ -94,7 +313,7 @@ func NewAuthorizer(cfg types.AppConfig, logger *AppLogger) (*Authorizer, error)
 	}
 
 	opts := &cadapter.AdapterOptions{
-		DriverName:     cfg.Database.Type,
+		DriverName:     param.DriverName(),
 		DataSourceName: connection_str,
 		TableName:      cfg.Middlewares.Auth.TableName,
 		// or reuse an existing connection:
@@ -102,39 +321,157 @@ func NewAuthorizer(cfg types.AppConfig, logger *AppLogger) (*Authorizer, error)
 	}
 
 	// Casbin v2 may return an error
-	enfcer, err := casbin.NewEnforcer(cfg.Middlewares.Auth.ModelFile, cadapter.NewAdapterFromOptions(opts))
+	enfcer, err := casbin.NewSyncedEnforcer(cfg.Middlewares.Auth.ModelFile, cadapter.NewAdapterFromOptions(opts))
 	if err != nil {
 		logger.Error("Failed to get DB connection string: " + err.Error())
 		return nil, err
 	}
-	return &Authorizer{enforcer: enfcer}, err
+
+	author := &Authorizer{enforcer: enfcer}
+	if tenant := cfg.Middlewares.Auth.Tenant; tenant.Enable && tenant.Resolver == TenantResolverPath {
+		author.pathPrefix = tenant.PathPrefix
+	}
+	return author, err
 }
 
-func NewAuthorizerWithDB(model_file string, dbkit *DBToolKit) (*Authorizer, error) {
+func NewAuthorizerWithDB(model_file string, table_name string, dbkit *DBToolKit) (author *Authorizer, err error) {
+	// the adapter panics if the policy table does not exist
+	defer func() {
+		if r := recover(); r != nil {
+			author, err = nil, fmt.Errorf("failed to open policy table %s: %v", table_name, r)
+		}
+	}()
+
 	opts := &cadapter.AdapterOptions{
-		DB: (*sqlx.DB)(dbkit),
+		DB:        dbkit.DB,
+		TableName: table_name,
 	}
 
 	// Casbin v2 may return an error
-	enfcer, err := casbin.NewEnforcer(model_file, cadapter.NewAdapterFromOptions(opts))
+	enfcer, err := casbin.NewSyncedEnforcer(model_file, cadapter.NewAdapterFromOptions(opts))
 	return &Authorizer{enforcer: enfcer}, err
 }
 
-// HasPermission 检查用户是否拥有权限
-func (author *Authorizer) HasPermission(user string, permission string) bool {
-	result, err := author.enforcer.Enforce(user, permission, "*")
+// NewAuthorizerWithFiles loads the model and the policy from the files, e.g. to test them offline. The policy file is in
+// the CSV format of casbin, the policy is empty if policyFile is.
+func NewAuthorizerWithFiles(modelFile string, policyFile string) (*Authorizer, error) {
+	params := []interface{}{modelFile}
+	if policyFile != "" {
+		params = append(params, policyFile)
+	}
+	enfcer, err := casbin.NewSyncedEnforcer(params...)
+	if err != nil {
+		return nil, err
+	}
+	return &Authorizer{enforcer: enfcer}, nil
+}
+
+// Enforcer returns the underlying casbin enforcer, it is not synchronized with the authorization of the requests, see
+// PolicyManager to change the policy of a running application
+func (author *Authorizer) Enforcer() *casbin.Enforcer {
+	return author.enforcer.Enforcer
+}
+
+// Domains reports whether the model has domains, i.e. the requests are (sub, dom, obj, act) and the tenant of the
+// request is the domain
+func (author *Authorizer) Domains() bool {
+	r, ok := author.enforcer.GetModel()["r"]["r"]
+	return ok && len(r.Tokens) == 4
+}
+
+// HasPermission 检查用户是否拥有权限, in the tenant of the context if the model has domains
+func (author *Authorizer) HasPermission(ctx context.Context, user string, permission string) bool {
+	request := []interface{}{user, permission, "*"}
+	if author.Domains() {
+		request = []interface{}{user, utility.FromTenant(ctx), permission, "*"}
+	}
+	result, err := author.enforcer.Enforce(request...)
 	if err != nil {
 		return false
 	}
 	return result
 }
 
+// Explain decides the request, e.g. (alice, /data, GET), and returns the policy rule which decided it, nil if none
+func (author *Authorizer) Explain(request ...string) (bool, *PolicyRule, error) {
+	rvals := make([]interface{}, len(request))
+	for i, value := range request {
+		rvals[i] = value
+	}
+
+	allowed, explain, err := author.enforcer.EnforceEx(rvals...)
+	if err != nil || len(explain) == 0 {
+		return allowed, nil, err
+	}
+	return allowed, &PolicyRule{PType: "p", Rule: explain}, nil
+}
+
+// AnonymousSubject is the casbin subject of the requests without identity, e.g. "p, anonymous, /health, GET"
+const AnonymousSubject = "anonymous"
+
+// AuthorizerHandler authorizes the requests by the identity in the request context, see utility.FromUserID. The user
+// is allowed if the user or any role of the user is, and root users are always allowed. A model with domains checks
+// (sub, tenant, path, method), see utility.FromTenant. All requests are denied without an enforcer.
 func (author *Authorizer) AuthorizerHandler() gin.HandlerFunc {
-	if author.enforcer == nil {
+	if author == nil || author.enforcer == nil {
 		return func(ctx *gin.Context) {
+			NewResult(http.StatusServiceUnavailable, "授权器不可用", nil).Abort(ctx, http.StatusServiceUnavailable)
+		}
+	}
+
+	domains := author.Domains()
+	return func(ctx *gin.Context) {
+		c := ctx.Request.Context()
+		if utility.FromIsRootUser(c) {
 			ctx.Next()
+			return
+		}
+
+		subject := utility.FromUserID(c)
+		if subject == "" {
+			subject = AnonymousSubject
+		}
+		request := []interface{}{subject, ctx.Request.URL.Path, ctx.Request.Method}
+		if domains {
+			tenant := utility.FromTenant(c)
+			request = []interface{}{subject, tenant, author.object(ctx.Request.URL.Path, tenant), ctx.Request.Method}
+		}
+
+		subjects := append([]string{subject}, utility.FromUserRoles(c)...)
+		for _, sub := range subjects {
+			request[0] = sub
+			allowed, err := author.enforcer.Enforce(request...)
+			if err != nil {
+				_ = ctx.Error(err)
+				ctx.Abort()
+				return
+			}
+			if allowed {
+				ctx.Next()
+				return
+			}
+		}
+
+		// authenticating may help the anonymous
+		if subject == AnonymousSubject {
+			NewResult(http.StatusUnauthorized, "未登录", nil).Abort(ctx, http.StatusUnauthorized)
+			return
 		}
+		NewResult(http.StatusForbidden, "没有访问权限", nil).Abort(ctx, http.StatusForbidden)
 	}
+}
 
-	return authz.NewAuthorizer(author.enforcer)
+// object returns the path without the tenant segment if the tenant is resolved from the paths, e.g. /data of
+// /t/acme/data, so that the rules of all tenants name the same objects
+func (author *Authorizer) object(path string, tenant string) string {
+	if author.pathPrefix == "" || tenant == "" {
+		return path
+	}
+	if rest, ok := strings.CutPrefix(path, author.pathPrefix+tenant); ok && (rest == "" || rest[0] == '/') {
+		if rest == "" {
+			return "/"
+		}
+		return rest
+	}
+	return path
 }
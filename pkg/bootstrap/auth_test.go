@@ -0,0 +1,190 @@
+package bootstrap_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+	"github.com/robinmin/gin-starter/pkg/utility"
+)
+
+func storedHash(t *testing.T, db *bootstrap.DBToolKit, username string) string {
+	t.Helper()
+	user, err := db.Queries().GetUserByUsername(context.Background(), username)
+	require.NoError(t, err)
+	return user.Password
+}
+
+func TestAuthenticatorRehash(t *testing.T) {
+	db := newTestDB(t, true)
+	ctx := context.Background()
+	require.NoError(t, bootstrap.NewUserManager(db).CreateUser(ctx, "alice", "secret", "alice@example.com"))
+
+	cfg := *bootstrap.NewInstance[types.AppConfig]()
+	cfg.Middlewares.Auth.BcryptCost = 4
+	author := bootstrap.NewAuthenticator(cfg, db, nil, nil, bootstrap.NewAppLogger())
+
+	ok, err := author.Authenticate("alice", "secret")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(storedHash(t, db, "alice"), "$2a$04$"))
+
+	ok, err = author.Authenticate("alice", "wrong")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	cfg.Middlewares.Auth.PasswordHash = bootstrap.HashArgon2id
+	cfg.Middlewares.Auth.Argon2.Memory = 1024
+	author = bootstrap.NewAuthenticator(cfg, db, nil, nil, bootstrap.NewAppLogger())
+	user, err := author.Login(ctx, "alice", "secret", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", user.Email)
+	assert.True(t, strings.HasPrefix(storedHash(t, db, "alice"), "$argon2id$v=19$m=1024,t=1,p=2$"))
+
+	_, err = author.Login(ctx, "alice", "secret", "10.0.0.1")
+	assert.NoError(t, err)
+}
+
+func TestAuthenticatorLockout(t *testing.T) {
+	db := newTestDB(t, true)
+	ctx := context.Background()
+	require.NoError(t, bootstrap.NewUserManager(db).CreateUser(ctx, "bob", "secret", "bob@example.com"))
+
+	cfg := *bootstrap.NewInstance[types.AppConfig]()
+	cfg.Middlewares.Auth.Lockout.MaxAttempts = 3
+	cfg.Middlewares.Auth.Lockout.MaxAttemptsPerIP = 5
+	author := bootstrap.NewAuthenticator(cfg, db, nil, nil, bootstrap.NewAppLogger())
+
+	for i := 0; i < 3; i++ {
+		_, err := author.Login(ctx, "bob", "wrong", "10.0.0.1")
+		assert.ErrorIs(t, err, bootstrap.ErrInvalidCredentials)
+	}
+
+	// even the right password is rejected while locked
+	_, err := author.Login(ctx, "bob", "secret", "10.0.0.2")
+	var locked *bootstrap.LockedError
+	require.ErrorAs(t, err, &locked)
+	assert.Equal(t, cfg.Middlewares.Auth.Lockout.Backoff, locked.RetryAfter.Round(cfg.Middlewares.Auth.Lockout.Backoff))
+
+	// unknown users count for the IP as well
+	for i := 0; i < 2; i++ {
+		_, err = author.Login(ctx, "nobody", "wrong", "10.0.0.1")
+		assert.ErrorIs(t, err, bootstrap.ErrInvalidCredentials)
+	}
+	_, err = author.Login(ctx, "carol", "whatever", "10.0.0.1")
+	assert.ErrorAs(t, err, &locked)
+}
+
+func TestAuthorizerHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	author, err := bootstrap.NewAuthorizerWithDB("../../config/rbac_model.conf", "auth_rules", newTestDB(t, true))
+	require.NoError(t, err)
+	e := author.Enforcer()
+	for _, rule := range [][]string{
+		{"alice", "/orders", "GET"},
+		{"admin", "/orders", "DELETE"},
+		{bootstrap.AnonymousSubject, "/health", "GET"},
+	} {
+		_, err = e.AddPolicy(rule)
+		require.NoError(t, err)
+	}
+
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) {
+		// the identity is put by the auth middleware in the application
+		c := ctx.Request.Context()
+		if user := ctx.GetHeader("X-User"); user != "" {
+			c = utility.NewUserID(c, user)
+		}
+		if roles := ctx.GetHeader("X-Roles"); roles != "" {
+			c = utility.NewUserRoles(c, strings.Split(roles, ","))
+		}
+		if ctx.GetHeader("X-Root") != "" {
+			c = utility.NewIsRootUser(c)
+		}
+		ctx.Request = ctx.Request.WithContext(c)
+	}, author.AuthorizerHandler())
+	ok := func(ctx *gin.Context) { ctx.Status(http.StatusOK) }
+	router.GET("/orders", ok)
+	router.DELETE("/orders", ok)
+	router.GET("/health", ok)
+
+	testCases := []struct {
+		method, path string
+		user, roles  string
+		root         bool
+		expected     int
+		errorInfo    string
+	}{
+		{http.MethodGet, "/orders", "alice", "", false, http.StatusOK, "allowed to the user"},
+		{http.MethodDelete, "/orders", "alice", "", false, http.StatusForbidden, "denied to the user"},
+		{http.MethodDelete, "/orders", "alice", "viewer,admin", false, http.StatusOK, "allowed to a role of the user"},
+		{http.MethodGet, "/orders", "", "", false, http.StatusUnauthorized, "denied to the anonymous"},
+		{http.MethodGet, "/health", "", "", false, http.StatusOK, "allowed to the anonymous"},
+		{http.MethodDelete, "/orders", "bob", "", true, http.StatusOK, "root bypasses"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("X-User", tc.user)
+		req.Header.Set("X-Roles", tc.roles)
+		if tc.root {
+			req.Header.Set("X-Root", "1")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tc.expected, w.Code, tc.errorInfo)
+	}
+	// never allowed without the enforcer
+	router = gin.New()
+	router.Use((*bootstrap.Authorizer)(nil).AuthorizerHandler())
+	router.GET("/health", ok)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestApplicationRequiresAuthorizer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := *bootstrap.NewInstance[types.AppConfig]()
+	cfg.Database.Type = "sqlite3"
+	cfg.Database.Database = filepath.Join(t.TempDir(), "test.db")
+	cfg.Database.AutoMigrate = true
+	cfg.Middlewares.Static.Enable = false
+	cfg.Middlewares.Session.Enable = false
+	cfg.Middlewares.Auth.Enable = true
+	db, err := bootstrap.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	newApp := func(cfg types.AppConfig) error {
+		lc := fxtest.NewLifecycle(t)
+		watcher := bootstrap.NewConfigWatcher(cfg, &bootstrap.ConfigReloader{}, lc, bootstrap.NewAppLogger())
+		_, err := bootstrap.NewApplication(cfg, lc, nil, nil, watcher, bootstrap.NewAppLogger())
+		return err
+	}
+
+	cfg.Middlewares.Auth.ModelFile = "../../config/rbac_model.conf"
+	require.NoError(t, newApp(cfg))
+
+	// never served unauthorized
+	cfg.Middlewares.Auth.ModelFile = filepath.Join(t.TempDir(), "broken.conf")
+	require.NoError(t, os.WriteFile(cfg.Middlewares.Auth.ModelFile, []byte("[request_definition]\nr = sub, obj\n"), 0o600))
+	assert.Error(t, newApp(cfg))
+
+	// the tenants need the domains of the model
+	cfg.Middlewares.Auth.ModelFile = "../../config/rbac_model.conf"
+	cfg.Middlewares.Auth.Tenant.Enable = true
+	assert.Error(t, newApp(cfg))
+	cfg.Middlewares.Auth.ModelFile = "../../config/rbac_model_domains.conf"
+	assert.NoError(t, newApp(cfg))
+}
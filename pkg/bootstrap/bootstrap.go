@@ -2,12 +2,14 @@ package bootstrap
 
 import (
 	"context"
+	"errors"
 
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -54,6 +56,16 @@ type Application struct {
 	// DB instance
 	// DB     *database.DB
 
+	// middlewares which can be reconfigured at runtime
+	cors    atomic.Value
+	limiter *RateLimiter
+
+	// authenticates the requests before they are authorized, see UseIdentity
+	identity atomic.Value
+
+	// authorizes the requests, nil unless middlewares.auth is enabled
+	author *Authorizer
+
 	lifeCycle fx.Lifecycle
 }
 
@@ -62,12 +74,18 @@ func NewApplication(
 	lc fx.Lifecycle,
 	sty *AppSentry,
 	rds *RedisPool,
+	watcher *ConfigWatcher,
 	logger *AppLogger,
-) *Application {
+) (*Application, error) {
 	app := &Application{
 		Config: cfg.System,
 	}
 
+	// the logger is initialized before the config is loaded, its level is the one of the config from now on
+	if err := SetLogLevel(cfg.Middlewares.Log.DefaultLevel); err != nil {
+		logger.Warn("Failed to set log level: " + err.Error())
+	}
+
 	app.engine = gin.New()
 	if gin.IsDebugging() {
 		gin.ForceConsoleColor()
@@ -77,19 +95,16 @@ func NewApplication(
 	}
 	app.engine.ForwardedByClientIP = true
 
-	var err error
-	if cfg.System.TrustedProxies == "" {
-		err = app.engine.SetTrustedProxies([]string{"127.0.0.1"})
-	} else {
-		err = app.engine.SetTrustedProxies(strings.Split(cfg.System.TrustedProxies, ";"))
-	}
+	err := app.engine.SetTrustedProxies(trustedProxies(cfg))
 	if err != nil {
 		logger.Warn("Failed to set trusted proxies")
 	}
 
 	// The middleware functions are executed in the order they are defined.
+	// the application never runs without any of them, e.g. unauthorized if casbin fails to load
 	if err = app.useMiddlewares(context.Background(), cfg, rds, logger); err != nil {
 		logger.Error("Failed to enable all middlewares: " + err.Error())
+		return nil, err
 	}
 
 	app.server = NewHttpServer(app, logger)
@@ -98,7 +113,69 @@ func NewApplication(
 	// default status api
 	app.engine.GET("/status", status.GinHandler)
 
-	return app
+	watcher.Subscribe(func(change ConfigChange) {
+		app.applyConfigChange(change, logger)
+	})
+	return app, nil
+}
+
+// applyConfigChange applies the live config items to the running application
+func (app *Application) applyConfigChange(change ConfigChange, logger *AppLogger) {
+	cfg := change.New
+
+	if change.Has("middlewares.log.default_level") {
+		if err := SetLogLevel(cfg.Middlewares.Log.DefaultLevel); err != nil {
+			logger.Warn("Failed to change log level: " + err.Error())
+		}
+	}
+	if change.Has("middlewares.cors") {
+		app.cors.Store(newCORSHandler(cfg))
+	}
+	if change.Has("middlewares.rate_limit") {
+		app.limiter.Update(cfg)
+	}
+}
+
+func trustedProxies(cfg types.AppConfig) []string {
+	if cfg.System.TrustedProxies == "" {
+		return []string{"127.0.0.1"}
+	}
+	return strings.Split(cfg.System.TrustedProxies, ";")
+}
+
+func corsConfig(cfg types.AppConfig) cors.Config {
+	conf := cors.Config{
+		AllowAllOrigins:           cfg.Middlewares.CORS.AllowAllOrigins,
+		AllowMethods:              cfg.Middlewares.CORS.AllowMethods,
+		AllowPrivateNetwork:       cfg.Middlewares.CORS.AllowPrivateNetwork,
+		AllowHeaders:              cfg.Middlewares.CORS.AllowHeaders,
+		AllowCredentials:          cfg.Middlewares.CORS.AllowCredentials,
+		ExposeHeaders:             cfg.Middlewares.CORS.ExposeHeaders,
+		MaxAge:                    time.Second * time.Duration(cfg.Middlewares.CORS.MaxAge),
+		AllowWildcard:             cfg.Middlewares.CORS.AllowWildcard,
+		AllowBrowserExtensions:    cfg.Middlewares.CORS.AllowBrowserExtensions,
+		AllowWebSockets:           cfg.Middlewares.CORS.AllowWebSockets,
+		AllowFiles:                cfg.Middlewares.CORS.AllowFiles,
+		OptionsResponseStatusCode: cfg.Middlewares.CORS.OptionsResponseStatusCode,
+	}
+
+	// cors rejects settings with more than one way to allow origins
+	if !conf.AllowAllOrigins {
+		if len(cfg.Middlewares.CORS.AllowOrigins) > 0 {
+			conf.AllowOrigins = cfg.Middlewares.CORS.AllowOrigins
+		} else {
+			conf.AllowOriginFunc = func(origin string) bool { return true }
+		}
+	}
+	return conf
+}
+
+// newCORSHandler returns nil if CORS is disabled
+func newCORSHandler(cfg types.AppConfig) gin.HandlerFunc {
+	if !cfg.Middlewares.CORS.Enable {
+		return nil
+	}
+	return cors.New(corsConfig(cfg))
 }
 
 func (app *Application) useMiddlewares(ctx context.Context, cfg types.AppConfig, rds *RedisPool, logger *AppLogger) error {
@@ -116,25 +193,19 @@ func (app *Application) useMiddlewares(ctx context.Context, cfg types.AppConfig,
 	//   - stack means whether output the stack info.
 	app.engine.Use(ginzap.RecoveryWithZap(logger, true))
 
-	// Middleware for CORS
-	if cfg.Middlewares.CORS.Enable {
-		app.engine.Use(cors.New(cors.Config{
-			AllowOriginFunc: func(origin string) bool { return true },
-
-			AllowAllOrigins:           cfg.Middlewares.CORS.AllowAllOrigins,
-			AllowMethods:              cfg.Middlewares.CORS.AllowMethods,
-			AllowPrivateNetwork:       cfg.Middlewares.CORS.AllowPrivateNetwork,
-			AllowHeaders:              cfg.Middlewares.CORS.AllowHeaders,
-			AllowCredentials:          cfg.Middlewares.CORS.AllowCredentials,
-			ExposeHeaders:             cfg.Middlewares.CORS.ExposeHeaders,
-			MaxAge:                    time.Second * time.Duration(cfg.Middlewares.CORS.MaxAge),
-			AllowWildcard:             cfg.Middlewares.CORS.AllowWildcard,
-			AllowBrowserExtensions:    cfg.Middlewares.CORS.AllowBrowserExtensions,
-			AllowWebSockets:           cfg.Middlewares.CORS.AllowWebSockets,
-			AllowFiles:                cfg.Middlewares.CORS.AllowFiles,
-			OptionsResponseStatusCode: cfg.Middlewares.CORS.OptionsResponseStatusCode,
-		}))
-	}
+	// Middleware for rate limiting
+	app.limiter = NewRateLimiter(cfg)
+	app.engine.Use(app.limiter.Handler())
+
+	// Middleware for CORS, it can be replaced on config changes
+	app.cors.Store(newCORSHandler(cfg))
+	app.engine.Use(func(ctx *gin.Context) {
+		if handler, _ := app.cors.Load().(gin.HandlerFunc); handler != nil {
+			handler(ctx)
+			return
+		}
+		ctx.Next()
+	})
 
 	// Middleware for session
 	if cfg.Middlewares.Session.Enable {
@@ -162,10 +233,54 @@ func (app *Application) useMiddlewares(ctx context.Context, cfg types.AppConfig,
 		app.engine.Use(gzip.Gzip(gzip.DefaultCompression))
 	}
 
+	// Middleware for the tenant, resolved before the identity which is bound to it
+	if tenant := cfg.Middlewares.Auth.Tenant; tenant.Enable {
+		resolve, err := NewTenantResolver(cfg)
+		if err != nil {
+			return err
+		}
+		defaultTenant := tenant.Default
+		if tenant.Resolver == TenantResolverClaim {
+			// the tokens without a tenant would not match it
+			defaultTenant = ""
+		}
+		app.engine.Use(TenantHandler(resolve, defaultTenant))
+	}
+
+	// Middleware for identity, set by the module issuing the tokens
+	app.engine.Use(func(ctx *gin.Context) {
+		if handler, _ := app.identity.Load().(gin.HandlerFunc); handler != nil {
+			handler(ctx)
+			return
+		}
+		ctx.Next()
+	})
+
 	// Middleware for authentication
 	if cfg.Middlewares.Auth.Enable {
-		author, _ := NewAuthorizer(cfg, logger)
-		app.engine.Use(author.AuthorizerHandler())
+		author, err := NewAuthorizer(cfg, logger)
+		if err != nil {
+			return err
+		}
+		if cfg.Middlewares.Auth.Tenant.Enable && !author.Domains() {
+			// stops the startup rather than serving the tenants unauthorized
+			return errors.New("middlewares.auth.tenant needs a casbin model with domains, e.g. ./config/rbac_model_domains.conf")
+		}
+		app.author = author
+		handler := author.AuthorizerHandler()
+		if routes := cfg.Middlewares.Auth.Routes; routes.Enable {
+			// the auth routes check the tokens by themselves, and the public keys are public
+			prefix := strings.TrimSuffix(routes.Prefix, "/") + "/"
+			app.engine.Use(func(ctx *gin.Context) {
+				if path := ctx.Request.URL.Path; strings.HasPrefix(path, prefix) || strings.HasPrefix(path, "/.well-known/") {
+					ctx.Next()
+					return
+				}
+				handler(ctx)
+			})
+		} else {
+			app.engine.Use(handler)
+		}
 	}
 	return nil
 }
@@ -173,7 +288,7 @@ func (app *Application) useMiddlewares(ctx context.Context, cfg types.AppConfig,
 type RedisPool redis.Pool
 
 // NewRedisClient 函数
-func NewRedisPool(cfg types.AppConfig) (*RedisPool, error) {
+func NewRedisPool(cfg types.AppConfig, lc fx.Lifecycle) (*RedisPool, error) {
 	// 创建 Redis 连接池
 	pool := &redis.Pool{
 		MaxIdle:     5,
@@ -189,14 +304,22 @@ func NewRedisPool(cfg types.AppConfig) (*RedisPool, error) {
 		},
 	}
 
-	// 测试连接
-	conn := pool.Get()
-	defer conn.Close()
+	// 测试连接 on start, so that commands which never start the application do not need redis
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			conn, err := pool.GetContext(ctx)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
 
-	_, err := conn.Do("PING")
-	if err != nil {
-		return nil, err
-	}
+			_, err = conn.Do("PING")
+			return err
+		},
+		OnStop: func(context.Context) error {
+			return pool.Close()
+		},
+	})
 
 	return (*RedisPool)(pool), nil
 }
@@ -212,6 +335,26 @@ func NewCache(cfg types.AppConfig, rds *RedisPool) persistence.CacheStore {
 	return nil
 }
 
+// UseIdentity sets the middleware putting the identity of the requests into their context, it runs before casbin
+func (app *Application) UseIdentity(handler gin.HandlerFunc) {
+	app.identity.Store(handler)
+}
+
+// Authorizer returns the authorizer of the requests, nil unless middlewares.auth is enabled
+func (app *Application) Authorizer() *Authorizer {
+	return app.author
+}
+
+// Group creates a route group, e.g. for the routes of other modules
+func (app *Application) Group(relativePath string, handlers ...gin.HandlerFunc) *gin.RouterGroup {
+	return app.engine.Group(relativePath, handlers...)
+}
+
+// Routes lists all routes registered so far
+func (app *Application) Routes() gin.RoutesInfo {
+	return app.engine.Routes()
+}
+
 func NewHttpServer(app *Application, logger *AppLogger) *http.Server {
 	return &http.Server{
 		Addr:         app.Config.ServerAddr,
@@ -2,15 +2,65 @@ package bootstrap
 
 import "go.uber.org/fx"
 
-// Module exports dependency
-var Module = fx.Module("bootstrap",
+// CoreModule provides the logger and the config watcher which all other modules rely on
+var CoreModule = fx.Module("core",
 	fx.Provide(
 		NewAppLogger,
+		NewConfigWatcher,
+	),
+)
+
+// DBModule provides the database connection
+var DBModule = fx.Module("database",
+	fx.Provide(
+		newDBWithLifecycle,
+	),
+)
+
+// RedisModule provides the redis pool and the cache store
+var RedisModule = fx.Module("redis",
+	fx.Provide(
 		NewRedisPool,
-		NewDB,
+		NewCache,
+	),
+)
+
+// SentryModule provides the sentry client
+var SentryModule = fx.Module("sentry",
+	fx.Provide(
 		NewSentry,
+	),
+)
+
+// ServerModule provides the HTTP application
+var ServerModule = fx.Module("server",
+	fx.Provide(
 		NewApplication,
-		NewCache,
 		// NewHttpServer,
 	),
 )
+
+// AuthModule provides the authenticator of users
+var AuthModule = fx.Module("auth",
+	fx.Provide(
+		NewAuthenticator,
+	),
+)
+
+// MailerModule provides the mailer, which is nil if mailer.type is none
+var MailerModule = fx.Module("mailer",
+	fx.Provide(
+		NewMailer,
+	),
+)
+
+// Module exports dependency
+var Module = fx.Module("bootstrap",
+	CoreModule,
+	DBModule,
+	RedisModule,
+	SentryModule,
+	ServerModule,
+	AuthModule,
+	MailerModule,
+)
@@ -0,0 +1,365 @@
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigSource identifies the layer which supplied a configuration item
+type ConfigSource int
+
+const (
+	SourceDefault ConfigSource = iota // `default:` struct tag
+	SourceFile                        // YAML config file
+	SourceEnv                         // environment variable
+	SourceFlag                        // command line flag
+)
+
+func (src ConfigSource) String() string {
+	switch src {
+	case SourceDefault:
+		return "default"
+	case SourceFile:
+		return "file"
+	case SourceEnv:
+		return "env"
+	case SourceFlag:
+		return "flag"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(src))
+	}
+}
+
+// ConfigOverrides collects repeatable `path=value` command line flags, e.g. `-set basic.redis.db=1`
+type ConfigOverrides []string
+
+func (overrides *ConfigOverrides) String() string {
+	return strings.Join(*overrides, ",")
+}
+
+func (overrides *ConfigOverrides) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("invalid config override %q, expected path=value", value)
+	}
+	*overrides = append(*overrides, value)
+	return nil
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// ConfigLoader builds a configuration of type T layer by layer: `default:` struct tags first, then the YAML
+// file, then environment variables and finally command line overrides. Items are addressed by the dotted
+// path of their yaml tags, e.g. `basic.redis.password`, which maps to the environment variable
+// `APP_BASIC_REDIS_PASSWORD` when EnvPrefix is `APP`.
+//
+// Once merged, `ENC[...]` values are decrypted with Cipher (loaded from `APP_CONFIG_KEY` or `APP_CONFIG_KEY_FILE`
+// if not set), and `file:/path` values of `secret:"true"` items are replaced with the content of the file.
+type ConfigLoader[T any] struct {
+	EnvPrefix string
+	Overrides []string
+	Cipher    *SecretCipher
+
+	sources map[string]ConfigSource
+	unknown []string
+}
+
+func NewConfigLoader[T any](envPrefix string, overrides []string) *ConfigLoader[T] {
+	return &ConfigLoader[T]{
+		EnvPrefix: envPrefix,
+		Overrides: overrides,
+		sources:   map[string]ConfigSource{},
+	}
+}
+
+// Load applies all layers and returns the merged configuration. An empty yamlFile skips the file layer.
+func (loader *ConfigLoader[T]) Load(yamlFile string) (*T, error) {
+	cfg := NewInstance[T]()
+	if cfg == nil {
+		return nil, errors.New("failed to apply default values")
+	}
+
+	loader.sources = map[string]ConfigSource{}
+	loader.unknown = nil
+	fields := ConfigFields(cfg)
+	index := make(map[string]reflect.Value, len(fields))
+	for _, field := range fields {
+		index[field.Path] = field.Value
+		loader.sources[field.Path] = SourceDefault
+	}
+
+	if yamlFile != "" {
+		data, err := os.ReadFile(yamlFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var root yaml.Node
+		if err = yaml.Unmarshal(data, &root); err != nil {
+			return nil, err
+		}
+		if err = root.Decode(cfg); err != nil {
+			return nil, err
+		}
+		known := knownConfigPaths(fields)
+		for _, path := range yamlPaths(&root, "") {
+			if _, ok := index[path]; ok {
+				loader.sources[path] = SourceFile
+			} else if !known[path] && !loader.isUnknown(path) {
+				loader.unknown = append(loader.unknown, path)
+			}
+		}
+	}
+
+	for _, field := range fields {
+		name := loader.EnvName(field.Path)
+		if raw, ok := os.LookupEnv(name); ok {
+			if err := SetConfigValue(field.Value, raw); err != nil {
+				return nil, fmt.Errorf("invalid value of %s: %w", name, err)
+			}
+			loader.sources[field.Path] = SourceEnv
+		}
+	}
+
+	for _, item := range loader.Overrides {
+		path, raw, _ := strings.Cut(item, "=")
+		path = strings.TrimSpace(path)
+		value, ok := index[path]
+		if !ok {
+			return nil, fmt.Errorf("unknown config item: %s", path)
+		}
+		if err := SetConfigValue(value, raw); err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %w", path, err)
+		}
+		loader.sources[path] = SourceFlag
+	}
+
+	// the elements of the slices are known after decoding the file
+	if err := loader.resolveSecrets(ConfigFields(cfg)); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks the loaded configuration against its `validate:` rules, keys in the YAML file which do not
+// match any config item are reported as violations as well.
+func (loader *ConfigLoader[T]) Validate(cfg *T) error {
+	var violations ConfigErrors
+	for _, path := range loader.unknown {
+		violations = append(violations, ConfigViolation{Path: path, Rule: "unknown", Message: "unknown config item"})
+	}
+
+	err := ValidateConfig(cfg)
+	var cerrs ConfigErrors
+	if errors.As(err, &cerrs) {
+		violations = append(violations, cerrs...)
+	} else if err != nil {
+		return err
+	}
+
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// UnknownKeys lists keys of the YAML file which do not match any config item
+func (loader *ConfigLoader[T]) UnknownKeys() []string {
+	return append([]string{}, loader.unknown...)
+}
+
+// isUnknown reports whether the path or one of its parents has been reported as unknown
+func (loader *ConfigLoader[T]) isUnknown(path string) bool {
+	for _, item := range loader.unknown {
+		if matchConfigPath(path, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// EnvName returns the environment variable name of the given config item path
+func (loader *ConfigLoader[T]) EnvName(path string) string {
+	name := strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+	if loader.EnvPrefix == "" {
+		return name
+	}
+	return strings.ToUpper(loader.EnvPrefix) + "_" + name
+}
+
+// Sources reports which layer supplied each config item after Load
+func (loader *ConfigLoader[T]) Sources() map[string]ConfigSource {
+	sources := make(map[string]ConfigSource, len(loader.sources))
+	for path, src := range loader.sources {
+		sources[path] = src
+	}
+	return sources
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// ConfigField is a leaf item of a configuration struct addressed by its yaml path
+type ConfigField struct {
+	Path    string
+	Field   reflect.StructField
+	Value   reflect.Value
+	Element bool // of an element of a slice of structs, the index is a segment of the path, e.g. providers.0.name
+}
+
+// ConfigFields lists all leaf items of the configuration struct pointed by cfg in declaration order. A slice of
+// structs is an item itself, followed by the items of its elements.
+func ConfigFields(cfg interface{}) []ConfigField {
+	var fields []ConfigField
+	collectConfigFields(reflect.ValueOf(cfg).Elem(), "", false, &fields)
+	return fields
+}
+
+func collectConfigFields(val reflect.Value, prefix string, element bool, fields *[]ConfigField) {
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := yamlName(sf)
+		if name == "-" {
+			continue
+		}
+
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		fv := val.Field(i)
+		if isStructValue(fv.Type()) {
+			collectConfigFields(fv, path, element, fields)
+			continue
+		}
+		*fields = append(*fields, ConfigField{Path: path, Field: sf, Value: fv, Element: element})
+		if fv.Kind() == reflect.Slice && isStructValue(fv.Type().Elem()) {
+			for j := 0; j < fv.Len(); j++ {
+				collectConfigFields(fv.Index(j), path+"."+strconv.Itoa(j), true, fields)
+			}
+		}
+	}
+}
+
+// isStructValue reports whether the type is a section of the configuration rather than a value like time.Time
+func isStructValue(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Struct && typ != reflect.TypeOf(time.Time{})
+}
+
+// knownConfigPaths returns the paths of all config items and their parent sections
+func knownConfigPaths(fields []ConfigField) map[string]bool {
+	known := map[string]bool{}
+	for _, field := range fields {
+		for path := field.Path; path != ""; {
+			known[path] = true
+			idx := strings.LastIndex(path, ".")
+			if idx < 0 {
+				break
+			}
+			path = path[:idx]
+		}
+	}
+	return known
+}
+
+func yamlName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(sf.Name)
+	}
+	return name
+}
+
+// yamlPaths lists the dotted paths of all keys defined in a YAML document
+func yamlPaths(node *yaml.Node, prefix string) []string {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return yamlPaths(node.Content[0], prefix)
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var paths []string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		path := node.Content[i].Value
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		paths = append(paths, path)
+		paths = append(paths, yamlPaths(node.Content[i+1], path)...)
+	}
+	return paths
+}
+
+// SetConfigValue converts raw into the type of the config item and assigns it. Slices are comma separated.
+func SetConfigValue(field reflect.Value, raw string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		// plain integers are accepted as well, the same as the YAML decoder does
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			field.SetInt(n)
+			return nil
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported slice type: %s", field.Type())
+		}
+		items := reflect.MakeSlice(field.Type(), 0, 0)
+		if raw != "" {
+			for _, item := range strings.Split(raw, ",") {
+				items = reflect.Append(items, reflect.ValueOf(strings.TrimSpace(item)).Convert(field.Type().Elem()))
+			}
+		}
+		field.Set(items)
+	default:
+		return fmt.Errorf("unsupported type: %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package bootstrap_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+type testConfig struct {
+	Basic types.AppConfig `yaml:"basic"`
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "app_config.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+	return file
+}
+
+func TestConfigLoaderLayers(t *testing.T) {
+	file := writeConfigFile(t, `
+basic:
+  database:
+    dbtype: mysql
+    dbport: 3307
+  redis:
+    password: from-file
+`)
+	t.Setenv("APP_BASIC_REDIS_PASSWORD", "from-env")
+	t.Setenv("APP_BASIC_MIDDLEWARES_CORS_ALLOW_METHODS", "GET, POST")
+
+	loader := bootstrap.NewConfigLoader[testConfig]("APP", []string{"basic.database.dbport=5432"})
+	cfg, err := loader.Load(file)
+	require.NoError(t, err)
+
+	assert.Equal(t, "mysql", cfg.Basic.Database.Type)
+	assert.Equal(t, 5432, cfg.Basic.Database.Port)
+	assert.Equal(t, "from-env", cfg.Basic.Redis.Password)
+	assert.Equal(t, []string{"GET", "POST"}, cfg.Basic.Middlewares.CORS.AllowMethods)
+	assert.Equal(t, 10*time.Minute, cfg.Basic.Redis.DefaultExpiration)
+
+	sources := loader.Sources()
+	assert.Equal(t, bootstrap.SourceFile, sources["basic.database.dbtype"])
+	assert.Equal(t, bootstrap.SourceFlag, sources["basic.database.dbport"])
+	assert.Equal(t, bootstrap.SourceEnv, sources["basic.redis.password"])
+	assert.Equal(t, bootstrap.SourceDefault, sources["basic.redis.address"])
+}
+
+func TestConfigLoaderErrors(t *testing.T) {
+	loader := bootstrap.NewConfigLoader[testConfig]("APP", []string{"basic.no_such_item=1"})
+	_, err := loader.Load("")
+	assert.Error(t, err)
+
+	loader = bootstrap.NewConfigLoader[testConfig]("APP", []string{"basic.database.dbport=abc"})
+	_, err = loader.Load("")
+	assert.Error(t, err)
+
+	_, err = bootstrap.NewConfigLoader[testConfig]("APP", nil).Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestConfigLoaderValidate(t *testing.T) {
+	file := writeConfigFile(t, `
+basic:
+  system:
+    static_dir: ./static
+  database:
+    dbtype: oracle
+  middlewares:
+    auth:
+      enable: true
+      model_file: ./no/such/model.conf
+`)
+
+	loader := bootstrap.NewConfigLoader[testConfig]("APP", nil)
+	cfg, err := loader.Load(file)
+	require.NoError(t, err)
+
+	err = loader.Validate(cfg)
+	var violations bootstrap.ConfigErrors
+	require.ErrorAs(t, err, &violations)
+
+	paths := map[string]string{}
+	for _, violation := range violations {
+		paths[violation.Path] = violation.Rule
+	}
+	assert.Equal(t, "unknown", paths["basic.system.static_dir"])
+	assert.Equal(t, "oneof", paths["basic.database.dbtype"])
+	assert.Equal(t, "file_exists", paths["basic.middlewares.auth.model_file"])
+	// static files are enabled by default, but ./static is relative to the test directory
+	assert.Equal(t, "dir_exists", paths["basic.middlewares.static.static_dir"])
+}
+
+func TestConfigLoaderSecrets(t *testing.T) {
+	key, err := bootstrap.GenerateSecretKey()
+	require.NoError(t, err)
+	sc, err := bootstrap.NewSecretCipher([]byte(key))
+	require.NoError(t, err)
+
+	encrypted, err := sc.Encrypt("redis-secret")
+	require.NoError(t, err)
+	assert.True(t, bootstrap.IsEncrypted(encrypted))
+
+	pwFile := filepath.Join(t.TempDir(), "db_password")
+	require.NoError(t, os.WriteFile(pwFile, []byte("db-secret\n"), 0o600))
+
+	file := writeConfigFile(t, `
+basic:
+  database:
+    dbpassword: file:`+pwFile+`
+    dbname: file:log/test.db
+  redis:
+    password: `+encrypted+`
+  middlewares:
+    auth:
+      oidc:
+        providers:
+          - name: keycloak
+            client_secret: `+encrypted+`
+`)
+
+	// the key is required once encrypted values are present
+	_, err = bootstrap.NewConfigLoader[testConfig]("APP", nil).Load(file)
+	assert.Error(t, err)
+
+	t.Setenv("APP_CONFIG_KEY", key)
+	cfg, err := bootstrap.NewConfigLoader[testConfig]("APP", nil).Load(file)
+	require.NoError(t, err)
+	assert.Equal(t, "redis-secret", cfg.Basic.Redis.Password)
+	assert.Equal(t, "db-secret", cfg.Basic.Database.Password)
+	// file references are only resolved for secret items
+	assert.Equal(t, "file:log/test.db", cfg.Basic.Database.Database)
+
+	// also the secrets of the elements of slices
+	require.Len(t, cfg.Basic.Middlewares.Auth.OIDC.Providers, 1)
+	assert.Equal(t, "redis-secret", cfg.Basic.Middlewares.Auth.OIDC.Providers[0].ClientSecret)
+
+	redacted := bootstrap.RedactConfig(cfg)
+	assert.Equal(t, bootstrap.RedactedValue, redacted.Basic.Redis.Password)
+	assert.Equal(t, "redis-secret", cfg.Basic.Redis.Password)
+	assert.Equal(t, bootstrap.RedactedValue, redacted.Basic.Middlewares.Auth.OIDC.Providers[0].ClientSecret)
+	assert.Equal(t, "redis-secret", cfg.Basic.Middlewares.Auth.OIDC.Providers[0].ClientSecret, "the original is kept")
+}
+
+func TestConfigSchema(t *testing.T) {
+	schema := bootstrap.ConfigSchema(bootstrap.NewInstance[types.AppConfig](), "test")
+	prop := func(schema map[string]interface{}, path ...string) map[string]interface{} {
+		for _, name := range path {
+			schema = schema["properties"].(map[string]interface{})[name].(map[string]interface{})
+		}
+		return schema
+	}
+
+	providers := prop(schema, "middlewares", "auth", "oidc", "providers")
+	assert.Equal(t, "array", providers["type"])
+	provider := providers["items"].(map[string]interface{})
+	assert.Equal(t, "object", provider["type"])
+	assert.Equal(t, true, prop(provider, "client_secret")["writeOnly"])
+	assert.Contains(t, provider["required"], "client_id")
+	assert.Equal(t, "^/", prop(schema, "middlewares", "auth", "routes", "prefix")["pattern"])
+}
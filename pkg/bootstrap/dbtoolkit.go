@@ -2,17 +2,43 @@ package bootstrap
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	// _ "gorm.io/driver/sqlite" // // Sqlite driver based on GGO
 	_ "github.com/glebarez/sqlite" // Pure go SQLite driver, checkout https://github.com/glebarez/sqlite for details
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the pgx driver for PostgreSQL
 	"github.com/jmoiron/sqlx"
 	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+	"go.uber.org/fx"
+
+	"github.com/robinmin/gin-starter/pkg/internal/dbo"
+	"github.com/robinmin/gin-starter/pkg/utility"
 )
 
 type DBParams types.AppDBConfig
-type DBToolKit sqlx.DB
+
+// DBToolKit is the primary database, with the read-only queries taking a context balanced over the healthy replicas
+type DBToolKit struct {
+	*sqlx.DB
+
+	replicas *replicaSet
+}
+
+func init() {
+	// sqlx does not know the name of the pure go driver, which takes `?` as bind variables
+	sqlx.BindDriver("sqlite", sqlx.QUESTION)
+}
 
 func NewDBParams(dbtype string, dbhost string, dbport int, dbdatabase string, dbuser string, dbpassword string) DBParams {
 	return DBParams{
@@ -25,10 +51,62 @@ func NewDBParams(dbtype string, dbhost string, dbport int, dbdatabase string, db
 	}
 }
 
+// DriverName returns the name of the registered database/sql driver for the database type
+func (param DBParams) DriverName() string {
+	switch param.Type {
+	case "sqlite3":
+		// the pure go driver registers itself as sqlite
+		return "sqlite"
+	case "postgres":
+		return "pgx"
+	default:
+		return param.Type
+	}
+}
+
 func (param DBParams) GetDSN() (string, error) {
 	switch param.Type {
 	case "mysql":
-		return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true", param.User, param.Password, param.Host, param.Port, param.Database), nil
+		mc := mysql.NewConfig()
+		mc.User = param.User
+		mc.Passwd = param.Password
+		mc.Net = "tcp"
+		mc.Addr = net.JoinHostPort(param.Host, strconv.Itoa(param.Port))
+		mc.DBName = param.Database
+		mc.ParseTime = true
+		if param.SSLMode != "" && param.SSLMode != "disable" {
+			tc, err := param.TLSConfig()
+			if err != nil {
+				return "", err
+			}
+			// the driver looks up TLS configs by name from the DSN
+			mc.TLSConfig = "custom-" + mc.Addr
+			if err = mysql.RegisterTLSConfig(mc.TLSConfig, tc); err != nil {
+				return "", err
+			}
+		}
+		return mc.FormatDSN(), nil
+	case "postgres":
+		query := url.Values{}
+		query.Set("sslmode", param.SSLMode)
+		if param.SSLMode == "" {
+			query.Set("sslmode", "disable")
+		}
+		if param.TLSCA != "" {
+			query.Set("sslrootcert", param.TLSCA)
+		}
+		if param.TLSCert != "" {
+			query.Set("sslcert", param.TLSCert)
+			query.Set("sslkey", param.TLSKey)
+		}
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(param.User, param.Password),
+			Host:     net.JoinHostPort(param.Host, strconv.Itoa(param.Port)),
+			Path:     "/" + param.Database,
+			RawQuery: query.Encode(),
+		}
+		return dsn.String(), nil
 	case "sqlite3":
 		return fmt.Sprintf("file:%s?cache=shared&mode=rwc", param.Database), nil
 	default:
@@ -36,6 +114,53 @@ func (param DBParams) GetDSN() (string, error) {
 	}
 }
 
+// TLSConfig builds the client TLS config following the libpq sslmode semantics: require encrypts only, verify-ca
+// also checks the certificate chain, and verify-full checks the host name as well.
+func (param DBParams) TLSConfig() (*tls.Config, error) {
+	tc := &tls.Config{
+		ServerName: param.Host,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if param.TLSCA != "" {
+		pem, err := os.ReadFile(param.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", param.TLSCA)
+		}
+	}
+	if param.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(param.TLSCert, param.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	switch param.SSLMode {
+	case "require":
+		tc.InsecureSkipVerify = true
+	case "verify-ca":
+		// skip the default verification which includes the host name, and check the chain only
+		tc.InsecureSkipVerify = true
+		tc.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("no server certificate")
+			}
+			opts := x509.VerifyOptions{Roots: tc.RootCAs, Intermediates: x509.NewCertPool()}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	}
+	return tc, nil
+}
+
 func NewDB(cfg types.AppConfig) (*DBToolKit, error) {
 	params := DBParams(cfg.Database)
 	conn_str, err0 := params.GetDSN()
@@ -43,7 +168,7 @@ func NewDB(cfg types.AppConfig) (*DBToolKit, error) {
 		return nil, fmt.Errorf("Unsupported database type: %s", params.Type)
 	}
 
-	db, err := sqlx.Connect(params.Type, conn_str)
+	db, err := sqlx.Connect(params.DriverName(), conn_str)
 	if err != nil {
 		return nil, err
 	}
@@ -61,5 +186,146 @@ func NewDB(cfg types.AppConfig) (*DBToolKit, error) {
 		return nil, err
 	}
 
-	return (*DBToolKit)(db), err
+	kit := &DBToolKit{DB: db}
+
+	// 自动迁移, so that the tables are up to date before anyone uses the connection
+	if cfg.Database.AutoMigrate {
+		if err = migrateDB(kit, cfg.Database.Type); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	if len(cfg.Database.Replicas) > 0 {
+		if kit.replicas, err = newReplicaSet(cfg.Database); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return kit, nil
+}
+
+// newDBWithLifecycle closes the database together with the application
+func newDBWithLifecycle(cfg types.AppConfig, lc fx.Lifecycle) (*DBToolKit, error) {
+	kit, err := NewDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			return kit.Close()
+		},
+	})
+	return kit, nil
+}
+
+func migrateDB(db *DBToolKit, dbtype string) error {
+	migrator, err := NewSchemaMigrator(db, dbtype)
+	if err != nil {
+		return err
+	}
+	if _, err = migrator.Up(context.Background()); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+	return nil
+}
+
+// Queries returns the sqlc queries in the dialect of the database
+func (kit *DBToolKit) Queries() *dbo.Queries {
+	return dbo.NewWithDialect(kit, kit.DriverName())
+}
+
+// TxQueries returns the sqlc queries running in the transaction
+func (kit *DBToolKit) TxQueries(tx dbo.DBTX) *dbo.Queries {
+	return dbo.NewWithDialect(tx, kit.DriverName())
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Statements taking a context run in the transaction of the context if any, see Transaction. Otherwise reads go to a
+// replica, unless utility.NewPrimaryRead marks the context, e.g. to read what has just been written. Everything else,
+// including prepared statements, goes to the primary.
+
+// dbConn is implemented by both *sqlx.DB and *sqlx.Tx
+type dbConn interface {
+	sqlx.ExtContext
+	sqlx.PreparerContext
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+// Reader returns the database for the reads of the context outside of transactions
+func (kit *DBToolKit) Reader(ctx context.Context) *sqlx.DB {
+	if kit.replicas == nil || utility.FromPrimaryRead(ctx) || utility.FromRowLock(ctx) {
+		return kit.DB
+	}
+	if replica := kit.replicas.next(); replica != nil {
+		return replica
+	}
+	return kit.DB
+}
+
+func (kit *DBToolKit) conn(ctx context.Context, read bool) dbConn {
+	if tx, ok := utility.FromTrans(ctx); ok {
+		return tx
+	}
+	if read {
+		return kit.Reader(ctx)
+	}
+	return kit.DB
+}
+
+// lockQuery appends FOR UPDATE to the SELECT statements of a context marked by utility.NewRowLock
+func (kit *DBToolKit) lockQuery(ctx context.Context, query string) string {
+	if !utility.FromRowLock(ctx) || kit.DriverName() == "sqlite" {
+		// SQLite locks the whole database on write, there is no row lock
+		return query
+	}
+
+	trimmed := strings.TrimRight(strings.TrimSpace(query), ";")
+	if len(trimmed) < 6 || !strings.EqualFold(trimmed[:6], "SELECT") || strings.HasSuffix(strings.ToUpper(trimmed), " FOR UPDATE") {
+		return query
+	}
+	return trimmed + " FOR UPDATE"
+}
+
+func (kit *DBToolKit) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return kit.conn(ctx, false).ExecContext(ctx, query, args...)
+}
+
+func (kit *DBToolKit) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return kit.conn(ctx, false).PrepareContext(ctx, kit.lockQuery(ctx, query))
+}
+
+func (kit *DBToolKit) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return kit.conn(ctx, true).QueryContext(ctx, kit.lockQuery(ctx, query), args...)
+}
+
+func (kit *DBToolKit) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return kit.conn(ctx, true).QueryRowContext(ctx, kit.lockQuery(ctx, query), args...)
+}
+
+func (kit *DBToolKit) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	return kit.conn(ctx, true).QueryxContext(ctx, kit.lockQuery(ctx, query), args...)
+}
+
+func (kit *DBToolKit) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	return kit.conn(ctx, true).QueryRowxContext(ctx, kit.lockQuery(ctx, query), args...)
+}
+
+func (kit *DBToolKit) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return kit.conn(ctx, true).SelectContext(ctx, dest, kit.lockQuery(ctx, query), args...)
+}
+
+func (kit *DBToolKit) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return kit.conn(ctx, true).GetContext(ctx, dest, kit.lockQuery(ctx, query), args...)
+}
+
+// Close closes the primary and all replicas
+func (kit *DBToolKit) Close() error {
+	if kit.replicas != nil {
+		kit.replicas.close()
+	}
+	return kit.DB.Close()
 }
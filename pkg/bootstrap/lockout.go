@@ -0,0 +1,147 @@
+package bootstrap
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// attemptStore counts failed logins and keeps the locks, with expiration
+type attemptStore interface {
+	// LockedFor returns how long the key is still locked
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	// Fail counts a failure of the key within the window and returns the number of failures so far
+	Fail(ctx context.Context, key string, window time.Duration) (int, error)
+	Lock(ctx context.Context, key string, d time.Duration) error
+	Reset(ctx context.Context, key string) error
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// redisAttemptStore shares the counters among all instances of the application
+type redisAttemptStore struct {
+	pool *redis.Pool
+}
+
+func (s *redisAttemptStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	ms, err := redis.Int64(conn.Do("PTTL", "auth:lock:"+key))
+	if err != nil || ms < 0 {
+		// -2 if the key does not exist
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (s *redisAttemptStore) Fail(ctx context.Context, key string, window time.Duration) (int, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	n, err := redis.Int(conn.Do("INCR", "auth:fail:"+key))
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		_, err = conn.Do("PEXPIRE", "auth:fail:"+key, window.Milliseconds())
+	}
+	return n, err
+}
+
+func (s *redisAttemptStore) Lock(ctx context.Context, key string, d time.Duration) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("SET", "auth:lock:"+key, 1, "PX", d.Milliseconds())
+	return err
+}
+
+func (s *redisAttemptStore) Reset(ctx context.Context, key string) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("DEL", "auth:fail:"+key, "auth:lock:"+key)
+	return err
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// memoryAttemptStore is used without redis, e.g. in tests and admin commands
+type memoryAttemptStore struct {
+	mu    sync.Mutex
+	now   func() time.Time
+	fails map[string]memoryCounter
+	locks map[string]time.Time
+}
+
+type memoryCounter struct {
+	n       int
+	expires time.Time
+}
+
+func newMemoryAttemptStore() *memoryAttemptStore {
+	return &memoryAttemptStore{
+		now:   time.Now,
+		fails: map[string]memoryCounter{},
+		locks: map[string]time.Time{},
+	}
+}
+
+func (s *memoryAttemptStore) LockedFor(_ context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if until, ok := s.locks[key]; ok {
+		if d := until.Sub(s.now()); d > 0 {
+			return d, nil
+		}
+		delete(s.locks, key)
+	}
+	return 0, nil
+}
+
+func (s *memoryAttemptStore) Fail(_ context.Context, key string, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	counter := s.fails[key]
+	if !counter.expires.After(now) {
+		counter = memoryCounter{expires: now.Add(window)}
+	}
+	counter.n++
+	s.fails[key] = counter
+	return counter.n, nil
+}
+
+func (s *memoryAttemptStore) Lock(_ context.Context, key string, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.locks[key] = s.now().Add(d)
+	return nil
+}
+
+func (s *memoryAttemptStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.fails, key)
+	delete(s.locks, key)
+	return nil
+}
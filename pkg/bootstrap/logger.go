@@ -25,6 +25,9 @@ type LoggerConfig struct {
 
 // /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
+// level of the global logger, which can be changed at runtime
+var loggerLevel = zap.NewAtomicLevel()
+
 type AppLogger struct {
 	*zap.Logger
 }
@@ -77,7 +80,8 @@ func InitLoggerWithConfig(cfg *LoggerConfig) (func(), error) {
 	if err != nil {
 		return nil, err
 	}
-	zconfig.Level.SetLevel(level)
+	loggerLevel.SetLevel(level)
+	zconfig.Level = loggerLevel
 
 	var (
 		logger   *zap.Logger
@@ -141,3 +145,13 @@ func InitLoggerWithConfig(cfg *LoggerConfig) (func(), error) {
 		}
 	}, nil
 }
+
+// SetLogLevel changes the level of the global logger on the fly
+func SetLogLevel(level string) error {
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	loggerLevel.SetLevel(lvl)
+	return nil
+}
@@ -0,0 +1,214 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+// Mail is a plain text mail
+type Mail struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Mailer sends the mails of the application, e.g. the password reset links
+type Mailer interface {
+	Send(ctx context.Context, mail Mail) error
+}
+
+// NewMailer creates the mailer of the config, it returns nil if the mailer type is none
+func NewMailer(cfg types.AppConfig) (Mailer, error) {
+	mc := cfg.Mailer
+	switch mc.Type {
+	case "smtp":
+		return &SMTPMailer{cfg: mc}, nil
+	case "file":
+		return &FileMailer{Dir: mc.Dir, From: mc.From}, nil
+	case "memory":
+		return &MemoryMailer{}, nil
+	case "none", "":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer type %s", mc.Type)
+	}
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// SMTPMailer sends the mails by an SMTP server, with implicit TLS or STARTTLS if the server offers it
+type SMTPMailer struct {
+	cfg types.AppMailerConfig
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Mail) error {
+	from, err := mail.ParseAddress(m.cfg.From)
+	if err != nil {
+		return fmt.Errorf("invalid mailer.from: %w", err)
+	}
+	data, err := msg.bytes(m.cfg.From)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	var conn net.Conn
+	if m.cfg.TLS {
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: m.cfg.Host}}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && !m.cfg.TLS {
+		if err = c.StartTLS(&tls.Config{ServerName: m.cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if m.cfg.Username != "" {
+		// PlainAuth refuses to send the password without TLS, except to localhost
+		if err = c.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		if err = c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// FileMailer writes the mails into the directory as .eml files, e.g. for development
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(_ context.Context, msg Mail) error {
+	data, err := msg.bytes(m.From)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(m.Dir, 0o750); err != nil {
+		return err
+	}
+
+	id := make([]byte, 4)
+	if _, err = rand.Read(id); err != nil {
+		return err
+	}
+	name := time.Now().Format("20060102-150405.000") + "-" + hex.EncodeToString(id) + ".eml"
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o640)
+}
+
+// MemoryMailer keeps the mails in memory, e.g. for tests
+type MemoryMailer struct {
+	mu    sync.Mutex
+	mails []Mail
+}
+
+func (m *MemoryMailer) Send(_ context.Context, msg Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mails = append(m.mails, msg)
+	return nil
+}
+
+// Mails returns the mails sent so far
+func (m *MemoryMailer) Mails() []Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Mail{}, m.mails...)
+}
+
+// Last returns the last mail sent to the address, false if none
+func (m *MemoryMailer) Last(to string) (Mail, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.mails) - 1; i >= 0; i-- {
+		for _, addr := range m.mails[i].To {
+			if strings.EqualFold(addr, to) {
+				return m.mails[i], true
+			}
+		}
+	}
+	return Mail{}, false
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// bytes formats the mail as a MIME message, the body is quoted-printable
+func (msg Mail) bytes(from string) ([]byte, error) {
+	if len(msg.To) == 0 {
+		return nil, errors.New("mail without recipient")
+	}
+	for _, header := range append([]string{from, msg.Subject}, msg.To...) {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, errors.New("line break in mail header")
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	// the line breaks of the text are written as CRLF
+	w := quotedprintable.NewWriter(&buf)
+	if _, err := w.Write([]byte(msg.Body)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
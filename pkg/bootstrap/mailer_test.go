@@ -0,0 +1,73 @@
+package bootstrap_test
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+func TestMailers(t *testing.T) {
+	ctx := context.Background()
+	cfg := *bootstrap.NewInstance[types.AppConfig]()
+	mailer, err := bootstrap.NewMailer(cfg)
+	require.NoError(t, err)
+	assert.Nil(t, mailer)
+	cfg.Mailer.Type = "pigeon"
+	_, err = bootstrap.NewMailer(cfg)
+	assert.Error(t, err)
+
+	// the file mailer writes MIME messages
+	cfg.Mailer.Type = "file"
+	cfg.Mailer.From = "Gin Starter <no-reply@example.com>"
+	cfg.Mailer.Dir = filepath.Join(t.TempDir(), "mails")
+	mailer, err = bootstrap.NewMailer(cfg)
+	require.NoError(t, err)
+	body := "Hi alice,\n\nthe token is " + strings.Repeat("x", 100) + "\n"
+	require.NoError(t, mailer.Send(ctx, bootstrap.Mail{To: []string{"alice@example.com"}, Subject: "Grüße", Body: body}))
+
+	files, err := filepath.Glob(filepath.Join(cfg.Mailer.Dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+	msg, err := mail.ReadMessage(f)
+	require.NoError(t, err)
+	assert.Equal(t, "Gin Starter <no-reply@example.com>", msg.Header.Get("From"))
+	assert.Equal(t, "alice@example.com", msg.Header.Get("To"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Grüße", subject)
+	data, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	require.NoError(t, err)
+	assert.Equal(t, strings.ReplaceAll(body, "\n", "\r\n"), string(data))
+
+	// no header injection
+	err = mailer.Send(ctx, bootstrap.Mail{To: []string{"alice@example.com\r\nBcc: eve@example.com"}, Subject: "hi", Body: "hi"})
+	assert.Error(t, err)
+	err = mailer.Send(ctx, bootstrap.Mail{Subject: "hi", Body: "hi"})
+	assert.Error(t, err)
+
+	memory := &bootstrap.MemoryMailer{}
+	require.NoError(t, memory.Send(ctx, bootstrap.Mail{To: []string{"alice@example.com"}, Subject: "first"}))
+	require.NoError(t, memory.Send(ctx, bootstrap.Mail{To: []string{"bob@example.com"}, Subject: "second"}))
+	require.NoError(t, memory.Send(ctx, bootstrap.Mail{To: []string{"alice@example.com"}, Subject: "third"}))
+	assert.Len(t, memory.Mails(), 3)
+	last, ok := memory.Last("ALICE@example.com")
+	require.True(t, ok)
+	assert.Equal(t, "third", last.Subject)
+	_, ok = memory.Last("eve@example.com")
+	assert.False(t, ok)
+}
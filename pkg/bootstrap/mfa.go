@@ -0,0 +1,330 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"image/png"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// the TOTP parameters expected by the authenticator apps
+	mfaPeriod = 30 // in second
+	mfaDigits = otp.DigitsSix
+	// codes of the steps before and after the current one are accepted, for the clock skew of the devices
+	mfaSkew = 1
+
+	// number of the recovery codes issued at once, each is used once
+	mfaRecoveryCodes = 10
+	// size of the QR code image of the provisioning URI
+	mfaQRCodeSize = 256
+)
+
+var (
+	ErrInvalidMFACode = errors.New("invalid MFA code")
+	ErrMFANotEnrolled = errors.New("MFA not enrolled")
+	ErrMFAEnabled     = errors.New("MFA already enabled")
+)
+
+// TOTPEnrollment is the secret of a pending enrollment, the authenticator apps scan the QR code of the URI
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`     // otpauth://totp/...
+	QRCode string `json:"qr_code"` // PNG data URI of the QR code of URI
+}
+
+// MFAStatus tells whether the user logs in with MFA
+type MFAStatus struct {
+	Enabled       bool `json:"enabled"`
+	Required      bool `json:"required"` // by any role of the user, in any tenant
+	RecoveryCodes int  `json:"recovery_codes"`
+}
+
+// MFAManager enrolls and verifies the TOTP second factor of the users, the recovery codes are stored hashed
+type MFAManager struct {
+	db     *DBToolKit
+	issuer string
+
+	// Now is the clock of the codes, time.Now if nil
+	Now func() time.Time
+}
+
+func NewMFAManager(db *DBToolKit, issuer string) *MFAManager {
+	return &MFAManager{db: db, issuer: issuer}
+}
+
+// Status returns the MFA status of the user
+func (m *MFAManager) Status(ctx context.Context, username string) (*MFAStatus, error) {
+	q := m.db.Queries()
+	user, err := q.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, notFound(err, ErrUserNotFound, username)
+	}
+
+	var status MFAStatus
+	row, err := q.GetMFA(ctx, user.ID)
+	if err == nil {
+		status.Enabled = row.EnabledAt != nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	n, err := q.IsMFARequired(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	status.Required = n > 0
+	if status.Enabled {
+		n, err = q.CountRecoveryCodes(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		status.RecoveryCodes = int(n)
+	}
+	return &status, nil
+}
+
+// Enroll generates a new secret for the user, it replaces any pending enrollment and is enabled by Activate
+func (m *MFAManager) Enroll(ctx context.Context, username string) (*TOTPEnrollment, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      m.issuer,
+		AccountName: username,
+		Period:      mfaPeriod,
+		Digits:      mfaDigits,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.db.Transaction(ctx, func(ctx context.Context) error {
+		q := m.db.Queries()
+		user, err := q.GetUserByUsername(ctx, username)
+		if err != nil {
+			return notFound(err, ErrUserNotFound, username)
+		}
+		row, err := q.GetMFA(ctx, user.ID)
+		if err == nil && row.EnabledAt != nil {
+			return ErrMFAEnabled
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if _, err = q.DeleteMFA(ctx, user.ID); err != nil {
+			return err
+		}
+		return q.CreateMFA(ctx, user.ID, key.Secret())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := key.Image(mfaQRCodeSize, mfaQRCodeSize)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{
+		Secret: key.Secret(),
+		URI:    key.URL(),
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// Activate enables the pending enrollment by the first code of the authenticator app, it returns the recovery codes,
+// which are shown once
+func (m *MFAManager) Activate(ctx context.Context, username, code string) ([]string, error) {
+	var codes []string
+	err := m.db.Transaction(ctx, func(ctx context.Context) error {
+		q := m.db.Queries()
+		user, err := q.GetUserByUsername(ctx, username)
+		if err != nil {
+			return notFound(err, ErrUserNotFound, username)
+		}
+		row, err := q.GetMFA(ctx, user.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrMFANotEnrolled
+		}
+		if err != nil {
+			return err
+		}
+		if row.EnabledAt != nil {
+			return ErrMFAEnabled
+		}
+
+		step, ok := m.validate(row.Secret, code)
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		if n, err := q.EnableMFA(ctx, step, user.ID); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrMFAEnabled
+		}
+
+		codes, err = m.resetRecoveryCodes(ctx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+// Verify checks the TOTP code or a recovery code of the user, each code is accepted once
+func (m *MFAManager) Verify(ctx context.Context, username, code string) error {
+	q := m.db.Queries()
+	user, err := q.GetUserByUsername(ctx, username)
+	if err != nil {
+		return notFound(err, ErrUserNotFound, username)
+	}
+	row, err := q.GetMFA(ctx, user.ID)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && row.EnabledAt == nil {
+		return ErrMFANotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == mfaDigits.Length() {
+		step, ok := m.validate(row.Secret, code)
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		// the step only moves forward, so that an intercepted code can't be replayed
+		n, err := q.UseMFAStep(ctx, step, user.ID)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	n, err := q.UseRecoveryCode(ctx, user.ID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user, the old ones are no longer accepted
+func (m *MFAManager) RegenerateRecoveryCodes(ctx context.Context, username string) ([]string, error) {
+	var codes []string
+	err := m.db.Transaction(ctx, func(ctx context.Context) error {
+		q := m.db.Queries()
+		user, err := q.GetUserByUsername(ctx, username)
+		if err != nil {
+			return notFound(err, ErrUserNotFound, username)
+		}
+		row, err := q.GetMFA(ctx, user.ID)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && row.EnabledAt == nil {
+			return ErrMFANotEnrolled
+		}
+		if err != nil {
+			return err
+		}
+
+		codes, err = m.resetRecoveryCodes(ctx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+// Disable removes the secret and the recovery codes of the user, e.g. if the device is lost
+func (m *MFAManager) Disable(ctx context.Context, username string) error {
+	return m.db.Transaction(ctx, func(ctx context.Context) error {
+		q := m.db.Queries()
+		user, err := q.GetUserByUsername(ctx, username)
+		if err != nil {
+			return notFound(err, ErrUserNotFound, username)
+		}
+		n, err := q.DeleteMFA(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrMFANotEnrolled
+		}
+		return q.DeleteRecoveryCodes(ctx, user.ID)
+	})
+}
+
+// SetRoleRequired requires MFA of the users of the role, or makes it optional again
+func (m *MFAManager) SetRoleRequired(ctx context.Context, role string, required bool) error {
+	q := m.db.Queries()
+	r, err := q.GetRoleByName(ctx, role)
+	if err != nil {
+		return notFound(err, ErrRoleNotFound, role)
+	}
+	return q.SetRoleMFARequired(ctx, required, r.ID)
+}
+
+// validate returns the time step of the code if it matches the secret within the skew
+func (m *MFAManager) validate(secret, code string) (int64, bool) {
+	now := time.Now
+	if m.Now != nil {
+		now = m.Now
+	}
+	t := now()
+
+	opts := totp.ValidateOpts{Period: mfaPeriod, Digits: mfaDigits, Algorithm: otp.AlgorithmSHA1}
+	for i := -mfaSkew; i <= mfaSkew; i++ {
+		at := t.Add(time.Duration(i*mfaPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, at, opts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return at.Unix() / mfaPeriod, true
+		}
+	}
+	return 0, false
+}
+
+func (m *MFAManager) resetRecoveryCodes(ctx context.Context, userID int64) ([]string, error) {
+	q := m.db.Queries()
+	if err := q.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, mfaRecoveryCodes)
+	for i := 0; i < mfaRecoveryCodes; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		// e.g. abcd-efgh-ijkl-mnop
+		s := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		code := s[:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:]
+		if err := q.CreateRecoveryCode(ctx, userID, hashRecoveryCode(code)); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode hashes the normalized code, SHA-256 suffices for random codes like the API keys
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
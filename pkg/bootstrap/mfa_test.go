@@ -0,0 +1,100 @@
+package bootstrap_test
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+)
+
+func TestMFAManager(t *testing.T) {
+	db := newTestDB(t, true)
+	ctx := context.Background()
+	um := bootstrap.NewUserManager(db)
+	require.NoError(t, um.CreateUser(ctx, "alice", "secret", "alice@example.com"))
+	require.NoError(t, um.AddRole(ctx, "alice", "admin"))
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mfa := bootstrap.NewMFAManager(db, "gin-starter")
+	mfa.Now = func() time.Time { return now }
+	code := func(secret string, at time.Time) string {
+		c, err := totp.GenerateCode(secret, at)
+		require.NoError(t, err)
+		return c
+	}
+
+	status, err := mfa.Status(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, bootstrap.MFAStatus{}, *status)
+	assert.ErrorIs(t, mfa.Verify(ctx, "alice", "123456"), bootstrap.ErrMFANotEnrolled)
+
+	// the pending enrollment is replaced by the next one
+	_, err = mfa.Enroll(ctx, "alice")
+	require.NoError(t, err)
+	enrollment, err := mfa.Enroll(ctx, "alice")
+	require.NoError(t, err)
+	uri, err := url.Parse(enrollment.URI)
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, enrollment.Secret, uri.Query().Get("secret"))
+	assert.Equal(t, "gin-starter", uri.Query().Get("issuer"))
+	assert.Contains(t, enrollment.QRCode, "data:image/png;base64,")
+
+	_, err = mfa.Activate(ctx, "alice", code(enrollment.Secret, now.Add(-time.Hour)))
+	assert.ErrorIs(t, err, bootstrap.ErrInvalidMFACode)
+	recovery, err := mfa.Activate(ctx, "alice", code(enrollment.Secret, now))
+	require.NoError(t, err)
+	assert.Len(t, recovery, 10)
+	_, err = mfa.Enroll(ctx, "alice")
+	assert.ErrorIs(t, err, bootstrap.ErrMFAEnabled)
+
+	status, err = mfa.Status(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, bootstrap.MFAStatus{Enabled: true, RecoveryCodes: 10}, *status)
+
+	// the code of the activation can't be replayed, the code of the next step can
+	assert.ErrorIs(t, mfa.Verify(ctx, "alice", code(enrollment.Secret, now)), bootstrap.ErrInvalidMFACode)
+	now = now.Add(30 * time.Second)
+	require.NoError(t, mfa.Verify(ctx, "alice", code(enrollment.Secret, now)))
+	assert.ErrorIs(t, mfa.Verify(ctx, "alice", code(enrollment.Secret, now)), bootstrap.ErrInvalidMFACode)
+
+	// one step of clock skew is tolerated
+	now = now.Add(5 * time.Minute)
+	require.NoError(t, mfa.Verify(ctx, "alice", code(enrollment.Secret, now.Add(30*time.Second))))
+	now = now.Add(5 * time.Minute)
+	assert.ErrorIs(t, mfa.Verify(ctx, "alice", code(enrollment.Secret, now.Add(90*time.Second))), bootstrap.ErrInvalidMFACode)
+
+	// the recovery codes are used once, in any case and with or without dashes
+	require.NoError(t, mfa.Verify(ctx, "alice", recovery[0]))
+	assert.ErrorIs(t, mfa.Verify(ctx, "alice", recovery[0]), bootstrap.ErrInvalidMFACode)
+	require.NoError(t, mfa.Verify(ctx, "alice", " "+strings.ToUpper(strings.ReplaceAll(recovery[1], "-", ""))+" "))
+	status, err = mfa.Status(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 8, status.RecoveryCodes)
+
+	regenerated, err := mfa.RegenerateRecoveryCodes(ctx, "alice")
+	require.NoError(t, err)
+	assert.ErrorIs(t, mfa.Verify(ctx, "alice", recovery[2]), bootstrap.ErrInvalidMFACode)
+	require.NoError(t, mfa.Verify(ctx, "alice", regenerated[0]))
+
+	// required by the role
+	assert.ErrorIs(t, mfa.SetRoleRequired(ctx, "nobody", true), bootstrap.ErrRoleNotFound)
+	require.NoError(t, mfa.SetRoleRequired(ctx, "admin", true))
+	require.NoError(t, mfa.Disable(ctx, "alice"))
+	status, err = mfa.Status(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, bootstrap.MFAStatus{Required: true}, *status)
+	assert.ErrorIs(t, mfa.Disable(ctx, "alice"), bootstrap.ErrMFANotEnrolled)
+
+	// the user is deleted with its MFA
+	_, err = mfa.Enroll(ctx, "alice")
+	require.NoError(t, err)
+	require.NoError(t, um.DeleteUser(ctx, "alice"))
+}
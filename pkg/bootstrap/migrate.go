@@ -0,0 +1,429 @@
+package bootstrap
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/robinmin/gin-starter/schema"
+)
+
+const (
+	// table recording the applied migrations
+	migrationTable = "schema_migrations"
+
+	// table holding the migration lock of databases without advisory locks
+	migrationLockTable = "schema_migrations_lock"
+
+	// name of the advisory lock on MySQL, and the key of it on PostgreSQL
+	migrationLockName = "schema_migrations"
+	migrationLockKey  = int64(0x6d6967726174696f) // "migratio"
+
+	// locks older than this are left over by crashed processes
+	migrationLockStale = 15 * time.Minute
+)
+
+var ErrNoMigration = errors.New("no migration to roll back")
+
+// Migration is one versioned schema change, parsed from a file named like 00001_init.sql
+type Migration struct {
+	Version int64
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// MigrationStatus tells whether a migration has been applied
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Migrator applies the embedded migrations and records them in the schema_migrations table. All operations hold a
+// lock, so that replicas starting at the same time do not race.
+type Migrator struct {
+	db         *sqlx.DB
+	dbtype     string
+	migrations []Migration
+
+	// how long to wait for the lock held by another process
+	LockTimeout time.Duration
+}
+
+// NewMigrator loads the migrations from fsys, which contains the files of the database type
+func NewMigrator(db *DBToolKit, dbtype string, fsys fs.FS) (*Migrator, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("no migrations for database type %s", dbtype)
+	}
+
+	return &Migrator{
+		db:          db.DB,
+		dbtype:      dbtype,
+		migrations:  migrations,
+		LockTimeout: time.Minute,
+	}, nil
+}
+
+// NewSchemaMigrator creates a migrator with the migrations embedded for the database type
+func NewSchemaMigrator(db *DBToolKit, dbtype string) (*Migrator, error) {
+	fsys, err := schema.Migrations(dbtype)
+	if err != nil {
+		return nil, err
+	}
+	return NewMigrator(db, dbtype, fsys)
+}
+
+// LoadMigrations parses all *.sql files in fsys, sorted by version
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	versions := map[int64]string{}
+	for _, file := range files {
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(path.Base(file), ".sql"), "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %s, expected <version>_<name>.sql", file)
+		}
+		if other, ok := versions[version]; ok {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", version, other, file)
+		}
+		versions[version] = file
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		up, down, err := parseMigration(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, Up: up, Down: down})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// parseMigration splits a migration file into the statements of the up and down sections. Statements end with a
+// semicolon at the end of a line, unless they are wrapped in `-- +goose StatementBegin` and `-- +goose StatementEnd`.
+func parseMigration(script string) (up []string, down []string, err error) {
+	var (
+		section *[]string
+		stmt    strings.Builder
+		inBlock bool
+	)
+	flush := func() {
+		if s := strings.TrimSpace(stmt.String()); s != "" && section != nil {
+			*section = append(*section, s)
+		}
+		stmt.Reset()
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(script))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "-- +goose ") {
+			switch strings.TrimSpace(strings.TrimPrefix(trimmed, "-- +goose ")) {
+			case "Up":
+				section = &up
+			case "Down":
+				section = &down
+			case "StatementBegin":
+				inBlock = true
+			case "StatementEnd":
+				inBlock = false
+				flush()
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "--") || (trimmed == "" && stmt.Len() == 0) {
+			continue
+		}
+		if section == nil {
+			return nil, nil, errors.New("statement before -- +goose Up")
+		}
+
+		stmt.WriteString(line)
+		stmt.WriteString("\n")
+		if code, _, _ := strings.Cut(trimmed, "--"); !inBlock && strings.HasSuffix(strings.TrimSpace(code), ";") {
+			flush()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if inBlock {
+		return nil, nil, errors.New("missing -- +goose StatementEnd")
+	}
+	if strings.TrimSpace(stmt.String()) != "" {
+		return nil, nil, errors.New("statement without trailing semicolon")
+	}
+	return up, down, nil
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Up applies all pending migrations and returns them
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.UpTo(ctx, 0)
+}
+
+// UpTo applies the pending migrations up to the version, or all of them if version is 0
+func (m *Migrator) UpTo(ctx context.Context, version int64) (applied []Migration, err error) {
+	err = m.withLock(ctx, func() error {
+		done, err := m.appliedVersions(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if version > 0 && migration.Version > version {
+				break
+			}
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, migration, true); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the latest applied migration and returns it
+func (m *Migrator) Down(ctx context.Context) (migration *Migration, err error) {
+	err = m.withLock(ctx, func() error {
+		migration, err = m.rollback(ctx)
+		return err
+	})
+	return migration, err
+}
+
+// Redo rolls back the latest applied migration and applies it again
+func (m *Migrator) Redo(ctx context.Context) (migration *Migration, err error) {
+	err = m.withLock(ctx, func() error {
+		if migration, err = m.rollback(ctx); err != nil {
+			return err
+		}
+		return m.apply(ctx, *migration, true)
+	})
+	return migration, err
+}
+
+// Status lists all migrations, together with the applied ones which are unknown to this binary
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.ensureTables(ctx); err != nil {
+		return nil, err
+	}
+	done, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []MigrationStatus
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if rec, ok := done[migration.Version]; ok {
+			status.AppliedAt = &rec.AppliedAt
+			delete(done, migration.Version)
+		}
+		result = append(result, status)
+	}
+	for _, rec := range done {
+		appliedAt := rec.AppliedAt
+		result = append(result, MigrationStatus{Version: rec.Version, Name: rec.Name, AppliedAt: &appliedAt})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type migrationRecord struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+func (m *Migrator) appliedVersions(ctx context.Context) (map[int64]migrationRecord, error) {
+	var records []migrationRecord
+	err := m.db.SelectContext(ctx, &records, "SELECT version, name, applied_at FROM "+migrationTable)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(map[int64]migrationRecord, len(records))
+	for _, rec := range records {
+		done[rec.Version] = rec
+	}
+	return done, nil
+}
+
+func (m *Migrator) rollback(ctx context.Context) (*Migration, error) {
+	var version int64
+	err := m.db.GetContext(ctx, &version, "SELECT COALESCE(MAX(version), 0) FROM "+migrationTable)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		return nil, ErrNoMigration
+	}
+
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i], m.apply(ctx, m.migrations[i], false)
+		}
+	}
+	return nil, fmt.Errorf("applied migration %d is unknown to this binary", version)
+}
+
+// apply runs the up or down statements of a migration and records it in one transaction. Note that MySQL commits DDL
+// statements implicitly, so a failed migration there may need to be cleaned up manually.
+func (m *Migrator) apply(ctx context.Context, migration Migration, up bool) error {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmts := migration.Up
+	if !up {
+		stmts = migration.Down
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, m.db.Rebind("INSERT INTO "+migrationTable+" (version, name, applied_at) VALUES (?, ?, ?)"),
+			migration.Version, migration.Name, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, m.db.Rebind("DELETE FROM "+migrationTable+" WHERE version = ?"), migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) ensureTables(ctx context.Context) error {
+	stmts := []string{
+		"CREATE TABLE IF NOT EXISTS " + migrationTable + ` (
+  version BIGINT NOT NULL PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  applied_at TIMESTAMP NOT NULL
+)`,
+	}
+	if m.dbtype == "sqlite3" {
+		stmts = append(stmts, "CREATE TABLE IF NOT EXISTS "+migrationLockTable+` (
+  id INTEGER NOT NULL PRIMARY KEY,
+  locked_at TIMESTAMP NOT NULL
+)`)
+	}
+
+	for _, stmt := range stmts {
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withLock runs fn while holding the migration lock. MySQL and PostgreSQL use advisory locks bound to a dedicated
+// connection; SQLite has no such thing, so a row in the lock table plays the role.
+func (m *Migrator) withLock(ctx context.Context, fn func() error) error {
+	if err := m.ensureTables(ctx); err != nil {
+		return err
+	}
+
+	lockCtx, cancel := context.WithTimeout(ctx, m.LockTimeout)
+	defer cancel()
+
+	switch m.dbtype {
+	case "mysql":
+		conn, err := m.db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		var locked sql.NullInt64
+		err = conn.QueryRowContext(lockCtx, "SELECT GET_LOCK(?, ?)", migrationLockName, int(m.LockTimeout.Seconds())).Scan(&locked)
+		if err != nil {
+			return err
+		}
+		if locked.Int64 != 1 {
+			return errors.New("timeout waiting for the migration lock")
+		}
+		defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", migrationLockName)
+	case "postgres":
+		conn, err := m.db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		// the query is canceled by the driver once lockCtx expires
+		if _, err = conn.ExecContext(lockCtx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+			return fmt.Errorf("failed to take the migration lock: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	default:
+		if err := m.lockTable(lockCtx); err != nil {
+			return err
+		}
+		defer m.db.ExecContext(context.Background(), "DELETE FROM "+migrationLockTable+" WHERE id = 1")
+	}
+
+	return fn()
+}
+
+func (m *Migrator) lockTable(ctx context.Context) error {
+	for {
+		// take over the lock left by a crashed process
+		_, err := m.db.ExecContext(ctx, m.db.Rebind("DELETE FROM "+migrationLockTable+" WHERE locked_at < ?"),
+			time.Now().UTC().Add(-migrationLockStale))
+		if err != nil {
+			return err
+		}
+
+		_, err = m.db.ExecContext(ctx, m.db.Rebind("INSERT INTO "+migrationLockTable+" (id, locked_at) VALUES (1, ?)"), time.Now().UTC())
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout waiting for the migration lock: %w", err)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package bootstrap_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+func newTestDB(t *testing.T, autoMigrate bool) *bootstrap.DBToolKit {
+	t.Helper()
+	cfg := *bootstrap.NewInstance[types.AppConfig]()
+	cfg.Database.Type = "sqlite3"
+	cfg.Database.Database = filepath.Join(t.TempDir(), "test.db")
+	cfg.Database.AutoMigrate = autoMigrate
+
+	db, err := bootstrap.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrator(t *testing.T) {
+	fsys := fstest.MapFS{
+		"00001_users.sql": {Data: []byte(`-- +goose Up
+CREATE TABLE t_users (
+  id INTEGER PRIMARY KEY, -- ID
+  name VARCHAR(64) NOT NULL
+);
+
+-- +goose Down
+DROP TABLE t_users;
+`)},
+		"00002_audit.sql": {Data: []byte(`-- +goose Up
+CREATE TABLE t_audit (id INTEGER PRIMARY KEY, user_id INTEGER);
+-- +goose StatementBegin
+CREATE TRIGGER t_users_audit AFTER INSERT ON t_users
+BEGIN
+  INSERT INTO t_audit (user_id) VALUES (NEW.id);
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER t_users_audit;
+DROP TABLE t_audit;
+`)},
+	}
+
+	db := newTestDB(t, false)
+	migrator, err := bootstrap.NewMigrator(db, "sqlite3", fsys)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	applied, err := migrator.UpTo(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, applied, 1)
+	assert.Equal(t, "users", applied[0].Name)
+
+	applied, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Len(t, applied, 1)
+	assert.Len(t, applied[0].Up, 2)
+
+	_, err = db.Exec("INSERT INTO t_users (name) VALUES ('alice')")
+	require.NoError(t, err)
+	var n int
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM t_audit").Scan(&n))
+	assert.Equal(t, 1, n)
+
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.NotNil(t, statuses[1].AppliedAt)
+
+	migration, err := migrator.Down(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), migration.Version)
+	statuses, err = migrator.Status(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, statuses[1].AppliedAt)
+
+	_, err = migrator.Redo(ctx)
+	require.NoError(t, err)
+	_, err = migrator.Down(ctx)
+	require.NoError(t, err)
+	_, err = migrator.Down(ctx)
+	assert.ErrorIs(t, err, bootstrap.ErrNoMigration)
+}
+
+func TestMigratorLock(t *testing.T) {
+	db := newTestDB(t, true)
+	migrator, err := bootstrap.NewSchemaMigrator(db, "sqlite3")
+	require.NoError(t, err)
+
+	// the embedded migrations have been applied by NewDB already
+	statuses, err := migrator.Status(context.Background())
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.NotNil(t, status.AppliedAt, status.Name)
+	}
+
+	// another process holds the lock
+	_, err = db.Exec("INSERT INTO schema_migrations_lock (id, locked_at) VALUES (1, ?)", time.Now().UTC())
+	require.NoError(t, err)
+	migrator.LockTimeout = 300 * time.Millisecond
+	_, err = migrator.Up(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,107 @@
+package bootstrap
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cache/persistence"
+)
+
+const (
+	// OneTimeTokenPrefix keys the one-time tokens in the cache store
+	OneTimeTokenPrefix = "sys_ott_"
+
+	PurposePasswordReset = "password_reset"
+	PurposeVerifyEmail   = "verify_email"
+)
+
+var ErrInvalidOneTimeToken = errors.New("invalid, expired or used token")
+
+// OneTimeToken is stored for the issued tokens until they are used or expire
+type OneTimeToken struct {
+	Purpose  string
+	Username string
+	Email    string // the email the token was sent to
+	Expires  time.Time
+}
+
+// OneTimeTokens issues the signed, single-use and expiring tokens of the links mailed to the users. Only the latest
+// token of a user and purpose is valid.
+type OneTimeTokens struct {
+	store  persistence.CacheStore
+	secret []byte
+}
+
+func NewOneTimeTokens(store persistence.CacheStore, secret string) *OneTimeTokens {
+	return &OneTimeTokens{store: store, secret: []byte(secret)}
+}
+
+// Issue returns a new token of the purpose for the user, it supersedes the previous one
+func (t *OneTimeTokens) Issue(purpose, username, email string, ttl time.Duration) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+
+	entry := OneTimeToken{Purpose: purpose, Username: username, Email: email, Expires: time.Now().Add(ttl)}
+	if err := t.store.Set(OneTimeTokenPrefix+id, entry, ttl); err != nil {
+		return "", err
+	}
+	if err := t.store.Set(t.latestKey(purpose, username), id, ttl); err != nil {
+		return "", err
+	}
+	return id + "." + t.sign(purpose, id), nil
+}
+
+// Consume verifies the token of the purpose and invalidates it
+func (t *OneTimeTokens) Consume(purpose, token string) (*OneTimeToken, error) {
+	id, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(t.sign(purpose, id))) {
+		return nil, ErrInvalidOneTimeToken
+	}
+
+	var entry OneTimeToken
+	err := t.store.Get(OneTimeTokenPrefix+id, &entry)
+	if err == persistence.ErrCacheMiss {
+		return nil, ErrInvalidOneTimeToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if entry.Purpose != purpose || !time.Now().Before(entry.Expires) {
+		return nil, ErrInvalidOneTimeToken
+	}
+
+	var latest string
+	if err = t.store.Get(t.latestKey(purpose, entry.Username), &latest); err != nil && err != persistence.ErrCacheMiss {
+		return nil, err
+	}
+	if latest != id {
+		return nil, ErrInvalidOneTimeToken
+	}
+
+	// whoever deletes it first uses it, which is only atomic for the in-memory store
+	if err = t.store.Delete(OneTimeTokenPrefix + id); err == persistence.ErrCacheMiss {
+		return nil, ErrInvalidOneTimeToken
+	} else if err != nil {
+		return nil, err
+	}
+	_ = t.store.Delete(t.latestKey(purpose, entry.Username))
+	return &entry, nil
+}
+
+func (t *OneTimeTokens) sign(purpose, id string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(purpose + "." + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (t *OneTimeTokens) latestKey(purpose, username string) string {
+	return OneTimeTokenPrefix + purpose + "_" + username
+}
@@ -0,0 +1,98 @@
+package bootstrap_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/cache/persistence"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/utility"
+)
+
+func TestOneTimeTokens(t *testing.T) {
+	store := persistence.NewInMemoryStore(time.Minute)
+	tokens := bootstrap.NewOneTimeTokens(store, "secret")
+
+	token, err := tokens.Issue(bootstrap.PurposePasswordReset, "alice", "alice@example.com", time.Minute)
+	require.NoError(t, err)
+
+	// signed by the secret and bound to the purpose
+	_, err = bootstrap.NewOneTimeTokens(store, "other").Consume(bootstrap.PurposePasswordReset, token)
+	assert.ErrorIs(t, err, bootstrap.ErrInvalidOneTimeToken)
+	_, err = tokens.Consume(bootstrap.PurposeVerifyEmail, token)
+	assert.ErrorIs(t, err, bootstrap.ErrInvalidOneTimeToken)
+	id, _, _ := strings.Cut(token, ".")
+	_, err = tokens.Consume(bootstrap.PurposePasswordReset, id)
+	assert.ErrorIs(t, err, bootstrap.ErrInvalidOneTimeToken)
+
+	entry, err := tokens.Consume(bootstrap.PurposePasswordReset, token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", entry.Username)
+	assert.Equal(t, "alice@example.com", entry.Email)
+	_, err = tokens.Consume(bootstrap.PurposePasswordReset, token)
+	assert.ErrorIs(t, err, bootstrap.ErrInvalidOneTimeToken)
+
+	// only the latest token is valid
+	first, err := tokens.Issue(bootstrap.PurposeVerifyEmail, "alice", "alice@example.com", time.Minute)
+	require.NoError(t, err)
+	second, err := tokens.Issue(bootstrap.PurposeVerifyEmail, "alice", "alice@example.com", time.Minute)
+	require.NoError(t, err)
+	_, err = tokens.Consume(bootstrap.PurposeVerifyEmail, first)
+	assert.ErrorIs(t, err, bootstrap.ErrInvalidOneTimeToken)
+	_, err = tokens.Consume(bootstrap.PurposeVerifyEmail, second)
+	require.NoError(t, err)
+
+	// expired
+	token, err = tokens.Issue(bootstrap.PurposeVerifyEmail, "alice", "alice@example.com", time.Nanosecond)
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+	_, err = tokens.Consume(bootstrap.PurposeVerifyEmail, token)
+	assert.ErrorIs(t, err, bootstrap.ErrInvalidOneTimeToken)
+}
+
+func TestVerifyEmail(t *testing.T) {
+	db := newTestDB(t, true)
+	ctx := context.Background()
+	um := bootstrap.NewUserManager(db)
+	require.NoError(t, um.CreateUser(ctx, "alice", "secret", "alice@example.com"))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", func(c *gin.Context) {
+		if username := c.GetHeader("X-User"); username != "" {
+			c.Request = c.Request.WithContext(utility.NewUserID(c.Request.Context(), username))
+		}
+	}, um.RequireVerifiedEmail(), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	get := func(username string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User", username)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get(""))
+	assert.Equal(t, http.StatusUnauthorized, get("nobody"))
+	assert.Equal(t, http.StatusForbidden, get("alice"))
+
+	// the token of an old email doesn't verify the current one
+	assert.ErrorIs(t, um.VerifyEmail(ctx, "alice", "old@example.com"), bootstrap.ErrUserNotFound)
+	require.NoError(t, um.VerifyEmail(ctx, "alice", "alice@example.com"))
+	require.NoError(t, um.VerifyEmail(ctx, "alice", "alice@example.com"))
+
+	user, err := um.GetUserByEmail(ctx, "alice@example.com")
+	require.NoError(t, err)
+	require.NotNil(t, user.EmailVerifiedAt)
+	assert.Equal(t, http.StatusNoContent, get("alice"))
+
+	_, err = um.GetUserByEmail(ctx, "nobody@example.com")
+	assert.ErrorIs(t, err, bootstrap.ErrUserNotFound)
+}
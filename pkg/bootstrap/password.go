@@ -0,0 +1,124 @@
+package bootstrap
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords with bcrypt or argon2id, and verifies hashes of both formats
+type PasswordHasher struct {
+	Algorithm  string
+	BcryptCost int
+
+	// argon2id parameters, Memory is in KiB
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// DefaultPasswordHasher hashes with bcrypt at the default cost
+var DefaultPasswordHasher = &PasswordHasher{
+	Algorithm:  HashBcrypt,
+	BcryptCost: bcrypt.DefaultCost,
+	Time:       1,
+	Memory:     64 * 1024,
+	Threads:    2,
+}
+
+// NewPasswordHasher creates the hasher configured under middlewares.auth
+func NewPasswordHasher(cfg types.AppConfig) *PasswordHasher {
+	auth := cfg.Middlewares.Auth
+	return &PasswordHasher{
+		Algorithm:  auth.PasswordHash,
+		BcryptCost: auth.BcryptCost,
+		Time:       uint32(auth.Argon2.Time),
+		Memory:     uint32(auth.Argon2.Memory),
+		Threads:    uint8(auth.Argon2.Threads),
+	}
+}
+
+// Hash returns the encoded hash of the password, argon2id hashes use the PHC string format
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	if h.Algorithm == HashArgon2id {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, 32)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Time, h.Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// Verify checks the password against the hash. It also reports whether the hash should be replaced, because it was
+// made with another algorithm or other parameters than the current ones.
+func (h *PasswordHasher) Verify(hash, password string) (ok bool, rehash bool, err error) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, false, err
+		}
+		actual := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(actual, key) != 1 {
+			return false, false, nil
+		}
+		return true, h.Algorithm != HashArgon2id || params.Time != h.Time || params.Memory != h.Memory || params.Threads != h.Threads, nil
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false, false, ErrUnknownHash
+	}
+	if err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	return true, h.Algorithm != HashBcrypt || cost != h.BcryptCost, nil
+}
+
+func decodeArgon2id(hash string) (params PasswordHasher, salt []byte, key []byte, err error) {
+	// $argon2id$v=19$m=65536,t=1,p=2$<salt>$<key>
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %s", parts[2])
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+	return params, salt, key, nil
+}
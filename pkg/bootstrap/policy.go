@@ -0,0 +1,324 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PolicyAuditAdd    = "add"
+	PolicyAuditRemove = "remove"
+
+	// the values are kept in the columns v0..v5 of the policy table
+	maxRuleValues = 6
+	maxRuleLength = 255
+)
+
+var ErrInvalidRule = errors.New("invalid policy rule")
+
+// PolicyRule is a casbin rule, e.g. "p, alice, /data, GET" or "g, alice, admin"
+type PolicyRule struct {
+	PType string   `json:"ptype"`
+	Rule  []string `json:"rule"`
+}
+
+func (r PolicyRule) String() string {
+	return r.PType + ", " + strings.Join(r.Rule, ", ")
+}
+
+// PolicyFilter selects the rules, the empty fields match any rule
+type PolicyFilter struct {
+	PType   string
+	Subject string // the first value, the user or role
+	Object  string // the object of p rules or the role of g rules
+	Tenant  string // the domain of the rules of a model with domains
+}
+
+// PolicyAudit is a recorded change of the policy
+type PolicyAudit struct {
+	ID        int        `json:"id"`
+	Actor     string     `json:"actor"`
+	Action    string     `json:"action"`
+	Rule      PolicyRule `json:"rule"`
+	CreatedAt *time.Time `json:"created_at"`
+}
+
+// PolicyManager changes the casbin policy of the authorizer, the rules are validated against the model and every
+// change is recorded in the audit trail
+type PolicyManager struct {
+	author *Authorizer
+	db     *DBToolKit
+	mu     sync.Mutex // one change at a time, e.g. an import
+}
+
+func NewPolicyManager(author *Authorizer, db *DBToolKit) *PolicyManager {
+	return &PolicyManager{author: author, db: db}
+}
+
+// Rules lists the rules matching the filter, the p rules first
+func (pm *PolicyManager) Rules(filter PolicyFilter) []PolicyRule {
+	rules := []PolicyRule{}
+	for _, ptype := range pm.ptypes() {
+		if filter.PType != "" && filter.PType != ptype {
+			continue
+		}
+
+		var values [][]string
+		if strings.HasPrefix(ptype, "p") {
+			values = pm.author.enforcer.GetNamedPolicy(ptype)
+		} else {
+			values = pm.author.enforcer.GetNamedGroupingPolicy(ptype)
+		}
+		obj, dom := pm.index(ptype, "obj"), pm.index(ptype, "dom")
+		for _, rule := range values {
+			if matchValue(rule, 0, filter.Subject) && matchValue(rule, obj, filter.Object) && matchValue(rule, dom, filter.Tenant) {
+				rules = append(rules, PolicyRule{PType: ptype, Rule: rule})
+			}
+		}
+	}
+	return rules
+}
+
+// Validate checks the rule against the policy and role definitions of the model
+func (pm *PolicyManager) Validate(rule PolicyRule) error {
+	sec := rule.PType
+	if len(sec) > 0 {
+		sec = sec[:1]
+	}
+	ast, ok := pm.author.enforcer.GetModel()[sec][rule.PType]
+	if !ok || sec != "p" && sec != "g" {
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidRule, rule.PType)
+	}
+	if len(rule.Rule) != len(ast.Tokens) || len(rule.Rule) > maxRuleValues {
+		return fmt.Errorf("%w: %s needs %d values", ErrInvalidRule, rule.PType, len(ast.Tokens))
+	}
+	for _, value := range rule.Rule {
+		switch {
+		case value == "" || strings.TrimSpace(value) != value:
+			return fmt.Errorf("%w: empty or padded value %q", ErrInvalidRule, value)
+		case strings.ContainsAny(value, ",\r\n"):
+			return fmt.Errorf("%w: comma or line break in %q", ErrInvalidRule, value)
+		case len(value) > maxRuleLength:
+			return fmt.Errorf("%w: value longer than %d", ErrInvalidRule, maxRuleLength)
+		}
+	}
+	return nil
+}
+
+// Add adds the rules which don't exist yet, and returns how many were added. The rules are validated before any is
+// added.
+func (pm *PolicyManager) Add(ctx context.Context, actor string, rules ...PolicyRule) (int, error) {
+	if err := pm.validate(rules); err != nil {
+		return 0, err
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.apply(ctx, actor, PolicyAuditAdd, rules)
+}
+
+// Remove removes the rules which exist, and returns how many were removed
+func (pm *PolicyManager) Remove(ctx context.Context, actor string, rules ...PolicyRule) (int, error) {
+	if err := pm.validate(rules); err != nil {
+		return 0, err
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.apply(ctx, actor, PolicyAuditRemove, rules)
+}
+
+// Import adds the rules, and removes all other rules if replace is set. It isn't atomic, the rules are changed one by
+// one.
+func (pm *PolicyManager) Import(ctx context.Context, actor string, rules []PolicyRule, replace bool) (added int, removed int, err error) {
+	if err = pm.validate(rules); err != nil {
+		return 0, 0, err
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if replace {
+		keep := map[string]bool{}
+		for _, rule := range rules {
+			keep[rule.String()] = true
+		}
+		var stale []PolicyRule
+		for _, rule := range pm.Rules(PolicyFilter{}) {
+			if !keep[rule.String()] {
+				stale = append(stale, rule)
+			}
+		}
+		if removed, err = pm.apply(ctx, actor, PolicyAuditRemove, stale); err != nil {
+			return 0, removed, err
+		}
+	}
+	added, err = pm.apply(ctx, actor, PolicyAuditAdd, rules)
+	return added, removed, err
+}
+
+// Audit returns the latest changes of the policy, the latest first
+func (pm *PolicyManager) Audit(ctx context.Context, limit int) ([]PolicyAudit, error) {
+	rows, err := pm.db.Queries().ListPolicyAudit(ctx, int64(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]PolicyAudit, 0, len(rows))
+	for _, row := range rows {
+		entries = append(entries, PolicyAudit{
+			ID:        int(row.ID),
+			Actor:     row.Actor,
+			Action:    row.Action,
+			Rule:      PolicyRule{PType: row.Ptype, Rule: strings.Split(row.Rule, ", ")},
+			CreatedAt: row.CreatedAt,
+		})
+	}
+	return entries, nil
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (pm *PolicyManager) validate(rules []PolicyRule) error {
+	for _, rule := range rules {
+		if err := pm.Validate(rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply adds or removes the rules and records the changed ones, it returns how many were changed
+func (pm *PolicyManager) apply(ctx context.Context, actor, action string, rules []PolicyRule) (int, error) {
+	n := 0
+	for _, rule := range rules {
+		params := make([]interface{}, len(rule.Rule))
+		for i, value := range rule.Rule {
+			params[i] = value
+		}
+
+		var (
+			ok  bool
+			err error
+		)
+		grouping := strings.HasPrefix(rule.PType, "g")
+		switch {
+		case action == PolicyAuditAdd && grouping:
+			ok, err = pm.author.enforcer.AddNamedGroupingPolicy(rule.PType, params...)
+		case action == PolicyAuditAdd:
+			ok, err = pm.author.enforcer.AddNamedPolicy(rule.PType, params...)
+		case grouping:
+			ok, err = pm.author.enforcer.RemoveNamedGroupingPolicy(rule.PType, params...)
+		default:
+			ok, err = pm.author.enforcer.RemoveNamedPolicy(rule.PType, params...)
+		}
+		if err != nil {
+			return n, err
+		}
+		if !ok {
+			continue
+		}
+
+		n++
+		if err = pm.db.Queries().CreatePolicyAudit(ctx, actor, action, rule.PType, strings.Join(rule.Rule, ", ")); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// index returns the position of the field in the rules of the type, -1 if they have none. The fields of the p rules
+// are named by the policy definition, e.g. 2 of obj in "p = sub, dom, obj, act". The g rules are (user, role) or
+// (user, role, domain).
+func (pm *PolicyManager) index(ptype string, field string) int {
+	sec := ptype[:1]
+	ast, ok := pm.author.enforcer.GetModel()[sec][ptype]
+	if !ok {
+		return -1
+	}
+	if sec == "g" {
+		switch {
+		case field == "obj":
+			return 1
+		case field == "dom" && len(ast.Tokens) == 3:
+			return 2
+		}
+		return -1
+	}
+
+	for i, token := range ast.Tokens {
+		if token == ptype+"_"+field {
+			return i
+		}
+	}
+	if field == "obj" {
+		return 1
+	}
+	return -1
+}
+
+// matchValue reports whether the value at i of the rule is the wanted one, any value is wanted if empty
+func matchValue(rule []string, i int, want string) bool {
+	return want == "" || i >= 0 && i < len(rule) && rule[i] == want
+}
+
+// ptypes returns the rule types of the model, e.g. p and g
+func (pm *PolicyManager) ptypes() []string {
+	var ptypes []string
+	model := pm.author.enforcer.GetModel()
+	for _, sec := range []string{"p", "g"} {
+		names := make([]string, 0, len(model[sec]))
+		for ptype := range model[sec] {
+			names = append(names, ptype)
+		}
+		sort.Strings(names)
+		ptypes = append(ptypes, names...)
+	}
+	return ptypes
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// ParsePolicyCSV reads the rules in the format of the casbin policy files, e.g. "p, alice, /data, GET". Empty lines
+// and the lines starting with # are skipped.
+func ParsePolicyCSV(r io.Reader) ([]PolicyRule, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var rules []PolicyRule
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rules, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+		if len(record) == 1 && record[0] == "" {
+			continue
+		}
+		rules = append(rules, PolicyRule{PType: record[0], Rule: record[1:]})
+	}
+}
+
+// WritePolicyCSV writes the rules in the format of the casbin policy files
+func WritePolicyCSV(w io.Writer, rules []PolicyRule) error {
+	for _, rule := range rules {
+		if _, err := io.WriteString(w, rule.String()+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package bootstrap_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+)
+
+func TestPolicyManager(t *testing.T) {
+	db := newTestDB(t, true)
+	ctx := context.Background()
+	author, err := bootstrap.NewAuthorizerWithDB("../../config/rbac_model.conf", "auth_rules", db)
+	require.NoError(t, err)
+	pm := bootstrap.NewPolicyManager(author, db)
+	p := func(rule ...string) bootstrap.PolicyRule { return bootstrap.PolicyRule{PType: "p", Rule: rule} }
+	g := func(rule ...string) bootstrap.PolicyRule { return bootstrap.PolicyRule{PType: "g", Rule: rule} }
+
+	// validated against the model before any rule is added
+	for _, rule := range []bootstrap.PolicyRule{
+		p("admin", "/data"),
+		g("alice", "admin", "extra"),
+		{PType: "p2", Rule: []string{"admin", "/data", "GET"}},
+		{PType: "r", Rule: []string{"admin", "/data", "GET"}},
+		p("admin", " /data", "GET"),
+		p("admin", "", "GET"),
+		p("admin", "/a,/b", "GET"),
+	} {
+		assert.ErrorIs(t, pm.Validate(rule), bootstrap.ErrInvalidRule, rule.String())
+	}
+	_, err = pm.Add(ctx, "root", p("admin", "/data", "GET"), p("admin", "/data"))
+	assert.ErrorIs(t, err, bootstrap.ErrInvalidRule)
+	assert.Empty(t, pm.Rules(bootstrap.PolicyFilter{}))
+
+	n, err := pm.Add(ctx, "root", p("admin", "/data", "GET"), p("admin", "/data", "GET"), g("alice", "admin"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	allowed, err := author.Enforcer().Enforce("alice", "/data", "GET")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	// filtered by the type, the subject and the object
+	n, err = pm.Add(ctx, "root", p("user", "/data", "GET"), p("user", "/me", "GET"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []bootstrap.PolicyRule{p("user", "/data", "GET"), p("user", "/me", "GET")}, pm.Rules(bootstrap.PolicyFilter{Subject: "user"}))
+	assert.Equal(t, []bootstrap.PolicyRule{p("admin", "/data", "GET"), p("user", "/data", "GET")}, pm.Rules(bootstrap.PolicyFilter{Object: "/data"}))
+	assert.Equal(t, []bootstrap.PolicyRule{g("alice", "admin")}, pm.Rules(bootstrap.PolicyFilter{PType: "g"}))
+
+	n, err = pm.Remove(ctx, "root", g("alice", "admin"), g("bob", "admin"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	allowed, err = author.Enforcer().Enforce("alice", "/data", "GET")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	// the export is imported again, replacing all other rules
+	var buf bytes.Buffer
+	require.NoError(t, bootstrap.WritePolicyCSV(&buf, pm.Rules(bootstrap.PolicyFilter{PType: "p", Subject: "user"})))
+	assert.Equal(t, "p, user, /data, GET\np, user, /me, GET\n", buf.String())
+	rules, err := bootstrap.ParsePolicyCSV(strings.NewReader("# users\n" + buf.String() + "\ng, bob, user\n"))
+	require.NoError(t, err)
+	added, removed, err := pm.Import(ctx, "root", rules, true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, rules, pm.Rules(bootstrap.PolicyFilter{}))
+
+	// reloaded from the database
+	author, err = bootstrap.NewAuthorizerWithDB("../../config/rbac_model.conf", "auth_rules", db)
+	require.NoError(t, err)
+	assert.Equal(t, rules, bootstrap.NewPolicyManager(author, db).Rules(bootstrap.PolicyFilter{}))
+
+	audit, err := pm.Audit(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, audit, 2)
+	assert.Equal(t, "root", audit[0].Actor)
+	assert.Equal(t, bootstrap.PolicyAuditAdd, audit[0].Action)
+	assert.Equal(t, g("bob", "user"), audit[0].Rule)
+	assert.Equal(t, bootstrap.PolicyAuditRemove, audit[1].Action)
+	assert.Equal(t, p("admin", "/data", "GET"), audit[1].Rule)
+	audit, err = pm.Audit(ctx, 100)
+	require.NoError(t, err)
+	assert.Len(t, audit, 7)
+}
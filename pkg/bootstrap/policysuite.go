@@ -0,0 +1,93 @@
+package bootstrap
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PolicySuite is a YAML test suite of the casbin model and policy, e.g.
+//
+//	model: ../config/rbac_model.conf
+//	policy: policy.csv
+//	cases:
+//	  - name: admins read the data
+//	    request: [alice, /data, GET]
+//	    allow: true
+//
+// The model and the policy are relative to the suite file, they may be left to the command running the suite.
+type PolicySuite struct {
+	Model  string       `yaml:"model,omitempty"`
+	Policy string       `yaml:"policy,omitempty"`
+	Cases  []PolicyCase `yaml:"cases"`
+}
+
+// PolicyCase is a request and its expected decision
+type PolicyCase struct {
+	Name    string   `yaml:"name,omitempty"`
+	Request []string `yaml:"request"` // the values of the request definition of the model, e.g. sub, obj, act
+	Allow   bool     `yaml:"allow"`
+}
+
+// PolicyCaseResult is the decision of a case
+type PolicyCaseResult struct {
+	PolicyCase
+	Allowed bool
+	Matched *PolicyRule // the rule which decided the request, nil if none
+	Err     error
+}
+
+// Passed reports whether the request was decided as expected
+func (r PolicyCaseResult) Passed() bool {
+	return r.Err == nil && r.Allowed == r.Allow
+}
+
+// LoadPolicySuite reads the suite file, the unknown keys are rejected to catch typos like "allowed"
+func LoadPolicySuite(file string) (*PolicySuite, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var suite PolicySuite
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&suite); err != nil {
+		return nil, fmt.Errorf("invalid policy suite %s: %w", file, err)
+	}
+	if len(suite.Cases) == 0 {
+		return nil, fmt.Errorf("invalid policy suite %s: no cases", file)
+	}
+	for i := range suite.Cases {
+		c := &suite.Cases[i]
+		if len(c.Request) == 0 {
+			return nil, fmt.Errorf("invalid policy suite %s: case %d without request", file, i+1)
+		}
+		if c.Name == "" {
+			c.Name = strings.Join(c.Request, ", ")
+		}
+	}
+
+	dir := filepath.Dir(file)
+	for _, path := range []*string{&suite.Model, &suite.Policy} {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	return &suite, nil
+}
+
+// Run decides the requests of all cases by the authorizer
+func (suite *PolicySuite) Run(author *Authorizer) []PolicyCaseResult {
+	results := make([]PolicyCaseResult, 0, len(suite.Cases))
+	for _, c := range suite.Cases {
+		result := PolicyCaseResult{PolicyCase: c}
+		result.Allowed, result.Matched, result.Err = author.Explain(c.Request...)
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,59 @@
+package bootstrap_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+)
+
+func TestPolicySuite(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		file := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(file, []byte(data), 0o600))
+		return file
+	}
+	write("policy.csv", "p, admin, /data, GET\ng, alice, admin\n")
+	model, err := filepath.Abs("../../config/rbac_model.conf")
+	require.NoError(t, err)
+
+	suite, err := bootstrap.LoadPolicySuite(write("suite.yaml", `
+model: `+model+`
+policy: policy.csv
+cases:
+  - name: admins read the data
+    request: [alice, /data, GET]
+    allow: true
+  - request: [bob, /data, GET]
+    allow: true
+  - request: [alice, /data]
+`))
+	require.NoError(t, err)
+	assert.Equal(t, model, suite.Model)
+	assert.Equal(t, filepath.Join(dir, "policy.csv"), suite.Policy)
+
+	author, err := bootstrap.NewAuthorizerWithFiles(suite.Model, suite.Policy)
+	require.NoError(t, err)
+	results := suite.Run(author)
+	require.Len(t, results, 3)
+
+	assert.True(t, results[0].Passed())
+	assert.Equal(t, &bootstrap.PolicyRule{PType: "p", Rule: []string{"admin", "/data", "GET"}}, results[0].Matched)
+	assert.False(t, results[1].Passed())
+	assert.Equal(t, "bob, /data, GET", results[1].Name)
+	assert.False(t, results[1].Allowed)
+	assert.Nil(t, results[1].Matched)
+	assert.False(t, results[2].Passed(), "the request doesn't fit the model")
+	assert.Error(t, results[2].Err)
+
+	// typos are rejected
+	_, err = bootstrap.LoadPolicySuite(write("typo.yaml", "cases: [{request: [alice, /data, GET], allowed: true}]"))
+	assert.Error(t, err)
+	_, err = bootstrap.LoadPolicySuite(write("empty.yaml", "cases: [{allow: true}]"))
+	assert.Error(t, err)
+}
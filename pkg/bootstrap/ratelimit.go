@@ -0,0 +1,94 @@
+package bootstrap
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+const (
+	// idle limiters are dropped after this period
+	rateLimiterIdleTimeout = 10 * time.Minute
+)
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimiter limits requests per client IP with token buckets, its settings can be updated at runtime
+type RateLimiter struct {
+	mu        sync.Mutex
+	enable    bool
+	limit     rate.Limit
+	burst     int
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+func NewRateLimiter(cfg types.AppConfig) *RateLimiter {
+	limiter := &RateLimiter{
+		clients:   map[string]*clientLimiter{},
+		lastSweep: time.Now(),
+	}
+	limiter.Update(cfg)
+	return limiter
+}
+
+// Update applies new rate limit settings to all clients
+func (rl *RateLimiter) Update(cfg types.AppConfig) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.enable = cfg.Middlewares.RateLimit.Enable
+	rl.limit = rate.Limit(cfg.Middlewares.RateLimit.Rate)
+	rl.burst = cfg.Middlewares.RateLimit.Burst
+	for _, client := range rl.clients {
+		client.limiter.SetLimit(rl.limit)
+		client.limiter.SetBurst(rl.burst)
+	}
+}
+
+// Allow reports whether a request from the given client may proceed now
+func (rl *RateLimiter) Allow(clientIP string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if !rl.enable {
+		return true
+	}
+
+	now := time.Now()
+	if now.Sub(rl.lastSweep) > rateLimiterIdleTimeout {
+		for ip, client := range rl.clients {
+			if now.Sub(client.lastSeen) > rateLimiterIdleTimeout {
+				delete(rl.clients, ip)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	client, ok := rl.clients[clientIP]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(rl.limit, rl.burst)}
+		rl.clients[clientIP] = client
+	}
+	client.lastSeen = now
+	return client.limiter.AllowN(now, 1)
+}
+
+func (rl *RateLimiter) Handler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !rl.Allow(ctx.ClientIP()) {
+			ctx.JSON(http.StatusTooManyRequests, NewResult(http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests), nil))
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,130 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+// replica is a read-only database which is dropped from the rotation while its health check fails
+type replica struct {
+	addr    string
+	db      *sqlx.DB
+	healthy atomic.Bool
+}
+
+type replicaSet struct {
+	replicas []*replica
+	counter  atomic.Uint64
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newReplicaSet(cfg types.AppDBConfig) (*replicaSet, error) {
+	if cfg.Type == "sqlite3" {
+		return nil, fmt.Errorf("replicas are not supported by %s", cfg.Type)
+	}
+
+	rs := &replicaSet{stop: make(chan struct{})}
+	for _, addr := range cfg.Replicas {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			rs.close()
+			return nil, fmt.Errorf("invalid replica address %s: %w", addr, err)
+		}
+
+		params := DBParams(cfg)
+		params.Host = host
+		params.Port, _ = strconv.Atoi(port)
+		dsn, err := params.GetDSN()
+		if err != nil {
+			rs.close()
+			return nil, err
+		}
+
+		// connect lazily, an unavailable replica must not prevent the application from starting
+		db, err := sqlx.Open(params.DriverName(), dsn)
+		if err != nil {
+			rs.close()
+			return nil, err
+		}
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+		rs.replicas = append(rs.replicas, &replica{addr: addr, db: db})
+	}
+
+	rs.check()
+	if cfg.HealthCheckInterval > 0 {
+		rs.wg.Add(1)
+		go rs.run(cfg.HealthCheckInterval)
+	}
+	return rs, nil
+}
+
+// next picks the healthy replicas in turn, it returns nil if none is healthy
+func (rs *replicaSet) next() *sqlx.DB {
+	n := uint64(len(rs.replicas))
+	start := rs.counter.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if r := rs.replicas[(start+i)%n]; r.healthy.Load() {
+			return r.db
+		}
+	}
+	return nil
+}
+
+func (rs *replicaSet) run(interval time.Duration) {
+	defer rs.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rs.stop:
+			return
+		case <-ticker.C:
+			rs.check()
+		}
+	}
+}
+
+// check pings all replicas in parallel and updates their health
+func (rs *replicaSet) check() {
+	var wg sync.WaitGroup
+	for _, r := range rs.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			err := r.db.PingContext(ctx)
+
+			if healthy := err == nil; r.healthy.Swap(healthy) != healthy {
+				if healthy {
+					zap.L().Info("Database replica " + r.addr + " is in rotation")
+				} else {
+					zap.L().Warn("Database replica " + r.addr + " is dropped from rotation: " + err.Error())
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+}
+
+func (rs *replicaSet) close() {
+	close(rs.stop)
+	rs.wg.Wait()
+	for _, r := range rs.replicas {
+		r.db.Close()
+	}
+}
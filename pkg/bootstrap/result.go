@@ -58,6 +58,11 @@ func (result Result) Fail(c *gin.Context) {
 	c.Abort()
 }
 
+// Abort responds with the HTTP status and stops the handler chain, e.g. for 4xx errors
+func (result Result) Abort(c *gin.Context, status int) {
+	c.AbortWithStatusJSON(status, result)
+}
+
 // /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 func GlobalErrorHandler() gin.HandlerFunc {
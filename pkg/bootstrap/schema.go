@@ -0,0 +1,184 @@
+package bootstrap
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// placeholder of secret values in printed configurations
+	RedactedValue = "******"
+)
+
+// ConfigSchema generates a JSON Schema (draft 2020-12) for the configuration struct pointed by cfg. Property names
+// follow the yaml tags, defaults come from the `default:` tags and constraints from the `validate:` tags.
+func ConfigSchema(cfg interface{}, title string) map[string]interface{} {
+	val := reflect.ValueOf(cfg).Elem()
+	schema := structSchema(val)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = title
+	return schema
+}
+
+func structSchema(val reflect.Value) map[string]interface{} {
+	typ := val.Type()
+	properties := map[string]interface{}{}
+	var required []string
+
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := yamlName(sf)
+		if name == "-" {
+			continue
+		}
+
+		fv := val.Field(i)
+		var prop map[string]interface{}
+		switch {
+		case isStructValue(fv.Type()):
+			prop = structSchema(fv)
+		case fv.Kind() == reflect.Slice && isStructValue(fv.Type().Elem()):
+			prop = map[string]interface{}{
+				"type":  "array",
+				"items": structSchema(reflect.New(fv.Type().Elem()).Elem()),
+			}
+		default:
+			prop = fieldSchema(sf, fv)
+		}
+		if isSecretField(sf) {
+			prop["writeOnly"] = true
+		}
+		if applyValidateRules(prop, sf.Tag.Get("validate")) {
+			required = append(required, name)
+		}
+		properties[name] = prop
+	}
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func fieldSchema(sf reflect.StructField, fv reflect.Value) map[string]interface{} {
+	prop := map[string]interface{}{}
+	_, hasDefault := sf.Tag.Lookup("default")
+
+	if fv.Type() == reflect.TypeOf(time.Duration(0)) {
+		prop["type"] = []string{"string", "integer"}
+		prop["description"] = "duration, e.g. 10m or 30s"
+		if hasDefault {
+			prop["default"] = time.Duration(fv.Int()).String()
+		}
+		return prop
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		prop["type"] = "string"
+	case reflect.Bool:
+		prop["type"] = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		prop["type"] = "integer"
+	case reflect.Float32, reflect.Float64:
+		prop["type"] = "number"
+	case reflect.Slice:
+		prop["type"] = "array"
+		prop["items"] = map[string]interface{}{"type": "string"}
+	case reflect.Map:
+		prop["type"] = "object"
+	}
+
+	if hasDefault && !fv.IsZero() {
+		prop["default"] = fv.Interface()
+	}
+	return prop
+}
+
+// applyValidateRules maps the `validate:` rules to JSON Schema keywords and reports whether the item is required
+func applyValidateRules(prop map[string]interface{}, rules string) bool {
+	required := false
+	target := prop
+	for _, rule := range strings.Split(rules, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = true
+		case "dive":
+			// the following rules apply to the items of an array
+			if items, ok := prop["items"].(map[string]interface{}); ok {
+				target = items
+			}
+		case "oneof":
+			target["enum"] = strings.Fields(param)
+		case "min", "gte":
+			if n, err := strconv.ParseFloat(param, 64); err == nil {
+				target["minimum"] = n
+			}
+		case "max", "lte":
+			if n, err := strconv.ParseFloat(param, 64); err == nil {
+				target["maximum"] = n
+			}
+		case "url":
+			target["format"] = "uri"
+		case "startswith":
+			target["pattern"] = "^" + regexp.QuoteMeta(param)
+		}
+	}
+	return required
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func isSecretField(sf reflect.StructField) bool {
+	secret, _ := strconv.ParseBool(sf.Tag.Get("secret"))
+	return secret
+}
+
+// RedactConfig returns a copy of the configuration with all non-empty `secret:"true"` items masked
+func RedactConfig[T any](cfg *T) *T {
+	redacted := *cfg
+	copySlices(reflect.ValueOf(&redacted).Elem())
+	for _, field := range ConfigFields(&redacted) {
+		if isSecretField(field.Field) && field.Value.Kind() == reflect.String && field.Value.String() != "" {
+			field.Value.SetString(RedactedValue)
+		}
+	}
+	return &redacted
+}
+
+// copySlices replaces the slices of the struct by copies, so that masking the copy of a configuration leaves the
+// elements of the original alone
+func copySlices(val reflect.Value) {
+	for i := 0; i < val.NumField(); i++ {
+		fv := val.Field(i)
+		if !val.Type().Field(i).IsExported() {
+			continue
+		}
+		switch {
+		case isStructValue(fv.Type()):
+			copySlices(fv)
+		case fv.Kind() == reflect.Slice && !fv.IsNil():
+			dup := reflect.MakeSlice(fv.Type(), fv.Len(), fv.Len())
+			reflect.Copy(dup, fv)
+			fv.Set(dup)
+			if isStructValue(fv.Type().Elem()) {
+				for j := 0; j < dup.Len(); j++ {
+					copySlices(dup.Index(j))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package bootstrap
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const (
+	encryptedPrefix = "ENC["
+	encryptedSuffix = "]"
+
+	// prefix of secret items referring to a file, e.g. file:/run/secrets/db_password
+	fileRefPrefix = "file:"
+)
+
+// SecretCipher encrypts and decrypts config values with AES-256-GCM. Encrypted values look like `ENC[...]`.
+type SecretCipher struct {
+	aead cipher.AEAD
+}
+
+// NewSecretCipher creates a cipher from the key material, which is hashed into an AES-256 key
+func NewSecretCipher(key []byte) (*SecretCipher, error) {
+	if len(strings.TrimSpace(string(key))) == 0 {
+		return nil, errors.New("empty config key")
+	}
+
+	sum := sha256.Sum256([]byte(strings.TrimSpace(string(key))))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretCipher{aead: aead}, nil
+}
+
+// LoadSecretCipher reads the key from the environment variable <PREFIX>_CONFIG_KEY, or from the file named by
+// <PREFIX>_CONFIG_KEY_FILE. It returns nil without error if neither is set.
+func LoadSecretCipher(envPrefix string) (*SecretCipher, error) {
+	name := configKeyEnv(envPrefix)
+	if key, ok := os.LookupEnv(name); ok {
+		return NewSecretCipher([]byte(key))
+	}
+	if file, ok := os.LookupEnv(name + "_FILE"); ok {
+		key, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return NewSecretCipher(key)
+	}
+	return nil, nil
+}
+
+// GenerateSecretKey returns a random key suitable for NewSecretCipher
+func GenerateSecretKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// IsEncrypted reports whether value is an `ENC[...]` marker
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix) && strings.HasSuffix(value, encryptedSuffix)
+}
+
+// Encrypt returns the `ENC[...]` marker of plain
+func (sc *SecretCipher) Encrypt(plain string) (string, error) {
+	nonce := make([]byte, sc.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := sc.aead.Seal(nonce, nonce, []byte(plain), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed) + encryptedSuffix, nil
+}
+
+// Decrypt returns the plain text of an `ENC[...]` marker
+func (sc *SecretCipher) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return "", errors.New("not an encrypted value")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(value[len(encryptedPrefix) : len(value)-len(encryptedSuffix)])
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < sc.aead.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+
+	nonce, data := sealed[:sc.aead.NonceSize()], sealed[sc.aead.NonceSize():]
+	plain, err := sc.aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt value, wrong config key?")
+	}
+	return string(plain), nil
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// resolveSecrets decrypts `ENC[...]` values of all string items and reads `file:` references of secret items
+func (loader *ConfigLoader[T]) resolveSecrets(fields []ConfigField) error {
+	var errs []error
+	for _, field := range fields {
+		if field.Value.Kind() != reflect.String {
+			continue
+		}
+
+		value := field.Value.String()
+		switch {
+		case IsEncrypted(value):
+			if loader.Cipher == nil {
+				sc, err := LoadSecretCipher(loader.EnvPrefix)
+				if err != nil {
+					return err
+				}
+				if sc == nil {
+					return fmt.Errorf("%s is encrypted, but neither %s nor %s_FILE is set", field.Path, configKeyEnv(loader.EnvPrefix), configKeyEnv(loader.EnvPrefix))
+				}
+				loader.Cipher = sc
+			}
+
+			plain, err := loader.Cipher.Decrypt(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", field.Path, err))
+				continue
+			}
+			field.Value.SetString(plain)
+		case isSecretField(field.Field) && strings.HasPrefix(value, fileRefPrefix):
+			data, err := os.ReadFile(strings.TrimPrefix(value, fileRefPrefix))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", field.Path, err))
+				continue
+			}
+			field.Value.SetString(strings.TrimRight(string(data), "\r\n"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func configKeyEnv(envPrefix string) string {
+	if envPrefix == "" {
+		return "CONFIG_KEY"
+	}
+	return strings.ToUpper(envPrefix) + "_CONFIG_KEY"
+}
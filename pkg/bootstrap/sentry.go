@@ -3,6 +3,7 @@ package bootstrap
 import (
 	"context"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/getsentry/sentry-go"
@@ -15,18 +16,26 @@ import (
 
 type AppSentry struct {
 	Params types.AppSentryConfig
+
+	mu sync.RWMutex
+}
+
+func sentryOptions(params types.AppSentryConfig) sentry.ClientOptions {
+	return sentry.ClientOptions{
+		Dsn: params.DSN,
+		// Set TracesSampleRate to 1.0 to capture 100%
+		// of transactions for performance monitoring.
+		// We recommend adjusting this value in production,
+		TracesSampleRate: params.TracesSampleRate,
+	}
 }
 
-func NewSentry(cfg types.AppConfig, lc fx.Lifecycle, logger *AppLogger) (*AppSentry, error) {
+func NewSentry(cfg types.AppConfig, lc fx.Lifecycle, watcher *ConfigWatcher, logger *AppLogger) (*AppSentry, error) {
+	sty := &AppSentry{Params: cfg.Sentry}
+
 	lc.Append(fx.Hook{
 		OnStart: func(context.Context) error {
-			err := sentry.Init(sentry.ClientOptions{
-				Dsn: cfg.Sentry.DSN,
-				// Set TracesSampleRate to 1.0 to capture 100%
-				// of transactions for performance monitoring.
-				// We recommend adjusting this value in production,
-				TracesSampleRate: cfg.Sentry.TracesSampleRate,
-			})
+			err := sentry.Init(sentryOptions(cfg.Sentry))
 			if err != nil {
 				logger.Error("Sentry init error : " + err.Error())
 				return err
@@ -50,7 +59,26 @@ func NewSentry(cfg types.AppConfig, lc fx.Lifecycle, logger *AppLogger) (*AppSen
 			return nil
 		},
 	})
-	return &AppSentry{Params: cfg.Sentry}, nil
+
+	watcher.Subscribe(func(change ConfigChange) {
+		if !change.Has("sentry") {
+			return
+		}
+
+		sty.mu.Lock()
+		sty.Params.TracesSampleRate = change.New.Sentry.TracesSampleRate
+		sty.Params.DefaultLevel = change.New.Sentry.DefaultLevel
+		params := sty.Params
+		sty.mu.Unlock()
+
+		// the sample rate is fixed in the client options, so re-initialize the client with the new one
+		if change.Has("sentry.traces_sample_rate") {
+			if err := sentry.Init(sentryOptions(params)); err != nil {
+				logger.Error("Failed to apply new sentry options : " + err.Error())
+			}
+		}
+	})
+	return sty, nil
 }
 
 func (*AppSentry) SetUser(id string) {
@@ -107,7 +135,13 @@ func (sty *AppSentry) getEventConfig(event_id types.UserDefinedEvent) (bool, *ty
 	var ok bool
 	var needReport bool
 
+	sty.mu.RLock()
+	defer sty.mu.RUnlock()
+
 	if meta, ok = sty.Params.EventsMeta[event_id]; !ok {
+		meta, ok = AuthEventsMeta[event_id]
+	}
+	if !ok {
 		// by default report all
 		meta = types.UserDefinedEventMeta{
 			Name:  "evnt_unknown_report",
@@ -0,0 +1,94 @@
+package bootstrap
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+	"github.com/robinmin/gin-starter/pkg/utility"
+)
+
+// the resolvers of the tenant of a request, see middlewares.auth.tenant.resolver
+const (
+	TenantResolverHeader    = "header"
+	TenantResolverSubdomain = "subdomain"
+	TenantResolverPath      = "path"
+	TenantResolverClaim     = "claim"
+)
+
+// a tenant is also a casbin domain and a value of the policy rules
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// TenantResolver returns the tenant of the request, empty if the request names none
+type TenantResolver func(ctx *gin.Context) string
+
+// ValidTenant reports whether the tenant is well formed, letters, digits, "_", "." and "-" of at most 64 characters
+func ValidTenant(tenant string) bool {
+	return tenantPattern.MatchString(tenant)
+}
+
+// NewTenantResolver creates the resolver of the config. The claim resolver resolves nothing, the tenant is taken from
+// the access token by the JWT middleware.
+func NewTenantResolver(cfg types.AppConfig) (TenantResolver, error) {
+	tenant := cfg.Middlewares.Auth.Tenant
+	switch tenant.Resolver {
+	case TenantResolverHeader:
+		return func(ctx *gin.Context) string {
+			return ctx.GetHeader(tenant.Header)
+		}, nil
+	case TenantResolverSubdomain:
+		suffix := "." + strings.Trim(strings.ToLower(tenant.Domain), ".")
+		return func(ctx *gin.Context) string {
+			host := strings.ToLower(ctx.Request.Host)
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			// only the first label, e.g. acme of acme.example.com
+			sub, ok := strings.CutSuffix(host, suffix)
+			if !ok || strings.Contains(sub, ".") {
+				return ""
+			}
+			return sub
+		}, nil
+	case TenantResolverPath:
+		return func(ctx *gin.Context) string {
+			rest, ok := strings.CutPrefix(ctx.Request.URL.Path, tenant.PathPrefix)
+			if !ok {
+				return ""
+			}
+			segment, _, _ := strings.Cut(rest, "/")
+			return segment
+		}, nil
+	case TenantResolverClaim:
+		return func(ctx *gin.Context) string {
+			return ""
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown tenant resolver %q", tenant.Resolver)
+}
+
+// TenantHandler puts the tenant of the request into the request context, see utility.FromTenant, or the default
+// tenant if the request names none. The requests naming an invalid tenant are rejected.
+func TenantHandler(resolve TenantResolver, defaultTenant string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		tenant := resolve(ctx)
+		if tenant == "" {
+			tenant = defaultTenant
+		}
+		if tenant != "" && !ValidTenant(tenant) {
+			NewResult(http.StatusBadRequest, "无效的租户", nil).Abort(ctx, http.StatusBadRequest)
+			return
+		}
+
+		if tenant != "" {
+			ctx.Set("tenant", tenant)
+			ctx.Request = ctx.Request.WithContext(utility.NewTenant(ctx.Request.Context(), tenant))
+		}
+		ctx.Next()
+	}
+}
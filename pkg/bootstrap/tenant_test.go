@@ -0,0 +1,171 @@
+package bootstrap_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+	"github.com/robinmin/gin-starter/pkg/utility"
+)
+
+func TestTenantResolvers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	testCases := []struct {
+		resolver  string
+		host      string
+		path      string
+		header    string
+		expected  int
+		tenant    string
+		errorInfo string
+	}{
+		{bootstrap.TenantResolverHeader, "example.com", "/data", "acme", http.StatusOK, "acme", "header"},
+		{bootstrap.TenantResolverHeader, "example.com", "/data", "", http.StatusOK, "main", "default tenant"},
+		{bootstrap.TenantResolverHeader, "example.com", "/data", "ac,me", http.StatusBadRequest, "", "invalid tenant"},
+		{bootstrap.TenantResolverSubdomain, "acme.example.com:8080", "/data", "", http.StatusOK, "acme", "subdomain"},
+		{bootstrap.TenantResolverSubdomain, "eu.acme.example.com", "/data", "", http.StatusOK, "main", "only the first label"},
+		{bootstrap.TenantResolverSubdomain, "acme.example.org", "/data", "", http.StatusOK, "main", "another domain"},
+		{bootstrap.TenantResolverPath, "example.com", "/t/acme/data", "", http.StatusOK, "acme", "path"},
+		{bootstrap.TenantResolverPath, "example.com", "/data", "", http.StatusOK, "main", "path without tenant"},
+		{bootstrap.TenantResolverClaim, "example.com", "/data", "acme", http.StatusOK, "main", "claim ignores the request"},
+	}
+	for _, tc := range testCases {
+		cfg := *bootstrap.NewInstance[types.AppConfig]()
+		cfg.Middlewares.Auth.Tenant.Resolver = tc.resolver
+		cfg.Middlewares.Auth.Tenant.Domain = "example.com"
+		resolve, err := bootstrap.NewTenantResolver(cfg)
+		require.NoError(t, err)
+
+		var tenant string
+		router := gin.New()
+		router.Use(bootstrap.TenantHandler(resolve, "main"))
+		router.NoRoute(func(ctx *gin.Context) {
+			tenant = utility.FromTenant(ctx.Request.Context())
+			ctx.Status(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Host = tc.host
+		req.Header.Set("X-Tenant", tc.header)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tc.expected, w.Code, tc.errorInfo)
+		assert.Equal(t, tc.tenant, tenant, tc.errorInfo)
+	}
+
+	cfg := *bootstrap.NewInstance[types.AppConfig]()
+	cfg.Middlewares.Auth.Tenant.Resolver = "cookie"
+	_, err := bootstrap.NewTenantResolver(cfg)
+	assert.Error(t, err)
+}
+
+func TestAuthorizerDomains(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := *bootstrap.NewInstance[types.AppConfig]()
+	cfg.Database.Type = "sqlite3"
+	cfg.Database.Database = filepath.Join(t.TempDir(), "test.db")
+	cfg.Database.AutoMigrate = true
+	cfg.Middlewares.Auth.ModelFile = "../../config/rbac_model_domains.conf"
+	cfg.Middlewares.Auth.Tenant.Enable = true
+	cfg.Middlewares.Auth.Tenant.Resolver = bootstrap.TenantResolverPath
+	db, err := bootstrap.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	author, err := bootstrap.NewAuthorizer(cfg, bootstrap.NewAppLogger())
+	require.NoError(t, err)
+	require.True(t, author.Domains())
+	pm := bootstrap.NewPolicyManager(author, db)
+	_, err = pm.Add(context.Background(), "test",
+		bootstrap.PolicyRule{PType: "p", Rule: []string{"admin", "acme", "/orders", "DELETE"}},
+		bootstrap.PolicyRule{PType: "p", Rule: []string{"viewer", "*", "/orders", "GET"}},
+		bootstrap.PolicyRule{PType: "p", Rule: []string{"admin", "acme", "/reports", "*"}},
+		bootstrap.PolicyRule{PType: "g", Rule: []string{"alice", "admin", "acme"}},
+		bootstrap.PolicyRule{PType: "g", Rule: []string{"alice", "viewer", "globex"}},
+	)
+	require.NoError(t, err)
+
+	// the rules of a tenant, and the links of a user are filtered by the domain
+	assert.Len(t, pm.Rules(bootstrap.PolicyFilter{Tenant: "acme"}), 3)
+	assert.Len(t, pm.Rules(bootstrap.PolicyFilter{PType: "p", Object: "/orders"}), 2)
+	assert.Equal(t, []bootstrap.PolicyRule{{PType: "g", Rule: []string{"alice", "viewer", "globex"}}},
+		pm.Rules(bootstrap.PolicyFilter{Subject: "alice", Tenant: "globex"}))
+	_, err = pm.Add(context.Background(), "test", bootstrap.PolicyRule{PType: "g", Rule: []string{"bob", "admin"}})
+	assert.ErrorIs(t, err, bootstrap.ErrInvalidRule, "the links need the domain")
+
+	assert.True(t, author.HasPermission(utility.NewTenant(context.Background(), "acme"), "alice", "/reports"))
+	assert.False(t, author.HasPermission(utility.NewTenant(context.Background(), "globex"), "alice", "/reports"))
+	allowed, _, err := author.Explain("alice", "globex", "/orders", "DELETE")
+	require.NoError(t, err)
+	assert.False(t, allowed, "the role is of another tenant")
+	allowed, _, err = author.Explain("alice", "globex", "/orders", "GET")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	router := gin.New()
+	resolve, err := bootstrap.NewTenantResolver(cfg)
+	require.NoError(t, err)
+	router.Use(bootstrap.TenantHandler(resolve, ""), func(ctx *gin.Context) {
+		ctx.Request = ctx.Request.WithContext(utility.NewUserID(ctx.Request.Context(), ctx.GetHeader("X-User")))
+	}, author.AuthorizerHandler())
+	router.NoRoute(func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+
+	testCases := []struct {
+		method, path string
+		user         string
+		expected     int
+		errorInfo    string
+	}{
+		{http.MethodDelete, "/t/acme/orders", "alice", http.StatusOK, "allowed by the role in the tenant"},
+		{http.MethodDelete, "/t/globex/orders", "alice", http.StatusForbidden, "denied in another tenant"},
+		{http.MethodGet, "/t/globex/orders", "alice", http.StatusOK, "allowed by a rule of all tenants"},
+		{http.MethodGet, "/t/acme/orders", "alice", http.StatusForbidden, "the role of all tenants is linked in one"},
+		{http.MethodDelete, "/orders", "alice", http.StatusForbidden, "denied without tenant"},
+		{http.MethodDelete, "/t/acme/orders", "bob", http.StatusForbidden, "denied to other users"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("X-User", tc.user)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tc.expected, w.Code, tc.errorInfo)
+	}
+}
+
+func TestUserRolesPerTenant(t *testing.T) {
+	um := bootstrap.NewUserManager(newTestDB(t, true))
+	ctx := context.Background()
+	acme := utility.NewTenant(ctx, "acme")
+	require.NoError(t, um.CreateUser(ctx, "alice", "secret", "alice@example.com"))
+	require.NoError(t, um.AddRole(ctx, "alice", "viewer"))
+	require.NoError(t, um.AddRole(acme, "alice", "admin"))
+	require.NoError(t, um.AddRole(acme, "alice", "viewer"))
+
+	roles, err := um.UserRoles(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"viewer"}, roles)
+	roles, err = um.UserRoles(acme, "alice")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"admin", "viewer"}, roles)
+	roles, err = um.UserRoles(utility.NewTenant(ctx, "globex"), "alice")
+	require.NoError(t, err)
+	assert.Empty(t, roles)
+
+	require.NoError(t, um.RemoveRole(acme, "alice", "viewer"))
+	assert.Error(t, um.RemoveRole(acme, "alice", "viewer"))
+	roles, err = um.UserRoles(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"viewer"}, roles, "the roles of other tenants are kept")
+
+	// the user is deleted with the roles of all tenants
+	require.NoError(t, um.DeleteUser(ctx, "alice"))
+}
@@ -0,0 +1,76 @@
+package bootstrap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/robinmin/gin-starter/pkg/utility"
+)
+
+type savepointCtx struct{}
+
+// Transaction runs fn as one unit of work. The transaction is stored in the context passed to fn, so that the
+// statements and the queries of DBToolKit with this context run in it. It commits if fn returns nil, and rolls back
+// if fn returns an error or panics. Nested calls run in savepoints of the outer transaction.
+func (kit *DBToolKit) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return kit.TransactionWithOptions(ctx, nil, fn)
+}
+
+// TransactionWithOptions is Transaction with the isolation level or the read-only flag, which are ignored by nested
+// calls
+func (kit *DBToolKit) TransactionWithOptions(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) (err error) {
+	if _, ok := utility.FromTrans(ctx); ok {
+		return kit.savepoint(ctx, fn)
+	}
+
+	tx, err := kit.BeginTxx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	if err = fn(utility.NewTrans(ctx, tx)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			err = errors.Join(err, rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func (kit *DBToolKit) savepoint(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	tx, _ := utility.FromTrans(ctx)
+	depth, _ := ctx.Value(savepointCtx{}).(int)
+	name := fmt.Sprintf("sp_%d", depth+1)
+
+	if _, err = tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	rollback := func() error {
+		// roll back even if the context is canceled already
+		_, err := tx.ExecContext(context.WithoutCancel(ctx), "ROLLBACK TO SAVEPOINT "+name)
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			rollback()
+			panic(r)
+		}
+	}()
+
+	if err = fn(context.WithValue(ctx, savepointCtx{}, depth+1)); err != nil {
+		if rbErr := rollback(); rbErr != nil {
+			err = errors.Join(err, rbErr)
+		}
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
@@ -0,0 +1,67 @@
+package bootstrap_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/utility"
+)
+
+func countUsers(t *testing.T, db *bootstrap.DBToolKit) int {
+	t.Helper()
+	users, err := db.Queries().ListUsers(context.Background())
+	require.NoError(t, err)
+	return len(users)
+}
+
+func TestTransaction(t *testing.T) {
+	db := newTestDB(t, true)
+	ctx := context.Background()
+	errAbort := errors.New("abort")
+
+	// the queries pick up the transaction of the context
+	err := db.Transaction(ctx, func(ctx context.Context) error {
+		_, ok := utility.FromTrans(ctx)
+		assert.True(t, ok)
+		require.NoError(t, db.Queries().CreateUser(ctx, "alice", "hash", "alice@example.com"))
+		_, err := db.Queries().GetUserByUsername(ctx, "alice")
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, countUsers(t, db))
+
+	err = db.Transaction(ctx, func(ctx context.Context) error {
+		require.NoError(t, db.Queries().CreateUser(ctx, "bob", "hash", "bob@example.com"))
+		return errAbort
+	})
+	assert.ErrorIs(t, err, errAbort)
+	assert.Equal(t, 1, countUsers(t, db))
+
+	assert.Panics(t, func() {
+		_ = db.Transaction(ctx, func(ctx context.Context) error {
+			require.NoError(t, db.Queries().CreateUser(ctx, "bob", "hash", "bob@example.com"))
+			panic("boom")
+		})
+	})
+	assert.Equal(t, 1, countUsers(t, db))
+
+	// a failed nested call only rolls back its savepoint
+	err = db.Transaction(ctx, func(ctx context.Context) error {
+		require.NoError(t, db.Queries().CreateUser(ctx, "bob", "hash", "bob@example.com"))
+		err := db.Transaction(ctx, func(ctx context.Context) error {
+			require.NoError(t, db.Queries().CreateUser(ctx, "carol", "hash", "carol@example.com"))
+			return errAbort
+		})
+		assert.ErrorIs(t, err, errAbort)
+		return db.Transaction(ctx, func(ctx context.Context) error {
+			return db.Queries().CreateUser(ctx, "dave", "hash", "dave@example.com")
+		})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, countUsers(t, db))
+}
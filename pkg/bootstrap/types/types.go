@@ -19,41 +19,76 @@ type UserDefinedEventMap map[UserDefinedEvent]UserDefinedEventMeta
 // Definitions for system configuration
 type AppSysConfig struct {
 	DebugMode          bool   `yaml:"debug_mode,omitempty" json:"debug_mode,omitempty" default:"false"`
-	ServerAddr         string `yaml:"server_address,omitempty" json:"server_address,omitempty" default:":7086"`
-	ExternalSvrAddress string `yaml:"external_svr_address,omitempty" json:"external_svr_address,omitempty" default:""`
-	TrustedProxies     string `yaml:"trusted_proxies,omitempty" json:"trusted_proxies,omitempty" default:"127.0.0.1;10.0.0.0/8"`
+	ServerAddr         string `yaml:"server_address,omitempty" json:"server_address,omitempty" default:":7086" validate:"required,hostname_port"`
+	ExternalSvrAddress string `yaml:"external_svr_address,omitempty" json:"external_svr_address,omitempty" default:"" validate:"omitempty,url"`
+	TrustedProxies     string `yaml:"trusted_proxies,omitempty" json:"trusted_proxies,omitempty" default:"127.0.0.1;10.0.0.0/8" validate:"proxy_list"`
 }
 
 // Definitions for database configuration
 type AppDBConfig struct {
-	Type         string `yaml:"dbtype,omitempty" json:"dbtype,omitempty" default:"sqlite3"`
-	Host         string `yaml:"dbhost,omitempty" json:"dbhost,omitempty" default:"localhost"`
-	Port         int    `yaml:"dbport,omitempty" json:"dbport,omitempty" default:"3306"`
-	Database     string `yaml:"dbname,omitempty" json:"dbname,omitempty" default:"database"`
+	Type         string `yaml:"dbtype,omitempty" json:"dbtype,omitempty" default:"sqlite3" validate:"required,oneof=sqlite3 mysql postgres"`
+	Host         string `yaml:"dbhost,omitempty" json:"dbhost,omitempty" default:"localhost" validate:"required_unless=Type sqlite3"`
+	Port         int    `yaml:"dbport,omitempty" json:"dbport,omitempty" default:"3306" validate:"min=0,max=65535"`
+	Database     string `yaml:"dbname,omitempty" json:"dbname,omitempty" default:"database" validate:"required"`
 	User         string `yaml:"dbuser,omitempty" json:"dbuser,omitempty" default:"user"`
-	Password     string `yaml:"dbpassword,omitempty" json:"dbpassword,omitempty" default:""`
-	MaxOpenConns int    `yaml:"max_open_conns,omitempty" json:"max_open_conns,omitempty" default:"10"`
-	MaxIdleConns int    `yaml:"max_idle_conns,omitempty" json:"max_idle_conns,omitempty" default:"10"`
+	Password     string `yaml:"dbpassword,omitempty" json:"dbpassword,omitempty" default:"" secret:"true"`
+	MaxOpenConns int    `yaml:"max_open_conns,omitempty" json:"max_open_conns,omitempty" default:"10" validate:"min=0"`
+	MaxIdleConns int    `yaml:"max_idle_conns,omitempty" json:"max_idle_conns,omitempty" default:"10" validate:"min=0"`
+	AutoMigrate  bool   `yaml:"auto_migrate,omitempty" json:"auto_migrate,omitempty" default:"false"` // apply pending migrations on startup
+	SSLMode      string `yaml:"sslmode,omitempty" json:"sslmode,omitempty" default:"disable" validate:"oneof=disable require verify-ca verify-full"`
+	TLSCA        string `yaml:"tls_ca,omitempty" json:"tls_ca,omitempty" validate:"omitempty,file_exists"`     // CA certificate to verify the server with
+	TLSCert      string `yaml:"tls_cert,omitempty" json:"tls_cert,omitempty" validate:"omitempty,file_exists"` // client certificate
+	TLSKey       string `yaml:"tls_key,omitempty" json:"tls_key,omitempty" validate:"required_with=TLSCert"`
+
+	// read-only replicas sharing the settings above, as host:port
+	Replicas            []string      `yaml:"replicas,omitempty" json:"replicas,omitempty" validate:"dive,hostname_port"`
+	HealthCheckInterval time.Duration `yaml:"health_check_interval,omitempty" json:"health_check_interval,omitempty" default:"10s" validate:"min=0"`
 }
 
 // Definitions for redis configuration
 type AppRedisConfig struct {
-	Size              int           `yaml:"size,omitempty" json:"size,omitempty" default:"10"`                              // maximum number of idle connections.
-	Network           string        `yaml:"network,omitempty" json:"network,omitempty" default:"tcp"`                       // tcp or udp
-	Address           string        `yaml:"address,omitempty" json:"address,omitempty" default:"localhost:6379"`            // host:port of redis server
-	Password          string        `yaml:"password,omitempty" json:"password,omitempty" default:""`                        // redis-password
-	DB                int           `yaml:"db,omitempty" json:"db,omitempty" default:"0"`                                   // database
-	KeyPairs          string        `yaml:"key_pairs,omitempty" json:"key_pairs,omitempty" default:""`                      // Keys are defined in pairs to allow key rotation, but the common case is to set a single authentication key and optionally an encryption key.
-	DefaultExpiration time.Duration `yaml:"default_expiration,omitempty" json:"default_expiration,omitempty" default:"10m"` // default expiration time for redis cache
+	Size              int           `yaml:"size,omitempty" json:"size,omitempty" default:"10" validate:"min=1"`                              // maximum number of idle connections.
+	Network           string        `yaml:"network,omitempty" json:"network,omitempty" default:"tcp" validate:"oneof=tcp tcp4 tcp6 unix"`    // tcp or udp
+	Address           string        `yaml:"address,omitempty" json:"address,omitempty" default:"localhost:6379" validate:"required"`         // host:port of redis server
+	Password          string        `yaml:"password,omitempty" json:"password,omitempty" default:"" secret:"true"`                           // redis-password
+	DB                int           `yaml:"db,omitempty" json:"db,omitempty" default:"0" validate:"min=0"`                                   // database
+	KeyPairs          string        `yaml:"key_pairs,omitempty" json:"key_pairs,omitempty" default:"" secret:"true"`                         // Keys are defined in pairs to allow key rotation, but the common case is to set a single authentication key and optionally an encryption key.
+	DefaultExpiration time.Duration `yaml:"default_expiration,omitempty" json:"default_expiration,omitempty" default:"10m" validate:"gt=0s"` // default expiration time for redis cache
 	// EnableRedisCache  bool          `yaml:"enable_redis_cache,omitempty" json:"enable_redis_cache,omitempty" default:"true"` // use redis cache
 }
 
 // Definitions for sentry configuration
 type AppSentryConfig struct {
-	DSN              string              `yaml:"sentry_dsn,omitempty" json:"sentry_dsn,omitempty" default:""`                    // DSN of the sentry
-	TracesSampleRate float64             `yaml:"traces_sample_rate,omitempty" json:"traces_sample_rate,omitempty" default:"1.0"` // trac sample rate
-	DefaultLevel     int                 `yaml:"default_level,omitempty" json:"default_level,omitempty" default:"-4"`            // Default level of the sentry
-	EventsMeta       UserDefinedEventMap `yaml:"-" json:"-"`                                                                     // Events meatadata mappings
+	DSN              string              `yaml:"sentry_dsn,omitempty" json:"sentry_dsn,omitempty" default:"" validate:"omitempty,url" secret:"true"`    // DSN of the sentry
+	TracesSampleRate float64             `yaml:"traces_sample_rate,omitempty" json:"traces_sample_rate,omitempty" default:"1.0" validate:"min=0,max=1"` // trac sample rate
+	DefaultLevel     int                 `yaml:"default_level,omitempty" json:"default_level,omitempty" default:"-4"`                                   // Default level of the sentry
+	EventsMeta       UserDefinedEventMap `yaml:"-" json:"-"`                                                                                            // Events meatadata mappings
+}
+
+// Definitions for mailer configuration, the mails are sent by SMTP, written into Dir as .eml files or kept in memory
+type AppMailerConfig struct {
+	Type     string `yaml:"type,omitempty" json:"type,omitempty" default:"none" validate:"oneof=none smtp file memory"`
+	From     string `yaml:"from,omitempty" json:"from,omitempty" default:"" validate:"required_unless=Type none"` // e.g. "Gin Starter <no-reply@example.com>"
+	Host     string `yaml:"host,omitempty" json:"host,omitempty" default:"" validate:"required_if=Type smtp"`
+	Port     int    `yaml:"port,omitempty" json:"port,omitempty" default:"587" validate:"min=0,max=65535"`
+	Username string `yaml:"username,omitempty" json:"username,omitempty" default:""` // no authentication if empty
+	Password string `yaml:"password,omitempty" json:"password,omitempty" default:"" secret:"true"`
+	TLS      bool   `yaml:"tls,omitempty" json:"tls,omitempty" default:"false"` // implicit TLS, e.g. on port 465, otherwise STARTTLS if offered
+	Dir      string `yaml:"dir,omitempty" json:"dir,omitempty" default:"./data/mails" validate:"required_if=Type file"`
+}
+
+// Definitions for an OpenID Connect provider to login with, e.g. Keycloak, Google or Azure AD
+type AppOIDCProvider struct {
+	Name          string              `yaml:"name,omitempty" json:"name,omitempty" validate:"required,alphanum"`            // in the login URL, e.g. /auth/oidc/<name>/login
+	Issuer        string              `yaml:"issuer,omitempty" json:"issuer,omitempty" validate:"required,url"`             // discovered at <issuer>/.well-known/openid-configuration
+	ClientID      string              `yaml:"client_id,omitempty" json:"client_id,omitempty" validate:"required"`           // client ID registered at the provider
+	ClientSecret  string              `yaml:"client_secret,omitempty" json:"client_secret,omitempty" secret:"true"`         // empty for public clients, which rely on PKCE only
+	RedirectURL   string              `yaml:"redirect_url,omitempty" json:"redirect_url,omitempty" validate:"required,url"` // e.g. https://example.com/auth/oidc/<name>/callback
+	Scopes        []string            `yaml:"scopes,omitempty" json:"scopes,omitempty"`                                     // openid, profile and email if empty
+	UsernameClaim string              `yaml:"username_claim,omitempty" json:"username_claim,omitempty"`                     // preferred_username if empty, falling back to email
+	RolesClaim    string              `yaml:"roles_claim,omitempty" json:"roles_claim,omitempty"`                           // e.g. groups, a string or a list of strings
+	RoleMapping   map[string][]string `yaml:"role_mapping,omitempty" json:"role_mapping,omitempty"`                         // values of the roles claim to local roles, synced on every login
+	DefaultRoles  []string            `yaml:"default_roles,omitempty" json:"default_roles,omitempty"`                       // added to the users created by the provider
 }
 
 type AppConfig struct {
@@ -61,12 +96,13 @@ type AppConfig struct {
 	Database    AppDBConfig     `yaml:"database,omitempty" json:"database,omitempty"`
 	Redis       AppRedisConfig  `yaml:"redis,omitempty" json:"redis,omitempty"`
 	Sentry      AppSentryConfig `yaml:"sentry,omitempty" json:"sentry,omitempty"`
+	Mailer      AppMailerConfig `yaml:"mailer,omitempty" json:"mailer,omitempty"`
 	Middlewares struct {
 		Log struct {
-			TimeFormat   string   `yaml:"time_format,omitempty" json:"time_format,omitempty" default:"2006-01-02T15:04:05Z07:00"`
+			TimeFormat   string   `yaml:"time_format,omitempty" json:"time_format,omitempty" default:"2006-01-02T15:04:05Z07:00" validate:"required"`
 			UTC          bool     `yaml:"utc,omitempty" json:"utc,omitempty" default:"false"`
 			SkipPaths    []string `yaml:"skip_paths,omitempty" json:"skip_paths,omitempty"`
-			DefaultLevel string   `yaml:"default_level,omitempty" json:"default_level,omitempty" default:"info"` // Default level of the logger
+			DefaultLevel string   `yaml:"default_level,omitempty" json:"default_level,omitempty" default:"info" validate:"oneof=debug info warn error dpanic panic fatal"` // Default level of the logger
 		} `yaml:"log,omitempty" json:"log,omitempty"`
 
 		CORS struct {
@@ -86,14 +122,14 @@ type AppConfig struct {
 
 			// AllowMethods is a list of methods the client is allowed to use with
 			// cross-domain requests. Default value is simple methods (GET, POST, PUT, PATCH, DELETE, HEAD, and OPTIONS)
-			AllowMethods []string `yaml:"allow_methods,omitempty" json:"allow_methods,omitempty" default:"GET,POST,PUT,PATCH,DELETE,HEAD,OPTIONS"`
+			AllowMethods []string `yaml:"allow_methods,omitempty" json:"allow_methods,omitempty" default:"[\"GET\",\"POST\",\"PUT\",\"PATCH\",\"DELETE\",\"HEAD\",\"OPTIONS\"]" validate:"dive,oneof=GET HEAD POST PUT PATCH DELETE OPTIONS CONNECT TRACE"`
 
 			// AllowPrivateNetwork indicates whether the response should include allow private network header
 			AllowPrivateNetwork bool `yaml:"allow_private_network,omitempty" json:"allow_private_network,omitempty" default:"false"`
 
 			// AllowHeaders is list of non simple headers the client is allowed to use with
 			// cross-domain requests.
-			AllowHeaders []string `yaml:"allow_headers,omitempty" json:"allow_headers,omitempty" default:"Origin,Content-Length,Content-Type"`
+			AllowHeaders []string `yaml:"allow_headers,omitempty" json:"allow_headers,omitempty" default:"[\"Origin\",\"Content-Length\",\"Content-Type\"]"`
 
 			// AllowCredentials indicates whether the request can include user credentials like
 			// cookies, HTTP authentication or client side SSL certificates.
@@ -105,7 +141,7 @@ type AppConfig struct {
 
 			// MaxAge indicates how long (with second-precision) the results of a preflight request
 			// can be cached
-			MaxAge time.Duration `yaml:"max_age,omitempty" json:"max_age,omitempty" default:"43200"` // in second, default as 12 * time.Hour
+			MaxAge time.Duration `yaml:"max_age,omitempty" json:"max_age,omitempty" default:"43200" validate:"min=0"` // in second, default as 12 * time.Hour
 
 			// Allows to add origins like http://some-domain/*, https://api.* or http://some.*.subdomain.com
 			AllowWildcard bool `yaml:"allow_wildcard,omitempty" json:"allow_wildcard,omitempty" default:"false"`
@@ -120,13 +156,13 @@ type AppConfig struct {
 			AllowFiles bool `yaml:"allow_files,omitempty" json:"allow_files,omitempty" default:"false"`
 
 			// Allows to pass custom OPTIONS response status code for old browsers / clients
-			OptionsResponseStatusCode int `yaml:"options_response_status_code,omitempty" json:"options_response_status_code,omitempty" default:"200"`
+			OptionsResponseStatusCode int `yaml:"options_response_status_code,omitempty" json:"options_response_status_code,omitempty" default:"200" validate:"min=100,max=599"`
 		} `yaml:"cors,omitempty" json:"cors,omitempty"`
 
 		Session struct {
 			Enable   bool   `yaml:"enable,omitempty" json:"enable,omitempty" default:"true"`
-			Name     string `yaml:"name,omitempty" json:"name,omitempty" default:"session"`        // session name
-			UseRedis bool   `yaml:"use_redis,omitempty" json:"use_redis,omitempty" default:"true"` // use redis session
+			Name     string `yaml:"name,omitempty" json:"name,omitempty" default:"session" validate:"required_if=Enable true"` // session name
+			UseRedis bool   `yaml:"use_redis,omitempty" json:"use_redis,omitempty" default:"true"`                             // use redis session
 		} `yaml:"session,omitempty" json:"session,omitempty"`
 
 		Cache struct {
@@ -136,8 +172,8 @@ type AppConfig struct {
 
 		Static struct {
 			Enable    bool   `yaml:"enable,omitempty" json:"enable,omitempty" default:"true"`
-			StaticDir string `yaml:"static_dir,omitempty" json:"static_dir,omitempty" default:"./static"`
-			StaticURL string `yaml:"static_url,omitempty" json:"static_url,omitempty" default:"/static"`
+			StaticDir string `yaml:"static_dir,omitempty" json:"static_dir,omitempty" default:"./static" validate:"required_if=Enable true,dir_exists"`
+			StaticURL string `yaml:"static_url,omitempty" json:"static_url,omitempty" default:"/static" validate:"required_if=Enable true,omitempty,startswith=/"`
 			Indexes   bool   `yaml:"indexes,omitempty" json:"indexes,omitempty" default:"true"`
 		} `yaml:"static,omitempty" json:"static,omitempty"`
 
@@ -145,10 +181,118 @@ type AppConfig struct {
 			Enable bool `yaml:"enable,omitempty" json:"enable,omitempty" default:"true"`
 		} `yaml:"gzip,omitempty" json:"gzip,omitempty"`
 
+		RateLimit struct {
+			Enable bool    `yaml:"enable,omitempty" json:"enable,omitempty" default:"false"`
+			Rate   float64 `yaml:"rate,omitempty" json:"rate,omitempty" default:"100" validate:"min=0"`   // requests per second of each client IP
+			Burst  int     `yaml:"burst,omitempty" json:"burst,omitempty" default:"200" validate:"min=0"` // maximum burst size of each client IP
+		} `yaml:"rate_limit,omitempty" json:"rate_limit,omitempty"`
+
 		Auth struct {
 			Enable    bool   `yaml:"enable,omitempty" json:"enable,omitempty" default:"true"`
-			ModelFile string `yaml:"model_file,omitempty" json:"model_file,omitempty" default:"./config/model.yaml"`
-			TableName string `yaml:"table_name,omitempty" json:"table_name,omitempty" default:"auth_rules"`
+			ModelFile string `yaml:"model_file,omitempty" json:"model_file,omitempty" default:"./config/rbac_model.conf" validate:"required_if=Enable true,file_exists"`
+			TableName string `yaml:"table_name,omitempty" json:"table_name,omitempty" default:"auth_rules" validate:"required_if=Enable true"`
+			RootRole  string `yaml:"root_role,omitempty" json:"root_role,omitempty" default:"root"` // users of the role bypass casbin, none if empty
+
+			// one deployment serving several tenants. The tenant of a request is resolved by Resolver, the tokens and
+			// the roles are bound to it, and casbin checks (sub, dom, obj, act) by a model with domains, e.g.
+			// ./config/rbac_model_domains.conf. The claim resolver takes the tenant of the access token only.
+			Tenant struct {
+				Enable     bool   `yaml:"enable,omitempty" json:"enable,omitempty" default:"false"`
+				Resolver   string `yaml:"resolver,omitempty" json:"resolver,omitempty" default:"header" validate:"oneof=header subdomain path claim"`
+				Header     string `yaml:"header,omitempty" json:"header,omitempty" default:"X-Tenant"`
+				Domain     string `yaml:"domain,omitempty" json:"domain,omitempty" default:"" validate:"required_if=Resolver subdomain"`       // base domain of the subdomains, e.g. example.com
+				PathPrefix string `yaml:"path_prefix,omitempty" json:"path_prefix,omitempty" default:"/t/" validate:"startswith=/,endswith=/"` // the tenant is the segment following it, e.g. /t/acme/...
+				Default    string `yaml:"default,omitempty" json:"default,omitempty" default:""`                                               // of the requests naming none, unless by the claim resolver
+			} `yaml:"tenant,omitempty" json:"tenant,omitempty"`
+
+			// hashing of new passwords, existing hashes are upgraded on the next successful login
+			PasswordHash string `yaml:"password_hash,omitempty" json:"password_hash,omitempty" default:"bcrypt" validate:"oneof=bcrypt argon2id"`
+			BcryptCost   int    `yaml:"bcrypt_cost,omitempty" json:"bcrypt_cost,omitempty" default:"10" validate:"min=4,max=31"`
+			Argon2       struct {
+				Time    int `yaml:"time,omitempty" json:"time,omitempty" default:"1" validate:"min=1"`
+				Memory  int `yaml:"memory,omitempty" json:"memory,omitempty" default:"65536" validate:"min=8"` // in KiB
+				Threads int `yaml:"threads,omitempty" json:"threads,omitempty" default:"2" validate:"min=1,max=255"`
+			} `yaml:"argon2,omitempty" json:"argon2,omitempty"`
+
+			// failed logins are counted per user and per IP, exceeding the limit locks them for Backoff, doubled on
+			// every further failure up to MaxBackoff
+			Lockout struct {
+				Enable           bool          `yaml:"enable,omitempty" json:"enable,omitempty" default:"true"`
+				MaxAttempts      int           `yaml:"max_attempts,omitempty" json:"max_attempts,omitempty" default:"5" validate:"min=1"`
+				MaxAttemptsPerIP int           `yaml:"max_attempts_per_ip,omitempty" json:"max_attempts_per_ip,omitempty" default:"20" validate:"min=1"`
+				Window           time.Duration `yaml:"window,omitempty" json:"window,omitempty" default:"15m" validate:"min=1s"`
+				Backoff          time.Duration `yaml:"backoff,omitempty" json:"backoff,omitempty" default:"1m" validate:"min=1s"`
+				MaxBackoff       time.Duration `yaml:"max_backoff,omitempty" json:"max_backoff,omitempty" default:"1h" validate:"gtefield=Backoff"`
+			} `yaml:"lockout,omitempty" json:"lockout,omitempty"`
+
+			// tokens issued by the auth routes, the refresh token outlives the access token by RefreshTTL - AccessTTL
+			Token struct {
+				AccessSecret  string        `yaml:"access_secret,omitempty" json:"access_secret,omitempty" default:"" secret:"true"`
+				RefreshSecret string        `yaml:"refresh_secret,omitempty" json:"refresh_secret,omitempty" default:"" secret:"true"`
+				AccessTTL     time.Duration `yaml:"access_ttl,omitempty" json:"access_ttl,omitempty" default:"15m" validate:"min=1m"`
+				RefreshTTL    time.Duration `yaml:"refresh_ttl,omitempty" json:"refresh_ttl,omitempty" default:"168h" validate:"gtfield=AccessTTL"`
+				ReuseGrace    time.Duration `yaml:"reuse_grace,omitempty" json:"reuse_grace,omitempty" default:"0s" validate:"min=0"` // a rotated refresh token presented again revokes its login after the grace
+				Issuer        string        `yaml:"issuer,omitempty" json:"issuer,omitempty" default:"gin-starter"`
+				// where the access tokens are looked for in order, e.g. "header:Authorization,cookie:access_token,query:token".
+				// The Authorization header takes the Bearer scheme, other headers the raw value unless a scheme follows.
+				Lookup string `yaml:"lookup,omitempty" json:"lookup,omitempty" default:"header:Authorization"`
+
+				// checks of parsed tokens besides the signature, the expiration and the issuer
+				Audience       []string      `yaml:"audience,omitempty" json:"audience,omitempty"` // also the audience of issued tokens
+				Leeway         time.Duration `yaml:"leeway,omitempty" json:"leeway,omitempty" default:"0s" validate:"min=0"`
+				RequiredClaims []string      `yaml:"required_claims,omitempty" json:"required_claims,omitempty" validate:"dive,oneof=sub iss aud jti iat nbf username roles tenant scopes"`
+
+				// access tokens are signed by the keys instead of AccessSecret unless Algorithm is HS256. The keys are
+				// loaded from KeyFiles and generated into KeyStore, which is rotated every RotateInterval if not 0.
+				// Superseded keys still verify during KeyRetention, and all keys are published at /.well-known/jwks.json.
+				Algorithm      string        `yaml:"algorithm,omitempty" json:"algorithm,omitempty" default:"HS256" validate:"oneof=HS256 RS256 ES256 EdDSA"`
+				KeyFiles       []string      `yaml:"key_files,omitempty" json:"key_files,omitempty" validate:"dive,file_exists"`
+				KeyStore       string        `yaml:"key_store,omitempty" json:"key_store,omitempty" default:""`
+				RotateInterval time.Duration `yaml:"rotate_interval,omitempty" json:"rotate_interval,omitempty" default:"0s" validate:"min=0"`
+				KeyRetention   time.Duration `yaml:"key_retention,omitempty" json:"key_retention,omitempty" default:"24h" validate:"gtefield=AccessTTL"`
+				Algorithms     []string      `yaml:"algorithms,omitempty" json:"algorithms,omitempty" validate:"dive,oneof=HS256 RS256 ES256 EdDSA"` // accepted algorithms, HS256 and those of the keys if empty
+			} `yaml:"token,omitempty" json:"token,omitempty"`
+
+			// API keys of machine clients, sent in Header or as Bearer tokens, see `cli apikey`
+			APIKeys struct {
+				Enable bool   `yaml:"enable,omitempty" json:"enable,omitempty" default:"false"`
+				Header string `yaml:"header,omitempty" json:"header,omitempty" default:"X-API-Key"`
+			} `yaml:"api_keys,omitempty" json:"api_keys,omitempty"`
+
+			// TOTP as the second factor of the password logins. The users enroll by the auth routes, those of the roles
+			// requiring MFA (see `cli user mfa require`) are asked to enroll on their next login.
+			MFA struct {
+				Enable       bool          `yaml:"enable,omitempty" json:"enable,omitempty" default:"false"`
+				Issuer       string        `yaml:"issuer,omitempty" json:"issuer,omitempty" default:""` // shown by the authenticator apps, token.issuer if empty
+				ChallengeTTL time.Duration `yaml:"challenge_ttl,omitempty" json:"challenge_ttl,omitempty" default:"5m" validate:"min=30s"`
+				MaxAttempts  int           `yaml:"max_attempts,omitempty" json:"max_attempts,omitempty" default:"5" validate:"min=1"` // wrong codes per challenge
+			} `yaml:"mfa,omitempty" json:"mfa,omitempty"`
+
+			// password reset and email verification by the links mailed to the users, see the mailer config. The
+			// tokens of the links are signed by TokenSecret, kept in the cache store and used once.
+			Email struct {
+				Enable      bool          `yaml:"enable,omitempty" json:"enable,omitempty" default:"false"`
+				TokenSecret string        `yaml:"token_secret,omitempty" json:"token_secret,omitempty" default:"" secret:"true" validate:"required_if=Enable true"`
+				ResetTTL    time.Duration `yaml:"reset_ttl,omitempty" json:"reset_ttl,omitempty" default:"30m" validate:"min=1m"`
+				VerifyTTL   time.Duration `yaml:"verify_ttl,omitempty" json:"verify_ttl,omitempty" default:"24h" validate:"min=1m"`
+				ResendAfter time.Duration `yaml:"resend_after,omitempty" json:"resend_after,omitempty" default:"1m" validate:"min=0"` // per email and purpose
+				// pages of the frontend the token is appended to, e.g. https://example.com/reset-password?token=
+				ResetURL  string `yaml:"reset_url,omitempty" json:"reset_url,omitempty" default:"" validate:"omitempty,url"`
+				VerifyURL string `yaml:"verify_url,omitempty" json:"verify_url,omitempty" default:"" validate:"omitempty,url"`
+			} `yaml:"email,omitempty" json:"email,omitempty"`
+
+			// login by OpenID Connect providers, the users are provisioned on their first login. It needs the auth
+			// routes and the session middleware, which keeps the state of the logins in progress.
+			OIDC struct {
+				Enable    bool              `yaml:"enable,omitempty" json:"enable,omitempty" default:"false"`
+				Providers []AppOIDCProvider `yaml:"providers,omitempty" json:"providers,omitempty" validate:"required_if=Enable true,dive"`
+			} `yaml:"oidc,omitempty" json:"oidc,omitempty"`
+
+			// built-in routes to login, refresh, logout and me, they need the refresh secret and the access secret or keys
+			Routes struct {
+				Enable bool   `yaml:"enable,omitempty" json:"enable,omitempty" default:"false"`
+				Prefix string `yaml:"prefix,omitempty" json:"prefix,omitempty" default:"/auth" validate:"required_if=Enable true,omitempty,startswith=/"`
+			} `yaml:"routes,omitempty" json:"routes,omitempty"`
 		} `yaml:"auth,omitempty" json:"auth,omitempty"`
 	} `yaml:"middlewares,omitempty" json:"middlewares,omitempty"`
 }
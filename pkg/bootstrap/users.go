@@ -0,0 +1,316 @@
+package bootstrap
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/robinmin/gin-starter/pkg/utility"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrRoleNotFound = errors.New("role not found")
+	ErrUserExists   = errors.New("user already exists")
+)
+
+// NoPassword is stored for the users provisioned by external providers, they can't login by password
+const NoPassword = "!"
+
+// UserManager maintains users and their roles, e.g. for the admin commands. The roles are assigned per tenant, the
+// one in the context, see utility.FromTenant.
+type UserManager struct {
+	db *DBToolKit
+}
+
+func NewUserManager(db *DBToolKit) *UserManager {
+	return &UserManager{db: db}
+}
+
+// CreateUser adds a user with the hashed password
+func (um *UserManager) CreateUser(ctx context.Context, username, password, email string) error {
+	hashed, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return um.db.Queries().CreateUser(ctx, username, hashed, email)
+}
+
+// GetUser returns the user with its password hash
+func (um *UserManager) GetUser(ctx context.Context, username string) (*User, error) {
+	row, err := um.db.Queries().GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, notFound(err, ErrUserNotFound, username)
+	}
+	return &User{
+		ID:              int(row.ID),
+		Username:        row.Username,
+		PasswordHash:    row.Password,
+		Email:           row.Email,
+		EmailVerifiedAt: row.EmailVerifiedAt,
+	}, nil
+}
+
+// GetUserByEmail returns the user of the email with its password hash
+func (um *UserManager) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	row, err := um.db.Queries().GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, notFound(err, ErrUserNotFound, email)
+	}
+	return &User{
+		ID:              int(row.ID),
+		Username:        row.Username,
+		PasswordHash:    row.Password,
+		Email:           row.Email,
+		EmailVerifiedAt: row.EmailVerifiedAt,
+	}, nil
+}
+
+// ListUsers returns all users with their password hashes
+func (um *UserManager) ListUsers(ctx context.Context) ([]User, error) {
+	rows, err := um.db.Queries().ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]User, 0, len(rows))
+	for _, row := range rows {
+		users = append(users, User{
+			ID:              int(row.ID),
+			Username:        row.Username,
+			PasswordHash:    row.Password,
+			Email:           row.Email,
+			EmailVerifiedAt: row.EmailVerifiedAt,
+		})
+	}
+	return users, nil
+}
+
+// UserRoles returns the role names of a user in the tenant of the context
+func (um *UserManager) UserRoles(ctx context.Context, username string) ([]string, error) {
+	names, err := um.db.Queries().GetRoleNamesByUsername(ctx, username, utility.FromTenant(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make([]string, 0, len(names))
+	for _, name := range names {
+		if name != nil {
+			roles = append(roles, *name)
+		}
+	}
+	return roles, nil
+}
+
+// SetPassword replaces the password of a user
+func (um *UserManager) SetPassword(ctx context.Context, username, password string) error {
+	hashed, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	n, err := um.db.Queries().UpdateUserPassword(ctx, hashed, username)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	return nil
+}
+
+// VerifyEmail marks the email of the user as verified, it fails with ErrUserNotFound if the user no longer has the
+// email. Verifying it again is a no-op.
+func (um *UserManager) VerifyEmail(ctx context.Context, username, email string) error {
+	n, err := um.db.Queries().VerifyUserEmail(ctx, username, email)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	user, err := um.GetUser(utility.NewPrimaryRead(ctx), username)
+	if err != nil {
+		return err
+	}
+	if user.Email != email {
+		return fmt.Errorf("%w: %s with email %s", ErrUserNotFound, username, email)
+	}
+	return nil
+}
+
+// DeleteUser removes a user together with its role assignments, identities and MFA
+func (um *UserManager) DeleteUser(ctx context.Context, username string) error {
+	return um.db.Transaction(ctx, func(ctx context.Context) error {
+		q := um.db.Queries()
+		user, err := q.GetUserByUsername(utility.NewRowLock(ctx), username)
+		if err != nil {
+			return notFound(err, ErrUserNotFound, username)
+		}
+		if err = q.DeleteUserRoles(ctx, user.ID); err != nil {
+			return err
+		}
+		if err = q.DeleteUserIdentities(ctx, user.ID); err != nil {
+			return err
+		}
+		if _, err = q.DeleteMFA(ctx, user.ID); err != nil {
+			return err
+		}
+		if err = q.DeleteRecoveryCodes(ctx, user.ID); err != nil {
+			return err
+		}
+		_, err = q.DeleteUser(ctx, username)
+		return err
+	})
+}
+
+// AddRole assigns a role to a user in the tenant of the context, the role is created if it does not exist yet
+func (um *UserManager) AddRole(ctx context.Context, username, role string) error {
+	return um.db.Transaction(ctx, func(ctx context.Context) error {
+		q := um.db.Queries()
+		user, err := q.GetUserByUsername(ctx, username)
+		if err != nil {
+			return notFound(err, ErrUserNotFound, username)
+		}
+
+		r, err := q.GetRoleByName(ctx, role)
+		if errors.Is(err, sql.ErrNoRows) {
+			if err = q.CreateRole(ctx, role, nil); err != nil {
+				return err
+			}
+			r, err = q.GetRoleByName(ctx, role)
+		}
+		if err != nil {
+			return err
+		}
+		return q.AddUserRole(ctx, user.ID, r.ID, utility.FromTenant(ctx))
+	})
+}
+
+// RemoveRole revokes a role from a user in the tenant of the context
+func (um *UserManager) RemoveRole(ctx context.Context, username, role string) error {
+	q := um.db.Queries()
+	user, err := q.GetUserByUsername(ctx, username)
+	if err != nil {
+		return notFound(err, ErrUserNotFound, username)
+	}
+	r, err := q.GetRoleByName(ctx, role)
+	if err != nil {
+		return notFound(err, ErrRoleNotFound, role)
+	}
+
+	n, err := q.RemoveUserRole(ctx, user.ID, r.ID, utility.FromTenant(ctx))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("user %s does not have role %s", username, role)
+	}
+	return nil
+}
+
+// ProvisionedRoles are the roles of a user of an external provider
+type ProvisionedRoles struct {
+	Mapped  []string // from the claims of the login
+	Managed []string // all roles the claims map to, those no longer mapped are removed
+	Default []string // added when the user is created
+}
+
+// ProvisionUser returns the user linked to the subject of the provider, the user is created on the first login with
+// the username, the email and the default roles. A local user of the same name is never taken over. The mapped roles
+// are synced on every login, the managed roles no longer mapped are removed and the others are kept.
+func (um *UserManager) ProvisionUser(ctx context.Context, provider, subject, username, email string, roles ProvisionedRoles) (user *User, created bool, err error) {
+	err = um.db.Transaction(ctx, func(ctx context.Context) error {
+		q := um.db.Queries()
+		row, err := q.GetUserByIdentity(ctx, provider, subject)
+		if errors.Is(err, sql.ErrNoRows) {
+			if _, err = q.GetUserByUsername(ctx, username); err == nil {
+				return fmt.Errorf("%w: %s", ErrUserExists, username)
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+
+			if err = q.CreateUser(ctx, username, NoPassword, email); err != nil {
+				return err
+			}
+			if row, err = q.GetUserByUsername(ctx, username); err != nil {
+				return err
+			}
+			if err = q.CreateIdentity(ctx, provider, subject, row.ID); err != nil {
+				return err
+			}
+			created = true
+		} else if err != nil {
+			return err
+		}
+
+		current, err := um.UserRoles(ctx, row.Username)
+		if err != nil {
+			return err
+		}
+		add := roles.Mapped
+		if created {
+			add = append(append([]string{}, roles.Default...), roles.Mapped...)
+		}
+		for _, role := range add {
+			if !slices.Contains(current, role) {
+				if err = um.AddRole(ctx, row.Username, role); err != nil {
+					return err
+				}
+				current = append(current, role)
+			}
+		}
+		for _, role := range current {
+			if slices.Contains(roles.Managed, role) && !slices.Contains(roles.Mapped, role) {
+				if err = um.RemoveRole(ctx, row.Username, role); err != nil {
+					return err
+				}
+			}
+		}
+
+		user = &User{ID: int(row.ID), Username: row.Username, PasswordHash: row.Password, Email: row.Email, EmailVerifiedAt: row.EmailVerifiedAt}
+		return nil
+	})
+	return user, created, err
+}
+
+// RequireVerifiedEmail only lets the users with a verified email pass, the user is taken from the identity in the
+// request context, see utility.FromUserID
+func (um *UserManager) RequireVerifiedEmail() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		username := utility.FromUserID(ctx.Request.Context())
+		if username == "" {
+			NewResult(http.StatusUnauthorized, "未登录", nil).Abort(ctx, http.StatusUnauthorized)
+			return
+		}
+
+		user, err := um.GetUser(ctx.Request.Context(), username)
+		if errors.Is(err, ErrUserNotFound) {
+			NewResult(http.StatusUnauthorized, err.Error(), nil).Abort(ctx, http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			_ = ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		if user.EmailVerifiedAt == nil {
+			NewResult(http.StatusForbidden, "email not verified", nil).Abort(ctx, http.StatusForbidden)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func notFound(err error, target error, name string) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %s", target, name)
+	}
+	return err
+}
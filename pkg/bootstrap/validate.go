@@ -0,0 +1,165 @@
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// ConfigViolation describes one invalid config item
+type ConfigViolation struct {
+	Path    string // yaml path of the item, e.g. basic.database.dbtype
+	Rule    string // the failed rule
+	Message string
+}
+
+func (violation ConfigViolation) String() string {
+	return violation.Path + ": " + violation.Message
+}
+
+// ConfigErrors collects all violations found in a configuration
+type ConfigErrors []ConfigViolation
+
+func (errs ConfigErrors) Error() string {
+	lines := make([]string, 0, len(errs))
+	for _, violation := range errs {
+		lines = append(lines, violation.String())
+	}
+	return "invalid config:\n  " + strings.Join(lines, "\n  ")
+}
+
+var (
+	cfgValidator     *validator.Validate
+	cfgValidatorOnce sync.Once
+)
+
+func configValidator() *validator.Validate {
+	cfgValidatorOnce.Do(func() {
+		cfgValidator = validator.New(validator.WithRequiredStructEnabled())
+		// report items by their yaml names
+		cfgValidator.RegisterTagNameFunc(func(sf reflect.StructField) string {
+			name := yamlName(sf)
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+		_ = cfgValidator.RegisterValidation("proxy_list", isProxyList)
+		_ = cfgValidator.RegisterValidation("file_exists", fileExists)
+		_ = cfgValidator.RegisterValidation("dir_exists", dirExists)
+	})
+	return cfgValidator
+}
+
+// ValidateConfig checks cfg, a pointer to a configuration struct, against the `validate:` rules of its fields.
+// It returns ConfigErrors with every violation found.
+func ValidateConfig(cfg interface{}) error {
+	err := configValidator().Struct(cfg)
+	if err == nil {
+		return nil
+	}
+
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return err
+	}
+
+	violations := make(ConfigErrors, 0, len(verrs))
+	for _, verr := range verrs {
+		// strip the name of the root struct
+		_, path, _ := strings.Cut(verr.Namespace(), ".")
+		violations = append(violations, ConfigViolation{
+			Path:    path,
+			Rule:    verr.Tag(),
+			Message: violationMessage(verr),
+		})
+	}
+	return violations
+}
+
+func violationMessage(verr validator.FieldError) string {
+	var msg string
+	switch verr.Tag() {
+	case "required", "required_if", "required_unless":
+		return "is required"
+	case "oneof":
+		msg = "must be one of [" + verr.Param() + "]"
+	case "min", "gte":
+		msg = "must be at least " + verr.Param()
+	case "max", "lte":
+		msg = "must be at most " + verr.Param()
+	case "gt":
+		msg = "must be greater than " + verr.Param()
+	case "hostname_port":
+		msg = "must be an address in the form of host:port"
+	case "url":
+		msg = "must be a valid URL"
+	case "startswith":
+		msg = "must start with " + verr.Param()
+	case "proxy_list":
+		msg = "must be IP addresses or CIDRs separated by ';'"
+	case "file_exists":
+		msg = "file does not exist"
+	case "dir_exists":
+		msg = "directory does not exist"
+	default:
+		msg = "failed on rule '" + verr.Tag() + "'"
+	}
+	return fmt.Sprintf("%s (got %v)", msg, verr.Value())
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// custom rules
+
+func isProxyList(fl validator.FieldLevel) bool {
+	val := fl.Field().String()
+	if val == "" {
+		return true
+	}
+	for _, item := range strings.Split(val, ";") {
+		if net.ParseIP(item) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(item); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// sectionDisabled reports whether the struct holding the field has a false `Enable` field
+func sectionDisabled(fl validator.FieldLevel) bool {
+	parent := fl.Parent()
+	if parent.Kind() == reflect.Ptr {
+		parent = parent.Elem()
+	}
+	if parent.Kind() != reflect.Struct {
+		return false
+	}
+	enable := parent.FieldByName("Enable")
+	return enable.IsValid() && enable.Kind() == reflect.Bool && !enable.Bool()
+}
+
+// fileExists checks the file only if its section is enabled
+func fileExists(fl validator.FieldLevel) bool {
+	if sectionDisabled(fl) {
+		return true
+	}
+	info, err := os.Stat(fl.Field().String())
+	return err == nil && !info.IsDir()
+}
+
+// dirExists checks the directory only if its section is enabled
+func dirExists(fl validator.FieldLevel) bool {
+	if sectionDisabled(fl) {
+		return true
+	}
+	info, err := os.Stat(fl.Field().String())
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,266 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+const (
+	// editors usually emit several events for one save, wait a while before reloading
+	configReloadDelay = 200 * time.Millisecond
+)
+
+// LiveConfigItems lists the config items (or their parents) which take effect without a restart. The trusted proxies
+// are not, gin reads them unsynchronized for the client IP of every request.
+var LiveConfigItems = []string{
+	"middlewares.log.default_level",
+	"middlewares.cors",
+	"middlewares.rate_limit",
+	"sentry.traces_sample_rate",
+	"sentry.default_level",
+}
+
+// ConfigReloader tells the watcher which file to watch and how to load it again
+type ConfigReloader struct {
+	File string
+	Load func() (types.AppConfig, error)
+}
+
+// ConfigChange is published to subscribers once a modified config has been reloaded and validated
+type ConfigChange struct {
+	Old             types.AppConfig
+	New             types.AppConfig
+	Changed         []string // paths of all changed items
+	RestartRequired []string // changed items which only take effect after a restart
+}
+
+// Has reports whether the given item or any item below it has been changed
+func (change ConfigChange) Has(path string) bool {
+	for _, item := range change.Changed {
+		if matchConfigPath(item, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchConfigPath(item string, path string) bool {
+	return item == path || strings.HasPrefix(item, path+".")
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// ConfigWatcher reloads the config file on modification or SIGHUP and notifies the subscribers
+type ConfigWatcher struct {
+	reloader *ConfigReloader
+	logger   *AppLogger
+
+	mu          sync.RWMutex
+	current     types.AppConfig
+	subscribers []func(ConfigChange)
+}
+
+func NewConfigWatcher(cfg types.AppConfig, reloader *ConfigReloader, lc fx.Lifecycle, logger *AppLogger) *ConfigWatcher {
+	watcher := &ConfigWatcher{
+		reloader: reloader,
+		logger:   logger,
+		current:  cfg,
+	}
+	if reloader == nil || reloader.Load == nil {
+		return watcher
+	}
+
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			return watcher.start(done)
+		},
+		OnStop: func(context.Context) error {
+			close(done)
+			return nil
+		},
+	})
+	return watcher
+}
+
+// Current returns the latest valid configuration
+func (watcher *ConfigWatcher) Current() types.AppConfig {
+	watcher.mu.RLock()
+	defer watcher.mu.RUnlock()
+	return watcher.current
+}
+
+// Subscribe registers fn to be called after each successful reload which changed anything
+func (watcher *ConfigWatcher) Subscribe(fn func(ConfigChange)) {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	watcher.subscribers = append(watcher.subscribers, fn)
+}
+
+// Reload loads and validates the config again, then publishes the changes. An invalid config is rejected
+// and the current one stays in effect.
+func (watcher *ConfigWatcher) Reload() error {
+	if watcher.reloader == nil || watcher.reloader.Load == nil {
+		return errors.New("config reloader is not available")
+	}
+
+	cfg, err := watcher.reloader.Load()
+	if err != nil {
+		return err
+	}
+	if err = ValidateConfig(&cfg); err != nil {
+		return err
+	}
+	if err = checkLiveConfig(cfg); err != nil {
+		return err
+	}
+
+	watcher.mu.Lock()
+	change := diffConfig(watcher.current, cfg)
+	watcher.current = cfg
+	subscribers := append([]func(ConfigChange){}, watcher.subscribers...)
+	watcher.mu.Unlock()
+
+	if len(change.Changed) == 0 {
+		return nil
+	}
+
+	watcher.logger.Info("Config reloaded", zap.Strings("changed", change.Changed))
+	if len(change.RestartRequired) > 0 {
+		watcher.logger.Warn("Config changes require a restart to take effect", zap.Strings("items", change.RestartRequired))
+	}
+	for _, fn := range subscribers {
+		fn(change)
+	}
+	return nil
+}
+
+func (watcher *ConfigWatcher) start(done chan struct{}) error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// watch the directory instead of the file, as editors and k8s config maps replace the file on saving
+	file := filepath.Clean(watcher.reloader.File)
+	if err = fsw.Add(filepath.Dir(file)); err != nil {
+		_ = fsw.Close()
+		return err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		defer fsw.Close()
+
+		var timer <-chan time.Time
+		for {
+			select {
+			case <-done:
+				return
+			case <-hup:
+				watcher.reload("SIGHUP")
+			case evt, ok := <-fsw.Events:
+				if !ok {
+					return
+				}
+				name := filepath.Base(evt.Name)
+				if filepath.Clean(evt.Name) == file || name == "..data" {
+					timer = time.After(configReloadDelay)
+				}
+			case <-timer:
+				timer = nil
+				watcher.reload("file change")
+			case err, ok := <-fsw.Errors:
+				if !ok {
+					return
+				}
+				watcher.logger.Warn("Config watcher error: " + err.Error())
+			}
+		}
+	}()
+
+	watcher.logger.Info("Watching config file", zap.String("file", file))
+	return nil
+}
+
+func (watcher *ConfigWatcher) reload(reason string) {
+	if err := watcher.Reload(); err != nil {
+		watcher.logger.Error("Failed to reload config on "+reason+", keep the current one", zap.Error(err))
+	}
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func diffConfig(oldCfg types.AppConfig, newCfg types.AppConfig) ConfigChange {
+	change := ConfigChange{Old: oldCfg, New: newCfg}
+
+	// the slices are compared as a whole, the numbers of their elements may differ
+	var oldFields, newFields []ConfigField
+	for _, field := range ConfigFields(&oldCfg) {
+		if !field.Element {
+			oldFields = append(oldFields, field)
+		}
+	}
+	for _, field := range ConfigFields(&newCfg) {
+		if !field.Element {
+			newFields = append(newFields, field)
+		}
+	}
+	for i, field := range oldFields {
+		if reflect.DeepEqual(field.Value.Interface(), newFields[i].Value.Interface()) {
+			continue
+		}
+
+		change.Changed = append(change.Changed, field.Path)
+		if !isLiveConfigItem(field.Path) {
+			change.RestartRequired = append(change.RestartRequired, field.Path)
+		}
+	}
+	return change
+}
+
+func isLiveConfigItem(path string) bool {
+	for _, item := range LiveConfigItems {
+		if matchConfigPath(path, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLiveConfig makes sure all live items can be applied before publishing them
+func checkLiveConfig(cfg types.AppConfig) error {
+	var errs []error
+
+	if _, err := zapcore.ParseLevel(cfg.Middlewares.Log.DefaultLevel); err != nil {
+		errs = append(errs, fmt.Errorf("middlewares.log.default_level: %w", err))
+	}
+	if cfg.Middlewares.CORS.Enable {
+		if err := corsConfig(cfg).Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("middlewares.cors: %w", err))
+		}
+	}
+	if rate := cfg.Sentry.TracesSampleRate; rate < 0 || rate > 1 {
+		errs = append(errs, fmt.Errorf("sentry.traces_sample_rate: %v is out of range [0, 1]", rate))
+	}
+	return errors.Join(errs...)
+}
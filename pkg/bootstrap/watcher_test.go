@@ -0,0 +1,51 @@
+package bootstrap_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+func TestConfigWatcherReload(t *testing.T) {
+	current := *bootstrap.NewInstance[types.AppConfig]()
+	// sections which refer to files relative to the working directory
+	current.Middlewares.Static.Enable = false
+	current.Middlewares.Auth.Enable = false
+	next := current
+	reloader := &bootstrap.ConfigReloader{
+		Load: func() (types.AppConfig, error) { return next, nil },
+	}
+
+	watcher := bootstrap.NewConfigWatcher(current, reloader, fxtest.NewLifecycle(t), bootstrap.NewAppLogger())
+
+	var changes []bootstrap.ConfigChange
+	watcher.Subscribe(func(change bootstrap.ConfigChange) {
+		changes = append(changes, change)
+	})
+
+	// nothing changed, nothing published
+	require.NoError(t, watcher.Reload())
+	assert.Empty(t, changes)
+
+	next.Middlewares.Log.DefaultLevel = "warn"
+	next.Sentry.TracesSampleRate = 0.5
+	next.System.ServerAddr = ":8086"
+	next.System.TrustedProxies = "127.0.0.1"
+	require.NoError(t, watcher.Reload())
+	require.Len(t, changes, 1)
+	assert.True(t, changes[0].Has("middlewares.log"))
+	assert.True(t, changes[0].Has("sentry.traces_sample_rate"))
+	assert.ElementsMatch(t, []string{"system.server_address", "system.trusted_proxies"}, changes[0].RestartRequired)
+	assert.Equal(t, ":8086", watcher.Current().System.ServerAddr)
+
+	// an invalid config is rejected and the current one stays
+	next.Sentry.TracesSampleRate = 2
+	assert.Error(t, watcher.Reload())
+	assert.Len(t, changes, 1)
+	assert.Equal(t, 0.5, watcher.Current().Sentry.TracesSampleRate)
+}
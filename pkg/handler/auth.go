@@ -0,0 +1,483 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/cache/persistence"
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+	"github.com/robinmin/gin-starter/pkg/middleware"
+	"github.com/robinmin/gin-starter/pkg/utility"
+)
+
+// AuthHandler serves the built-in auth routes
+type AuthHandler struct {
+	cfg    types.AppConfig
+	author *bootstrap.Authenticator
+	users  *bootstrap.UserManager
+	tokens *middleware.JWTTokenPair
+	mfa    *bootstrap.MFAManager // nil unless MFA is enabled
+	authed gin.HandlerFunc
+	ident  gin.HandlerFunc
+	logger *bootstrap.AppLogger
+}
+
+var errNotTenantMember = errors.New("the user has no role in the tenant")
+
+type loginRequest struct {
+	Username string `json:"username" form:"username" binding:"required"`
+	Password string `json:"password" form:"password" binding:"required"`
+	Device   string `json:"device" form:"device"` // e.g. "Alice's iPhone", shown in the session list
+	Tenant   string `json:"tenant" form:"tenant"` // if the request names none, e.g. by the claim resolver
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" form:"refresh_token" binding:"required"`
+}
+
+type logoutRequest struct {
+	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+}
+
+type tokenResponse struct {
+	middleware.JWTTokenResult
+	ExpiresIn int `json:"expires_in"` // in second
+}
+
+type sessionResponse struct {
+	middleware.Session
+	Current bool `json:"current"`
+}
+
+type revokedResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+type meResponse struct {
+	ID            int      `json:"id"`
+	Username      string   `json:"username"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Roles         []string `json:"roles"`
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// NewAuthHandler creates the handler, the tokens are kept in store, or in memory if store is nil
+func NewAuthHandler(
+	cfg types.AppConfig,
+	author *bootstrap.Authenticator,
+	db *bootstrap.DBToolKit,
+	store persistence.CacheStore,
+	rds *bootstrap.RedisPool,
+	sty *bootstrap.AppSentry,
+	logger *bootstrap.AppLogger,
+) (*AuthHandler, error) {
+	token := cfg.Middlewares.Auth.Token
+	if store == nil {
+		store = persistence.NewInMemoryStore(token.AccessTTL)
+	}
+
+	extractors, err := middleware.ParseTokenLookup(token.Lookup)
+	if err != nil {
+		return nil, fmt.Errorf("invalid middlewares.auth.token.lookup: %w", err)
+	}
+
+	var keys *middleware.KeySet
+	if token.Algorithm != middleware.AlgHS256 {
+		keys, err = middleware.NewKeySet(middleware.KeySetOptions{
+			Algorithm:   token.Algorithm,
+			Files:       token.KeyFiles,
+			Dir:         token.KeyStore,
+			RotateEvery: token.RotateInterval,
+			Retain:      token.KeyRetention,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the signing keys: %w", err)
+		}
+	}
+
+	tokens := &middleware.JWTTokenPair{
+		AccessSecret:    token.AccessSecret,
+		RefreshSecret:   token.RefreshSecret,
+		RefreshDelay:    int((token.RefreshTTL - token.AccessTTL) / time.Minute),
+		ApplicationName: token.Issuer,
+		RDB:             store,
+		Keys:            keys,
+		Algorithms:      token.Algorithms,
+		Validation: middleware.ClaimsValidation{
+			Audience: token.Audience,
+			Leeway:   token.Leeway,
+			Required: token.RequiredClaims,
+		},
+		ReuseGrace: token.ReuseGrace,
+	}
+
+	// Add of the redis store is not atomic, and the sessions are shared by all instances
+	if _, ok := store.(*persistence.RedisStore); ok && rds != nil {
+		tokens.Rotations = &middleware.RedisRotationStore{Pool: (*redis.Pool)(rds)}
+		tokens.Index = &middleware.RedisSessionIndex{Pool: (*redis.Pool)(rds)}
+	}
+	tokens.OnReuse = func(username, family string) {
+		logger.Warn("Refresh token of " + username + " reused, revoked token family " + family)
+		if sty != nil {
+			sty.ReportEvent(bootstrap.EVT_AUTH_REFRESH_REUSED, "refresh token reused", map[string]interface{}{
+				"username": username,
+				"family":   family,
+			})
+		}
+	}
+
+	authConfig := middleware.JWTAuthConfig{
+		Extractors: extractors,
+		RootRole:   cfg.Middlewares.Auth.RootRole,
+		Tenants:    cfg.Middlewares.Auth.Tenant.Enable,
+	}
+	if apiKeys := cfg.Middlewares.Auth.APIKeys; apiKeys.Enable {
+		authConfig.APIKeys = bootstrap.NewAPIKeyManager(db)
+		authConfig.APIKeyHeader = apiKeys.Header
+	}
+	identConfig := authConfig
+	identConfig.Optional = true
+
+	var mfa *bootstrap.MFAManager
+	if cfg.Middlewares.Auth.MFA.Enable {
+		issuer := cfg.Middlewares.Auth.MFA.Issuer
+		if issuer == "" {
+			issuer = token.Issuer
+		}
+		mfa = bootstrap.NewMFAManager(db, issuer)
+	}
+
+	return &AuthHandler{
+		cfg:    cfg,
+		author: author,
+		users:  bootstrap.NewUserManager(db),
+		tokens: tokens,
+		mfa:    mfa,
+		authed: middleware.JWTAuthMiddlewareWithConfig(tokens, authConfig),
+		ident:  middleware.JWTAuthMiddlewareWithConfig(tokens, identConfig),
+		logger: logger,
+	}, nil
+}
+
+// Keys returns the keys signing the access tokens, nil if they are signed by the access secret
+func (h *AuthHandler) Keys() *middleware.KeySet {
+	return h.tokens.Keys
+}
+
+// Identify returns the middleware putting the identity of the access token into the request context, the requests
+// without any token pass anonymously
+func (h *AuthHandler) Identify() gin.HandlerFunc {
+	return h.ident
+}
+
+// Register adds the auth routes to the router
+func (h *AuthHandler) Register(router gin.IRouter) error {
+	if h.tokens.RefreshSecret == "" || h.tokens.AccessSecret == "" && h.tokens.Keys == nil {
+		return errors.New("middlewares.auth.token.refresh_secret and access_secret or signing keys are required by the auth routes")
+	}
+
+	authed := h.authed
+	router.POST("/login", h.Login)
+	router.POST("/refresh", h.Refresh)
+	router.POST("/logout", authed, h.Logout)
+	router.GET("/me", authed, middleware.RequireScopes(), h.Me) // any API key
+	router.GET("/sessions", authed, h.Sessions)
+	router.DELETE("/sessions", authed, h.RevokeOtherSessions)
+	router.DELETE("/sessions/:id", authed, h.RevokeSession)
+	if h.mfa != nil {
+		h.registerMFA(router)
+	}
+	return nil
+}
+
+// RegisterAdmin adds the admin routes to the router, they must be authorized by casbin, as the routes only check the
+// access token
+func (h *AuthHandler) RegisterAdmin(router gin.IRouter) {
+	router.DELETE("/users/:username/sessions", h.authed, h.RevokeUserSessions)
+	if h.mfa != nil {
+		router.DELETE("/users/:username/mfa", h.authed, h.ResetUserMFA)
+	}
+}
+
+// RegisterJWKS publishes the public keys at the well-known path, if the access tokens are signed by keys
+func (h *AuthHandler) RegisterJWKS(router gin.IRouter) {
+	if h.tokens.Keys != nil {
+		router.GET(middleware.JWKSPath, middleware.JWKSHandler(h.tokens.Keys))
+	}
+}
+
+// Login exchanges the username and password for a token pair, or for an MFA challenge if the user must pass the
+// second factor
+func (h *AuthHandler) Login(ctx *gin.Context) {
+	var req loginRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		bootstrap.NewResult(http.StatusBadRequest, err.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+	if !h.loginTenant(ctx, req.Tenant) {
+		return
+	}
+
+	user, err := h.author.Login(ctx.Request.Context(), req.Username, req.Password, ctx.ClientIP())
+	var locked *bootstrap.LockedError
+	switch {
+	case errors.As(err, &locked):
+		ctx.Header("Retry-After", strconv.Itoa(int(locked.RetryAfter.Round(time.Second)/time.Second)))
+		bootstrap.NewResult(http.StatusTooManyRequests, err.Error(), nil).Abort(ctx, http.StatusTooManyRequests)
+		return
+	case errors.Is(err, bootstrap.ErrInvalidCredentials):
+		bootstrap.NewResult(http.StatusUnauthorized, err.Error(), nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	case err != nil:
+		_ = ctx.Error(err)
+		return
+	}
+
+	if _, err = h.tenantRoles(ctx, user.Username); err != nil {
+		h.tokenError(ctx, err)
+		return
+	}
+
+	if h.mfa != nil {
+		challenge, err := h.mfaChallenge(ctx, user.Username, req.Device)
+		if err != nil {
+			_ = ctx.Error(err)
+			return
+		}
+		if challenge != nil {
+			bootstrap.NewResult(http.StatusOK, "mfa required", challenge).OK(ctx)
+			return
+		}
+	}
+
+	resp, err := h.issueTokens(ctx, user.Username, req.Device)
+	if err != nil {
+		h.tokenError(ctx, err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", resp).OK(ctx)
+}
+
+// Refresh issues a new access token for the refresh token, with the current roles of the user in the tenant of the
+// token. The users deleted since the login can not refresh.
+func (h *AuthHandler) Refresh(ctx *gin.Context) {
+	var req refreshRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		bootstrap.NewResult(http.StatusBadRequest, err.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+
+	pair, err := h.tokens.RefreshTokenPairWithClaims(req.RefreshToken, h.accessMinutes(), func(claims middleware.Claims) (middleware.Claims, error) {
+		if _, err := h.users.GetUser(ctx.Request.Context(), claims.Username); err != nil {
+			return claims, err
+		}
+		ctx.Request = ctx.Request.WithContext(utility.NewTenant(ctx.Request.Context(), claims.Tenant))
+		roles, err := h.tenantRoles(ctx, claims.Username)
+		claims.Roles = roles
+		return claims, err
+	})
+	switch {
+	case errors.Is(err, bootstrap.ErrUserNotFound):
+		bootstrap.NewResult(http.StatusUnauthorized, middleware.ErrInvalidRefreshToken.Error(), nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	case errors.Is(err, errNotTenantMember):
+		h.tokenError(ctx, err)
+		return
+	case errors.Is(err, middleware.ErrRefreshTokenRotated):
+		bootstrap.NewResult(http.StatusConflict, err.Error(), nil).Abort(ctx, http.StatusConflict)
+		return
+	case errors.Is(err, middleware.ErrInvalidRefreshToken), errors.Is(err, middleware.ErrRefreshTokenReused):
+		bootstrap.NewResult(http.StatusUnauthorized, err.Error(), nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	case err != nil:
+		_ = ctx.Error(err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", h.tokenResponse(*pair)).OK(ctx)
+}
+
+// Logout releases the access token of the request, and the refresh token if given
+func (h *AuthHandler) Logout(ctx *gin.Context) {
+	var req logoutRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		bootstrap.NewResult(http.StatusBadRequest, err.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+
+	// nobody can release the refresh tokens of others
+	if req.RefreshToken != "" {
+		claims, err := h.tokens.ParseToken(req.RefreshToken, h.tokens.RefreshSecret)
+		if err != nil || claims.Username != ctx.GetString("username") {
+			bootstrap.NewResult(http.StatusBadRequest, "invalid refresh token", nil).Abort(ctx, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, err := h.tokens.ReleaseTokenPair(ctx.GetString("token"), req.RefreshToken); err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", nil).OK(ctx)
+}
+
+// Me returns the current user with its roles
+func (h *AuthHandler) Me(ctx *gin.Context) {
+	username := ctx.GetString("username")
+	user, err := h.users.GetUser(ctx.Request.Context(), username)
+	if errors.Is(err, bootstrap.ErrUserNotFound) {
+		// deleted after the token was issued
+		bootstrap.NewResult(http.StatusUnauthorized, err.Error(), nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	roles, err := h.users.UserRoles(ctx.Request.Context(), username)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", meResponse{
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		Roles:         roles,
+	}).OK(ctx)
+}
+
+// Sessions lists the active sessions of the current user
+func (h *AuthHandler) Sessions(ctx *gin.Context) {
+	sessions, err := h.tokens.Sessions(ctx.GetString("username"))
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	current := ctx.GetString("session")
+	resp := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		resp = append(resp, sessionResponse{Session: session, Current: session.ID == current})
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", resp).OK(ctx)
+}
+
+// RevokeSession logs one session of the current user out
+func (h *AuthHandler) RevokeSession(ctx *gin.Context) {
+	err := h.tokens.RevokeSession(ctx.GetString("username"), ctx.Param("id"))
+	if errors.Is(err, middleware.ErrSessionNotFound) {
+		bootstrap.NewResult(http.StatusNotFound, err.Error(), nil).Abort(ctx, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", nil).OK(ctx)
+}
+
+// RevokeOtherSessions logs all sessions of the current user out except the current one
+func (h *AuthHandler) RevokeOtherSessions(ctx *gin.Context) {
+	n, err := h.tokens.RevokeOtherSessions(ctx.GetString("username"), ctx.GetString("session"))
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", revokedResponse{Revoked: n}).OK(ctx)
+}
+
+// RevokeUserSessions forces the user out of all sessions
+func (h *AuthHandler) RevokeUserSessions(ctx *gin.Context) {
+	username := ctx.Param("username")
+	n, err := h.tokens.RevokeUserSessions(username)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	h.logger.Info(fmt.Sprintf("%s forced %s out of %d sessions", ctx.GetString("username"), username, n))
+	bootstrap.NewResult(http.StatusOK, "ok", revokedResponse{Revoked: n}).OK(ctx)
+}
+
+// issueTokens starts a session of the user logged in from the device of the request, the tokens are bound to the
+// tenant of the request
+func (h *AuthHandler) issueTokens(ctx *gin.Context, username string, deviceName string) (*tokenResponse, error) {
+	roles, err := h.tenantRoles(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := middleware.Claims{Username: username, Roles: roles, Tenant: utility.FromTenant(ctx.Request.Context())}
+	device := middleware.Device{Name: deviceName, IP: ctx.ClientIP(), UserAgent: ctx.Request.UserAgent()}
+	pair, err := h.tokens.GenerateTokenPairWithClaims(claims, device, h.accessMinutes())
+	if err != nil {
+		return nil, err
+	}
+	resp := h.tokenResponse(*pair)
+	return &resp, nil
+}
+
+// loginTenant puts the tenant of the login request into the request context if the request names none otherwise, it
+// responds 400 to an invalid tenant or one other than that of the request
+func (h *AuthHandler) loginTenant(ctx *gin.Context, tenant string) bool {
+	if tenant == "" || !h.cfg.Middlewares.Auth.Tenant.Enable {
+		return true
+	}
+
+	current := utility.FromTenant(ctx.Request.Context())
+	switch {
+	case !bootstrap.ValidTenant(tenant):
+		bootstrap.NewResult(http.StatusBadRequest, "无效的租户", nil).Abort(ctx, http.StatusBadRequest)
+		return false
+	case current != "" && current != tenant:
+		bootstrap.NewResult(http.StatusBadRequest, "租户与请求不一致", nil).Abort(ctx, http.StatusBadRequest)
+		return false
+	}
+	ctx.Set("tenant", tenant)
+	ctx.Request = ctx.Request.WithContext(utility.NewTenant(ctx.Request.Context(), tenant))
+	return true
+}
+
+// tenantRoles returns the roles of the user in the tenant of the request, or errNotTenantMember if the user has none
+// in a named tenant
+func (h *AuthHandler) tenantRoles(ctx *gin.Context, username string) ([]string, error) {
+	c := ctx.Request.Context()
+	roles, err := h.users.UserRoles(c, username)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 && h.cfg.Middlewares.Auth.Tenant.Enable && utility.FromTenant(c) != "" {
+		return nil, errNotTenantMember
+	}
+	return roles, nil
+}
+
+// tokenError responds 403 to the users who are not members of the tenant, and reports other errors
+func (h *AuthHandler) tokenError(ctx *gin.Context, err error) {
+	if errors.Is(err, errNotTenantMember) {
+		bootstrap.NewResult(http.StatusForbidden, err.Error(), nil).Abort(ctx, http.StatusForbidden)
+		return
+	}
+	_ = ctx.Error(err)
+}
+
+func (h *AuthHandler) accessMinutes() int {
+	return int(h.cfg.Middlewares.Auth.Token.AccessTTL / time.Minute)
+}
+
+func (h *AuthHandler) tokenResponse(pair middleware.JWTTokenResult) tokenResponse {
+	return tokenResponse{
+		JWTTokenResult: pair,
+		ExpiresIn:      h.accessMinutes() * 60,
+	}
+}
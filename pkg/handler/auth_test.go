@@ -0,0 +1,466 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+	"github.com/robinmin/gin-starter/pkg/handler"
+)
+
+type result struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func newAuthRouter(t *testing.T, options ...func(cfg *types.AppConfig)) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	cfg := *bootstrap.NewInstance[types.AppConfig]()
+	cfg.Database.Type = "sqlite3"
+	cfg.Database.Database = filepath.Join(t.TempDir(), "test.db")
+	cfg.Database.AutoMigrate = true
+	cfg.Middlewares.Auth.BcryptCost = 4
+	cfg.Middlewares.Auth.Token.AccessSecret = "access"
+	cfg.Middlewares.Auth.Token.RefreshSecret = "refresh"
+	cfg.Middlewares.Auth.ModelFile = "../../config/rbac_model.conf"
+	cfg.Middlewares.Auth.RootRole = "admin"
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	db, err := bootstrap.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	um := bootstrap.NewUserManager(db)
+	require.NoError(t, um.CreateUser(context.Background(), "alice", "secret", "alice@example.com"))
+	require.NoError(t, um.AddRole(context.Background(), "alice", "admin"))
+
+	logger := bootstrap.NewAppLogger()
+	h, err := handler.NewAuthHandler(cfg, bootstrap.NewAuthenticator(cfg, db, nil, nil, logger), db, nil, nil, nil, logger)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(bootstrap.GlobalErrorHandler())
+	router.Use(sessions.Sessions(cfg.Middlewares.Session.Name, cookie.NewStore([]byte("secret"))))
+	if tenant := cfg.Middlewares.Auth.Tenant; tenant.Enable {
+		resolve, err := bootstrap.NewTenantResolver(cfg)
+		require.NoError(t, err)
+		router.Use(bootstrap.TenantHandler(resolve, tenant.Default))
+	}
+	h.RegisterJWKS(router)
+	require.NoError(t, h.Register(router.Group("/auth")))
+	if cfg.Middlewares.Auth.Enable {
+		// authorized by casbin as in the application
+		author, err := bootstrap.NewAuthorizerWithDB(cfg.Middlewares.Auth.ModelFile, cfg.Middlewares.Auth.TableName, db)
+		require.NoError(t, err)
+		admin := router.Group("/admin")
+		admin.Use(h.Identify(), author.AuthorizerHandler())
+		handler.NewPolicyHandler(h, bootstrap.NewPolicyManager(author, db), logger).RegisterAdmin(admin)
+		h.RegisterAdmin(admin)
+	}
+	if cfg.Middlewares.Auth.OIDC.Enable {
+		oh, err := handler.NewOIDCHandler(cfg, h, db, logger)
+		require.NoError(t, err)
+		require.NoError(t, oh.Register(router.Group("/auth")))
+	}
+	if cfg.Middlewares.Auth.Email.Enable {
+		mailer, err := bootstrap.NewMailer(cfg)
+		require.NoError(t, err)
+		require.NoError(t, handler.NewEmailHandler(cfg, h, db, mailer, logger).Register(router.Group("/auth")))
+	}
+	return router
+}
+
+func call(t *testing.T, router *gin.Engine, method, path, token, body string) (int, result) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var res result
+	_ = json.Unmarshal(w.Body.Bytes(), &res)
+	return w.Code, res
+}
+
+func TestAuthRoutes(t *testing.T) {
+	router := newAuthRouter(t)
+
+	code, res := call(t, router, http.MethodPost, "/auth/login", "", `{"username":"alice","password":"wrong"}`)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	code, _ = call(t, router, http.MethodPost, "/auth/login", "", `{"username":"alice"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, res = call(t, router, http.MethodPost, "/auth/login", "", `{"username":"alice","password":"secret"}`)
+	require.Equal(t, http.StatusOK, code)
+	var pair struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+	}
+	require.NoError(t, json.Unmarshal(res.Data, &pair))
+	assert.NotEmpty(t, pair.AccessToken)
+	assert.Equal(t, 15*60, pair.ExpiresIn)
+
+	code, res = call(t, router, http.MethodGet, "/auth/me", pair.AccessToken, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"id":1,"username":"alice","email":"alice@example.com","email_verified":false,"roles":["admin"]}`, string(res.Data))
+
+	code, _ = call(t, router, http.MethodGet, "/auth/me", "", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// the refreshed access token replaces the old one
+	old := pair.AccessToken
+	code, res = call(t, router, http.MethodPost, "/auth/refresh", "", `{"refresh_token":"`+pair.RefreshToken+`"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal(res.Data, &pair))
+	code, _ = call(t, router, http.MethodGet, "/auth/me", pair.AccessToken, "")
+	assert.Equal(t, http.StatusOK, code)
+	if old != pair.AccessToken {
+		code, _ = call(t, router, http.MethodGet, "/auth/me", old, "")
+		assert.Equal(t, http.StatusUnauthorized, code)
+	}
+
+	code, _ = call(t, router, http.MethodPost, "/auth/refresh", "", `{"refresh_token":"bogus"}`)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = call(t, router, http.MethodPost, "/auth/logout", pair.AccessToken, `{"refresh_token":"`+pair.RefreshToken+`"}`)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = call(t, router, http.MethodGet, "/auth/me", pair.AccessToken, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = call(t, router, http.MethodPost, "/auth/refresh", "", `{"refresh_token":"`+pair.RefreshToken+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestRefreshRoles(t *testing.T) {
+	var db *bootstrap.DBToolKit
+	router := newAuthRouter(t, func(cfg *types.AppConfig) {
+		var err error
+		db, err = bootstrap.NewDB(*cfg)
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+	})
+	var pair struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	refresh := func() int {
+		code, res := call(t, router, http.MethodPost, "/auth/refresh", "", `{"refresh_token":"`+pair.RefreshToken+`"}`)
+		if code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(res.Data, &pair))
+		}
+		return code
+	}
+	code, res := call(t, router, http.MethodPost, "/auth/login", "", `{"username":"alice","password":"secret"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal(res.Data, &pair))
+
+	// the roles revoked since the login are not carried over
+	um := bootstrap.NewUserManager(db)
+	require.NoError(t, um.RemoveRole(context.Background(), "alice", "admin"))
+	require.Equal(t, http.StatusOK, refresh())
+	var claims jwt.MapClaims
+	_, _, err := jwt.NewParser().ParseUnverified(pair.AccessToken, &claims)
+	require.NoError(t, err)
+	assert.Nil(t, claims["roles"])
+
+	// nor are the deleted users refreshed
+	require.NoError(t, um.DeleteUser(context.Background(), "alice"))
+	assert.Equal(t, http.StatusUnauthorized, refresh())
+}
+
+func TestAuthRoutesWithKeys(t *testing.T) {
+	router := newAuthRouter(t, func(cfg *types.AppConfig) {
+		cfg.Middlewares.Auth.Token.AccessSecret = ""
+		cfg.Middlewares.Auth.Token.Algorithm = "ES256"
+		cfg.Middlewares.Auth.Token.KeyStore = t.TempDir()
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+		} `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jwks))
+	require.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "ES256", jwks.Keys[0].Alg)
+
+	code, res := call(t, router, http.MethodPost, "/auth/login", "", `{"username":"alice","password":"secret"}`)
+	require.Equal(t, http.StatusOK, code)
+	var pair struct {
+		AccessToken string `json:"access_token"`
+	}
+	require.NoError(t, json.Unmarshal(res.Data, &pair))
+	code, _ = call(t, router, http.MethodGet, "/auth/me", pair.AccessToken, "")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestSessionRoutes(t *testing.T) {
+	router := newAuthRouter(t)
+	login := func(device string) string {
+		code, res := call(t, router, http.MethodPost, "/auth/login", "", `{"username":"alice","password":"secret","device":"`+device+`"}`)
+		require.Equal(t, http.StatusOK, code)
+		var pair struct {
+			AccessToken string `json:"access_token"`
+		}
+		require.NoError(t, json.Unmarshal(res.Data, &pair))
+		return pair.AccessToken
+	}
+	phone, laptop, tablet := login("phone"), login("laptop"), login("tablet")
+
+	type session struct {
+		ID      string `json:"id"`
+		Device  string `json:"device"`
+		Current bool   `json:"current"`
+	}
+	list := func(token string) []session {
+		code, res := call(t, router, http.MethodGet, "/auth/sessions", token, "")
+		require.Equal(t, http.StatusOK, code)
+		var sessions []session
+		require.NoError(t, json.Unmarshal(res.Data, &sessions))
+		return sessions
+	}
+
+	sessions := list(phone)
+	require.Len(t, sessions, 3)
+	var laptopID string
+	for _, s := range sessions {
+		assert.Equal(t, s.Device == "phone", s.Current)
+		if s.Device == "laptop" {
+			laptopID = s.ID
+		}
+	}
+
+	code, _ := call(t, router, http.MethodDelete, "/auth/sessions/"+laptopID, phone, "")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = call(t, router, http.MethodGet, "/auth/me", laptop, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = call(t, router, http.MethodDelete, "/auth/sessions/"+laptopID, phone, "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, res := call(t, router, http.MethodDelete, "/auth/sessions", phone, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"revoked":1}`, string(res.Data))
+	code, _ = call(t, router, http.MethodGet, "/auth/me", tablet, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Len(t, list(phone), 1)
+
+	code, res = call(t, router, http.MethodDelete, "/admin/users/alice/sessions", phone, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"revoked":1}`, string(res.Data))
+	code, _ = call(t, router, http.MethodGet, "/auth/me", phone, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	var db *bootstrap.DBToolKit
+	router := newAuthRouter(t, func(cfg *types.AppConfig) {
+		cfg.Middlewares.Auth.APIKeys.Enable = true
+		var err error
+		db, err = bootstrap.NewDB(*cfg)
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+	})
+	key, _, err := bootstrap.NewAPIKeyManager(db).Create(context.Background(), "alice", "ci", bootstrap.APIKeyOptions{RateLimit: 2})
+	require.NoError(t, err)
+
+	// as a Bearer token
+	code, res := call(t, router, http.MethodGet, "/auth/me", key, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"id":1,"username":"alice","email":"alice@example.com","email_verified":false,"roles":["admin"]}`, string(res.Data))
+
+	// in the header
+	req := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+	req.Header.Set("X-API-Key", key)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// beyond the rate limit
+	code, _ = call(t, router, http.MethodGet, "/auth/me", key, "")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	code, _ = call(t, router, http.MethodGet, "/auth/me", key+"x", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// denied by the routes declaring no scopes
+	key, _, err = bootstrap.NewAPIKeyManager(db).Create(context.Background(), "alice", "cd", bootstrap.APIKeyOptions{Scopes: []string{"orders:read"}})
+	require.NoError(t, err)
+	code, _ = call(t, router, http.MethodGet, "/auth/sessions", key, "")
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = call(t, router, http.MethodGet, "/auth/me", key, "")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestMFALogin(t *testing.T) {
+	var cfg types.AppConfig
+	router := newAuthRouter(t, func(c *types.AppConfig) {
+		c.Middlewares.Auth.MFA.Enable = true
+		c.Middlewares.Auth.MFA.MaxAttempts = 2
+		c.Middlewares.Auth.Lockout.MaxAttempts = 3
+		cfg = *c
+	})
+	db, err := bootstrap.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	login := func() (string, *bootstrap.TOTPEnrollment) {
+		code, res := call(t, router, http.MethodPost, "/auth/login", "", `{"username":"alice","password":"secret"}`)
+		require.Equal(t, http.StatusOK, code)
+		var challenge struct {
+			MFARequired    bool                      `json:"mfa_required"`
+			ChallengeToken string                    `json:"challenge_token"`
+			AccessToken    string                    `json:"access_token"`
+			Enrollment     *bootstrap.TOTPEnrollment `json:"enrollment"`
+		}
+		require.NoError(t, json.Unmarshal(res.Data, &challenge))
+		if !challenge.MFARequired {
+			return challenge.AccessToken, nil
+		}
+		assert.Empty(t, challenge.AccessToken)
+		return challenge.ChallengeToken, challenge.Enrollment
+	}
+	verify := func(challenge, code string) (int, result) {
+		return call(t, router, http.MethodPost, "/auth/mfa/verify", "", `{"challenge_token":"`+challenge+`","code":"`+code+`"}`)
+	}
+	totpCode := func(secret string, at time.Time) string {
+		code, err := totp.GenerateCode(secret, at)
+		require.NoError(t, err)
+		return code
+	}
+
+	// enrolled by the user
+	token, enrollment := login()
+	require.Nil(t, enrollment)
+	code, res := call(t, router, http.MethodPost, "/auth/mfa/totp", token, "")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal(res.Data, &enrollment))
+	code, _ = call(t, router, http.MethodPost, "/auth/mfa/totp/activate", token, `{"code":"000000"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, res = call(t, router, http.MethodPost, "/auth/mfa/totp/activate", token, `{"code":"`+totpCode(enrollment.Secret, time.Now())+`"}`)
+	require.Equal(t, http.StatusOK, code)
+	var recovery struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	require.NoError(t, json.Unmarshal(res.Data, &recovery))
+	require.Len(t, recovery.RecoveryCodes, 10)
+
+	// the challenge is dropped after too many invalid codes
+	challenge, _ := login()
+	code, _ = verify(challenge, "000000")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, res = verify(challenge, "000000")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Contains(t, res.Message, "login again")
+	code, _ = verify(challenge, recovery.RecoveryCodes[0])
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// the code of the next step, as the one of the activation is used
+	challenge, _ = login()
+	code, res = verify(challenge, totpCode(enrollment.Secret, time.Now().Add(30*time.Second)))
+	require.Equal(t, http.StatusOK, code, res.Message)
+	var pair struct {
+		AccessToken string `json:"access_token"`
+	}
+	require.NoError(t, json.Unmarshal(res.Data, &pair))
+	code, _ = call(t, router, http.MethodGet, "/auth/me", pair.AccessToken, "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = verify(challenge, recovery.RecoveryCodes[0])
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// a recovery code instead
+	challenge, _ = login()
+	code, _ = verify(challenge, recovery.RecoveryCodes[0])
+	require.Equal(t, http.StatusOK, code)
+
+	// required by the role, the user enrolls in the login after the reset
+	require.NoError(t, bootstrap.NewMFAManager(db, "").SetRoleRequired(context.Background(), "admin", true))
+	code, _ = call(t, router, http.MethodDelete, "/auth/mfa/totp", pair.AccessToken, `{"code":"`+recovery.RecoveryCodes[1]+`"}`)
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = call(t, router, http.MethodDelete, "/admin/users/alice/mfa", pair.AccessToken, "")
+	require.Equal(t, http.StatusOK, code)
+
+	challenge, enrollment = login()
+	require.NotNil(t, enrollment)
+	code, res = verify(challenge, totpCode(enrollment.Secret, time.Now()))
+	require.Equal(t, http.StatusOK, code, res.Message)
+	require.NoError(t, json.Unmarshal(res.Data, &recovery))
+	assert.Len(t, recovery.RecoveryCodes, 10)
+
+	code, res = call(t, router, http.MethodGet, "/auth/mfa", pair.AccessToken, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"enabled":true,"required":true,"recovery_codes":10}`, string(res.Data))
+
+	// the invalid codes of new challenges lock the user as the failed logins
+	for i := 0; i < 2; i++ {
+		challenge, _ = login()
+		code, _ = verify(challenge, "000000")
+		assert.Equal(t, http.StatusUnauthorized, code)
+	}
+	challenge, _ = login()
+	code, _ = verify(challenge, "000000")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	code, _ = verify(challenge, totpCode(enrollment.Secret, time.Now()))
+	assert.Equal(t, http.StatusUnauthorized, code, "the challenge is dropped")
+	code, _ = call(t, router, http.MethodPost, "/auth/login", "", `{"username":"alice","password":"secret"}`)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+}
+
+func TestMFACodesLockout(t *testing.T) {
+	router := newAuthRouter(t, func(c *types.AppConfig) {
+		c.Middlewares.Auth.MFA.Enable = true
+		c.Middlewares.Auth.Lockout.MaxAttempts = 2
+	})
+	code, res := call(t, router, http.MethodPost, "/auth/login", "", `{"username":"alice","password":"secret"}`)
+	require.Equal(t, http.StatusOK, code)
+	var pair struct {
+		AccessToken string `json:"access_token"`
+	}
+	require.NoError(t, json.Unmarshal(res.Data, &pair))
+	code, res = call(t, router, http.MethodPost, "/auth/mfa/totp", pair.AccessToken, "")
+	require.Equal(t, http.StatusOK, code)
+	var enrollment bootstrap.TOTPEnrollment
+	require.NoError(t, json.Unmarshal(res.Data, &enrollment))
+	totpCode, err := totp.GenerateCode(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	code, _ = call(t, router, http.MethodPost, "/auth/mfa/totp/activate", pair.AccessToken, `{"code":"`+totpCode+`"}`)
+	require.Equal(t, http.StatusOK, code)
+
+	// the codes of a stolen access token are not guessed without limit
+	code, _ = call(t, router, http.MethodPost, "/auth/mfa/recovery-codes", pair.AccessToken, `{"code":"000000"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = call(t, router, http.MethodDelete, "/auth/mfa/totp", pair.AccessToken, `{"code":"000000"}`)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	totpCode, err = totp.GenerateCode(enrollment.Secret, time.Now().Add(30*time.Second))
+	require.NoError(t, err)
+	code, _ = call(t, router, http.MethodDelete, "/auth/mfa/totp", pair.AccessToken, `{"code":"`+totpCode+`"}`)
+	assert.Equal(t, http.StatusTooManyRequests, code, "locked")
+}
@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cache/persistence"
+	"github.com/gin-gonic/gin"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+// the forgot password mails are sent in background, so that the response doesn't tell whether the email is registered
+const emailSendTimeout = 30 * time.Second
+
+// EmailHandler resets the passwords and verifies the emails of the users by the links mailed to them
+type EmailHandler struct {
+	cfg    types.AppConfig
+	auth   *AuthHandler
+	users  *bootstrap.UserManager
+	tokens *bootstrap.OneTimeTokens
+	mailer bootstrap.Mailer // nil if mailer.type is none
+	logger *bootstrap.AppLogger
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" form:"email" binding:"required,email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" form:"token" binding:"required"`
+	Password string `json:"password" form:"password" binding:"required,min=8"`
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// NewEmailHandler creates the handler, the tokens are kept in the token store of the auth routes
+func NewEmailHandler(
+	cfg types.AppConfig,
+	auth *AuthHandler,
+	db *bootstrap.DBToolKit,
+	mailer bootstrap.Mailer,
+	logger *bootstrap.AppLogger,
+) *EmailHandler {
+	return &EmailHandler{
+		cfg:    cfg,
+		auth:   auth,
+		users:  bootstrap.NewUserManager(db),
+		tokens: bootstrap.NewOneTimeTokens(auth.tokens.RDB, cfg.Middlewares.Auth.Email.TokenSecret),
+		mailer: mailer,
+		logger: logger,
+	}
+}
+
+// Register adds the password reset and email verification routes to the router of the auth routes
+func (h *EmailHandler) Register(router gin.IRouter) error {
+	if h.mailer == nil {
+		return errors.New("mailer is required by middlewares.auth.email")
+	}
+
+	router.POST("/password/forgot", h.ForgotPassword)
+	router.POST("/password/reset", h.ResetPassword)
+	router.POST("/email/verification", h.auth.authed, h.SendVerification)
+	router.POST("/email/verify", h.VerifyEmail)
+	return nil
+}
+
+// ForgotPassword mails a password reset link to the user of the email. It responds the same whether the email is
+// registered or not.
+func (h *EmailHandler) ForgotPassword(ctx *gin.Context) {
+	var req forgotPasswordRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		bootstrap.NewResult(http.StatusBadRequest, err.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.users.GetUserByEmail(ctx.Request.Context(), req.Email)
+	if err != nil && !errors.Is(err, bootstrap.ErrUserNotFound) {
+		_ = ctx.Error(err)
+		return
+	}
+	// the users provisioned by external providers have no password to reset
+	if err == nil && user.PasswordHash != bootstrap.NoPassword {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), emailSendTimeout)
+			defer cancel()
+			if err := h.send(ctx, bootstrap.PurposePasswordReset, user); err != nil && !errors.Is(err, errTooSoon) {
+				h.logger.Error("Failed to send the password reset mail to " + user.Username + ": " + err.Error())
+			}
+		}()
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", nil).OK(ctx)
+}
+
+// ResetPassword replaces the password of the user by the token of the mailed link, and logs all its sessions out
+func (h *EmailHandler) ResetPassword(ctx *gin.Context) {
+	var req resetPasswordRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		bootstrap.NewResult(http.StatusBadRequest, err.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+
+	entry, ok := h.consume(ctx, bootstrap.PurposePasswordReset, req.Token)
+	if !ok {
+		return
+	}
+	// the link is void once the user changed its email
+	user, err := h.users.GetUser(ctx.Request.Context(), entry.Username)
+	if err == nil && user.Email != entry.Email {
+		err = bootstrap.ErrInvalidOneTimeToken
+	}
+	if err != nil {
+		h.tokenError(ctx, err)
+		return
+	}
+	if err = h.users.SetPassword(ctx.Request.Context(), entry.Username, req.Password); err != nil {
+		h.tokenError(ctx, err)
+		return
+	}
+	// the link proves the email as well
+	if err = h.users.VerifyEmail(ctx.Request.Context(), entry.Username, entry.Email); err != nil {
+		h.tokenError(ctx, err)
+		return
+	}
+
+	n, err := h.auth.tokens.RevokeUserSessions(entry.Username)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	h.logger.Info(fmt.Sprintf("%s reset the password, logged out of %d sessions", entry.Username, n))
+	bootstrap.NewResult(http.StatusOK, "ok", nil).OK(ctx)
+}
+
+// SendVerification mails an email verification link to the current user
+func (h *EmailHandler) SendVerification(ctx *gin.Context) {
+	user, err := h.users.GetUser(ctx.Request.Context(), ctx.GetString("username"))
+	if errors.Is(err, bootstrap.ErrUserNotFound) {
+		bootstrap.NewResult(http.StatusUnauthorized, err.Error(), nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		bootstrap.NewResult(http.StatusConflict, "email already verified", nil).Abort(ctx, http.StatusConflict)
+		return
+	}
+
+	err = h.send(ctx.Request.Context(), bootstrap.PurposeVerifyEmail, user)
+	if errors.Is(err, errTooSoon) {
+		bootstrap.NewResult(http.StatusTooManyRequests, err.Error(), nil).Abort(ctx, http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", nil).OK(ctx)
+}
+
+// VerifyEmail marks the email of the user as verified by the token of the mailed link
+func (h *EmailHandler) VerifyEmail(ctx *gin.Context) {
+	var req verifyEmailRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		bootstrap.NewResult(http.StatusBadRequest, err.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+
+	entry, ok := h.consume(ctx, bootstrap.PurposeVerifyEmail, req.Token)
+	if !ok {
+		return
+	}
+	if err := h.users.VerifyEmail(ctx.Request.Context(), entry.Username, entry.Email); err != nil {
+		h.tokenError(ctx, err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", nil).OK(ctx)
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+var errTooSoon = errors.New("mail sent recently, try again later")
+
+// send issues a token of the purpose and mails its link to the user, at most once per ResendAfter
+func (h *EmailHandler) send(ctx context.Context, purpose string, user *bootstrap.User) error {
+	email := h.cfg.Middlewares.Auth.Email
+	if email.ResendAfter > 0 {
+		key := bootstrap.OneTimeTokenPrefix + "sent_" + purpose + "_" + strings.ToLower(user.Email)
+		if err := h.auth.tokens.RDB.Add(key, true, email.ResendAfter); err == persistence.ErrNotStored {
+			return errTooSoon
+		} else if err != nil {
+			return err
+		}
+	}
+
+	ttl, link, subject, action := email.ResetTTL, email.ResetURL, "Reset your password", "reset your password"
+	if purpose == bootstrap.PurposeVerifyEmail {
+		ttl, link, subject, action = email.VerifyTTL, email.VerifyURL, "Verify your email", "verify your email"
+	}
+	token, err := h.tokens.Issue(purpose, user.Username, user.Email, ttl)
+	if err != nil {
+		return err
+	}
+
+	var body string
+	if link != "" {
+		body = fmt.Sprintf("Hi %s,\n\nOpen the link below in %s to %s:\n\n%s%s\n", user.Username, ttl, action, link, token)
+	} else {
+		body = fmt.Sprintf("Hi %s,\n\nUse the token below in %s to %s:\n\n%s\n", user.Username, ttl, action, token)
+	}
+	body += "\nIgnore this mail if you didn't ask for it.\n"
+	return h.mailer.Send(ctx, bootstrap.Mail{To: []string{user.Email}, Subject: subject, Body: body})
+}
+
+// consume uses the token of the purpose, it responds 400 if the token is invalid
+func (h *EmailHandler) consume(ctx *gin.Context, purpose, token string) (*bootstrap.OneTimeToken, bool) {
+	entry, err := h.tokens.Consume(purpose, token)
+	if err != nil {
+		h.tokenError(ctx, err)
+		return nil, false
+	}
+	return entry, true
+}
+
+// tokenError responds 400 if the token is invalid, or its user was deleted or changed the email since
+func (h *EmailHandler) tokenError(ctx *gin.Context, err error) {
+	if errors.Is(err, bootstrap.ErrInvalidOneTimeToken) || errors.Is(err, bootstrap.ErrUserNotFound) {
+		bootstrap.NewResult(http.StatusBadRequest, bootstrap.ErrInvalidOneTimeToken.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+	_ = ctx.Error(err)
+}
@@ -0,0 +1,147 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+// popMail waits for the only mail in the directory of the file mailer, and removes it
+func popMail(t *testing.T, dir string) (to string, subject string, body string) {
+	t.Helper()
+	var files []string
+	require.Eventually(t, func() bool {
+		files, _ = filepath.Glob(filepath.Join(dir, "*.eml"))
+		return len(files) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, files, 1)
+
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	msg, err := mail.ReadMessage(f)
+	require.NoError(t, err)
+	data, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, os.Remove(files[0]))
+	return msg.Header.Get("To"), msg.Header.Get("Subject"), string(data)
+}
+
+func TestEmailRoutes(t *testing.T) {
+	dir := t.TempDir()
+	var cfg types.AppConfig
+	router := newAuthRouter(t, func(c *types.AppConfig) {
+		c.Mailer.Type = "file"
+		c.Mailer.From = "Gin Starter <no-reply@example.com>"
+		c.Mailer.Dir = dir
+		c.Middlewares.Auth.Email.Enable = true
+		c.Middlewares.Auth.Email.TokenSecret = "mail"
+		c.Middlewares.Auth.Email.ResetURL = "https://example.com/reset?token="
+		cfg = *c
+	})
+	db, err := bootstrap.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, bootstrap.NewUserManager(db).CreateUser(context.Background(), "bob", "password", "bob@example.com"))
+
+	tokenOf := regexp.MustCompile(`token=(\S+)`)
+	login := func(username, password string) (int, string) {
+		code, res := call(t, router, http.MethodPost, "/auth/login", "", `{"username":"`+username+`","password":"`+password+`"}`)
+		var pair struct {
+			AccessToken string `json:"access_token"`
+		}
+		_ = json.Unmarshal(res.Data, &pair)
+		return code, pair.AccessToken
+	}
+	me := func(token string) (verified bool) {
+		code, res := call(t, router, http.MethodGet, "/auth/me", token, "")
+		require.Equal(t, http.StatusOK, code)
+		var user struct {
+			EmailVerified bool `json:"email_verified"`
+		}
+		require.NoError(t, json.Unmarshal(res.Data, &user))
+		return user.EmailVerified
+	}
+
+	// the response doesn't tell whether the email is registered
+	code, _ := call(t, router, http.MethodPost, "/auth/password/forgot", "", `{"email":"nobody@example.com"}`)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = call(t, router, http.MethodPost, "/auth/password/forgot", "", `{"email":"not an email"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	_, old := login("alice", "secret")
+	code, _ = call(t, router, http.MethodPost, "/auth/password/forgot", "", `{"email":"alice@example.com"}`)
+	assert.Equal(t, http.StatusOK, code)
+	to, subject, body := popMail(t, dir)
+	assert.Equal(t, "alice@example.com", to)
+	assert.Equal(t, "Reset your password", subject)
+	match := tokenOf.FindStringSubmatch(body)
+	require.Len(t, match, 2, body)
+	reset := match[1]
+
+	// resent at most once per resend_after
+	code, _ = call(t, router, http.MethodPost, "/auth/password/forgot", "", `{"email":"alice@example.com"}`)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = call(t, router, http.MethodPost, "/auth/password/reset", "", `{"token":"`+reset+`","password":"short"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = call(t, router, http.MethodPost, "/auth/password/reset", "", `{"token":"`+reset+`x","password":"new-secret"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = call(t, router, http.MethodPost, "/auth/email/verify", "", `{"token":"`+reset+`"}`)
+	assert.Equal(t, http.StatusBadRequest, code, "the token is bound to its purpose")
+
+	code, _ = call(t, router, http.MethodPost, "/auth/password/reset", "", `{"token":"`+reset+`","password":"new-secret"}`)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = call(t, router, http.MethodPost, "/auth/password/reset", "", `{"token":"`+reset+`","password":"other-secret"}`)
+	assert.Equal(t, http.StatusBadRequest, code, "the token is used once")
+
+	// the sessions are logged out, and the link verified the email
+	code, _ = call(t, router, http.MethodGet, "/auth/me", old, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = login("alice", "secret")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, token := login("alice", "new-secret")
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, me(token))
+	code, _ = call(t, router, http.MethodPost, "/auth/email/verification", token, "")
+	assert.Equal(t, http.StatusConflict, code)
+
+	// verified by the mailed token
+	code, _ = call(t, router, http.MethodPost, "/auth/email/verification", "", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	_, token = login("bob", "password")
+	assert.False(t, me(token))
+	code, _ = call(t, router, http.MethodPost, "/auth/email/verification", token, "")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = call(t, router, http.MethodPost, "/auth/email/verification", token, "")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	to, subject, body = popMail(t, dir)
+	assert.Equal(t, "bob@example.com", to)
+	assert.Equal(t, "Verify your email", subject)
+	match = regexp.MustCompile(`(?m)^(\S+\.\S+)\r?$`).FindStringSubmatch(body)
+	require.Len(t, match, 2, body)
+
+	code, _ = call(t, router, http.MethodPost, "/auth/email/verify", "", `{"token":"`+match[1]+`"}`)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = call(t, router, http.MethodPost, "/auth/email/verify", "", `{"token":"`+match[1]+`"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.True(t, me(token))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	assert.Empty(t, files, "the throttled mails are not sent")
+}
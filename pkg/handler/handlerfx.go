@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"github.com/gin-contrib/cache/persistence"
+	"go.uber.org/fx"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+// AuthModule registers the auth routes if middlewares.auth.routes is enabled, the handlers are only created then
+var AuthModule = fx.Module("auth_routes",
+	fx.Invoke(func(
+		cfg types.AppConfig,
+		lc fx.Lifecycle,
+		db *bootstrap.DBToolKit, // migrated before casbin loads its rules in the application
+		app *bootstrap.Application,
+		author *bootstrap.Authenticator,
+		store persistence.CacheStore,
+		rds *bootstrap.RedisPool,
+		sty *bootstrap.AppSentry,
+		mailer bootstrap.Mailer,
+		logger *bootstrap.AppLogger,
+	) error {
+		if !cfg.Middlewares.Auth.Routes.Enable {
+			return nil
+		}
+		h, err := NewAuthHandler(cfg, author, db, store, rds, sty, logger)
+		if err != nil {
+			return err
+		}
+		if keys := h.Keys(); keys != nil {
+			lc.Append(fx.StartStopHook(keys.Start, keys.Stop))
+		}
+		app.UseIdentity(h.Identify())
+		h.RegisterJWKS(app.Group("/"))
+		// the admin routes are only there if casbin authorizes them
+		if author := app.Authorizer(); author != nil {
+			admin := app.Group("/admin")
+			h.RegisterAdmin(admin)
+			NewPolicyHandler(h, bootstrap.NewPolicyManager(author, db), logger).RegisterAdmin(admin)
+		}
+
+		router := app.Group(cfg.Middlewares.Auth.Routes.Prefix)
+		if cfg.Middlewares.Auth.OIDC.Enable {
+			oh, err := NewOIDCHandler(cfg, h, db, logger)
+			if err != nil {
+				return err
+			}
+			if err = oh.Register(router); err != nil {
+				return err
+			}
+		}
+		if cfg.Middlewares.Auth.Email.Enable {
+			if err = NewEmailHandler(cfg, h, db, mailer, logger).Register(router); err != nil {
+				return err
+			}
+		}
+		return h.Register(router)
+	}),
+)
+
+// Module exports the route modules
+var Module = fx.Module("handler",
+	AuthModule,
+)
@@ -0,0 +1,336 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/cache/persistence"
+	"github.com/gin-gonic/gin"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/utility"
+)
+
+// MFAChallengePrefix keys the challenges of the logins waiting for the second factor in the token store
+const MFAChallengePrefix = "sys_mfa_"
+
+// mfaChallenge is stored for the challenge token returned by the first step of the login
+type mfaChallenge struct {
+	Username string
+	Device   string
+	Tenant   string // of the login, the tokens are bound to it
+	Enroll   bool   // the user must enroll, the code activates the enrollment
+	Attempts int
+	Expires  time.Time
+}
+
+type mfaChallengeResponse struct {
+	MFARequired    bool                      `json:"mfa_required"`
+	ChallengeToken string                    `json:"challenge_token"`
+	ExpiresIn      int                       `json:"expires_in"`           // in second
+	Enrollment     *bootstrap.TOTPEnrollment `json:"enrollment,omitempty"` // the user must enroll by it first
+}
+
+type mfaVerifyRequest struct {
+	ChallengeToken string `json:"challenge_token" form:"challenge_token" binding:"required"`
+	Code           string `json:"code" form:"code" binding:"required"` // TOTP or recovery code
+}
+
+type mfaCodeRequest struct {
+	Code string `json:"code" form:"code" binding:"required"`
+}
+
+type mfaTokenResponse struct {
+	tokenResponse
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // shown once, after the enrollment
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// registerMFA adds the second step of the login and the enrollment routes of the current user
+func (h *AuthHandler) registerMFA(router gin.IRouter) {
+	authed := h.authed
+	router.POST("/mfa/verify", h.VerifyMFA)
+	router.GET("/mfa", authed, h.MFAStatus)
+	router.POST("/mfa/totp", authed, h.EnrollMFA)
+	router.POST("/mfa/totp/activate", authed, h.ActivateMFA)
+	router.DELETE("/mfa/totp", authed, h.DisableMFA)
+	router.POST("/mfa/recovery-codes", authed, h.RegenerateRecoveryCodes)
+}
+
+// mfaChallenge starts the second step of the login if the user enabled MFA or any of its roles requires it, it
+// returns nil otherwise
+func (h *AuthHandler) mfaChallenge(ctx *gin.Context, username, device string) (*mfaChallengeResponse, error) {
+	status, err := h.mfa.Status(ctx.Request.Context(), username)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Enabled && !status.Required {
+		return nil, nil
+	}
+
+	ttl := h.cfg.Middlewares.Auth.MFA.ChallengeTTL
+	resp := &mfaChallengeResponse{MFARequired: true, ChallengeToken: randomString(), ExpiresIn: int(ttl / time.Second)}
+	if !status.Enabled {
+		if resp.Enrollment, err = h.mfa.Enroll(ctx.Request.Context(), username); err != nil {
+			return nil, err
+		}
+	}
+
+	challenge := mfaChallenge{
+		Username: username,
+		Device:   device,
+		Tenant:   utility.FromTenant(ctx.Request.Context()),
+		Enroll:   !status.Enabled,
+		Expires:  time.Now().Add(ttl),
+	}
+	if err = h.tokens.RDB.Set(MFAChallengePrefix+resp.ChallengeToken, challenge, ttl); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// VerifyMFA exchanges the challenge token and the code for a token pair, the challenge is dropped after too many
+// invalid codes. The invalid codes are counted by username too, and lock the user as the failed logins do.
+func (h *AuthHandler) VerifyMFA(ctx *gin.Context) {
+	var req mfaVerifyRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		bootstrap.NewResult(http.StatusBadRequest, err.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+
+	key := MFAChallengePrefix + req.ChallengeToken
+	var challenge mfaChallenge
+	err := h.tokens.RDB.Get(key, &challenge)
+	if err == persistence.ErrCacheMiss || err == nil && !time.Now().Before(challenge.Expires) {
+		bootstrap.NewResult(http.StatusUnauthorized, "invalid or expired MFA challenge", nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	if tenant := utility.FromTenant(ctx.Request.Context()); tenant != "" && tenant != challenge.Tenant {
+		// the challenge of another tenant
+		bootstrap.NewResult(http.StatusUnauthorized, "invalid or expired MFA challenge", nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	}
+	ctx.Request = ctx.Request.WithContext(utility.NewTenant(ctx.Request.Context(), challenge.Tenant))
+	if err = h.author.SecondFactorLocked(ctx.Request.Context(), challenge.Username); err != nil {
+		h.mfaLocked(ctx, key, err)
+		return
+	}
+
+	var codes []string
+	if challenge.Enroll {
+		codes, err = h.mfa.Activate(ctx.Request.Context(), challenge.Username, req.Code)
+	} else {
+		err = h.mfa.Verify(ctx.Request.Context(), challenge.Username, req.Code)
+	}
+	if errors.Is(err, bootstrap.ErrInvalidMFACode) {
+		// counted by username as well, so that new challenges do not allow more guesses
+		if err = h.author.FailSecondFactor(ctx.Request.Context(), challenge.Username, ctx.ClientIP()); err != nil {
+			h.mfaLocked(ctx, key, err)
+			return
+		}
+		challenge.Attempts++
+		if challenge.Attempts >= h.cfg.Middlewares.Auth.MFA.MaxAttempts {
+			_ = h.tokens.RDB.Delete(key)
+			h.logger.Warn("Too many invalid MFA codes of " + challenge.Username)
+			bootstrap.NewResult(http.StatusUnauthorized, "too many invalid MFA codes, login again", nil).Abort(ctx, http.StatusUnauthorized)
+			return
+		}
+		if err = h.tokens.RDB.Replace(key, challenge, time.Until(challenge.Expires)); err != nil && err != persistence.ErrNotStored {
+			_ = ctx.Error(err)
+			return
+		}
+		bootstrap.NewResult(http.StatusUnauthorized, bootstrap.ErrInvalidMFACode.Error(), nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, bootstrap.ErrMFANotEnrolled) || errors.Is(err, bootstrap.ErrMFAEnabled) || errors.Is(err, bootstrap.ErrUserNotFound) {
+		// changed since the first step
+		_ = h.tokens.RDB.Delete(key)
+		bootstrap.NewResult(http.StatusUnauthorized, err.Error()+", login again", nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	// the challenge is used once
+	if err = h.tokens.RDB.Delete(key); err == persistence.ErrCacheMiss {
+		bootstrap.NewResult(http.StatusUnauthorized, "invalid or expired MFA challenge", nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	h.author.SecondFactorSucceeded(ctx.Request.Context(), challenge.Username)
+
+	resp, err := h.issueTokens(ctx, challenge.Username, challenge.Device)
+	if err != nil {
+		h.tokenError(ctx, err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", mfaTokenResponse{tokenResponse: *resp, RecoveryCodes: codes}).OK(ctx)
+}
+
+// mfaLocked drops the challenge of the key, if any, of the user locked after too many failures, the locks are
+// reported as by Login
+func (h *AuthHandler) mfaLocked(ctx *gin.Context, key string, err error) {
+	var locked *bootstrap.LockedError
+	if !errors.As(err, &locked) {
+		_ = ctx.Error(err)
+		return
+	}
+
+	if key != "" {
+		_ = h.tokens.RDB.Delete(key)
+	}
+	ctx.Header("Retry-After", strconv.Itoa(int(locked.RetryAfter.Round(time.Second)/time.Second)))
+	bootstrap.NewResult(http.StatusTooManyRequests, err.Error(), nil).Abort(ctx, http.StatusTooManyRequests)
+}
+
+// MFAStatus returns the MFA status of the current user
+func (h *AuthHandler) MFAStatus(ctx *gin.Context) {
+	status, err := h.mfa.Status(ctx.Request.Context(), ctx.GetString("username"))
+	if err != nil {
+		h.mfaError(ctx, err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", status).OK(ctx)
+}
+
+// EnrollMFA starts the TOTP enrollment of the current user, it is enabled by the first code
+func (h *AuthHandler) EnrollMFA(ctx *gin.Context) {
+	enrollment, err := h.mfa.Enroll(ctx.Request.Context(), ctx.GetString("username"))
+	if err != nil {
+		h.mfaError(ctx, err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", enrollment).OK(ctx)
+}
+
+// ActivateMFA enables the TOTP enrollment of the current user by its first code, and returns the recovery codes
+func (h *AuthHandler) ActivateMFA(ctx *gin.Context) {
+	var req mfaCodeRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		bootstrap.NewResult(http.StatusBadRequest, err.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.mfa.Activate(ctx.Request.Context(), ctx.GetString("username"), req.Code)
+	if err != nil {
+		h.mfaError(ctx, err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", recoveryCodesResponse{RecoveryCodes: codes}).OK(ctx)
+}
+
+// DisableMFA turns MFA of the current user off by a valid code, unless any of its roles requires it
+func (h *AuthHandler) DisableMFA(ctx *gin.Context) {
+	var req mfaCodeRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		bootstrap.NewResult(http.StatusBadRequest, err.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+
+	username := ctx.GetString("username")
+	status, err := h.mfa.Status(ctx.Request.Context(), username)
+	if err != nil {
+		h.mfaError(ctx, err)
+		return
+	}
+	if status.Required {
+		bootstrap.NewResult(http.StatusForbidden, "MFA is required by the roles of the user", nil).Abort(ctx, http.StatusForbidden)
+		return
+	}
+	if !h.verifyCode(ctx, username, req.Code) {
+		return
+	}
+	if err = h.mfa.Disable(ctx.Request.Context(), username); err != nil {
+		h.mfaError(ctx, err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", nil).OK(ctx)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the current user by a valid code
+func (h *AuthHandler) RegenerateRecoveryCodes(ctx *gin.Context) {
+	var req mfaCodeRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		bootstrap.NewResult(http.StatusBadRequest, err.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+
+	username := ctx.GetString("username")
+	if !h.verifyCode(ctx, username, req.Code) {
+		return
+	}
+	codes, err := h.mfa.RegenerateRecoveryCodes(ctx.Request.Context(), username)
+	if err != nil {
+		h.mfaError(ctx, err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", recoveryCodesResponse{RecoveryCodes: codes}).OK(ctx)
+}
+
+// verifyCode verifies the code of the current user, the invalid codes are counted and lock the user as by VerifyMFA.
+// It responds unless the code is valid.
+func (h *AuthHandler) verifyCode(ctx *gin.Context, username, code string) bool {
+	if err := h.author.SecondFactorLocked(ctx.Request.Context(), username); err != nil {
+		h.mfaLocked(ctx, "", err)
+		return false
+	}
+
+	err := h.mfa.Verify(ctx.Request.Context(), username, code)
+	if errors.Is(err, bootstrap.ErrInvalidMFACode) {
+		if err := h.author.FailSecondFactor(ctx.Request.Context(), username, ctx.ClientIP()); err != nil {
+			h.mfaLocked(ctx, "", err)
+			return false
+		}
+	}
+	if err != nil {
+		h.mfaError(ctx, err)
+		return false
+	}
+	h.author.SecondFactorSucceeded(ctx.Request.Context(), username)
+	return true
+}
+
+// ResetUserMFA turns MFA of the user off, e.g. if its device is lost. The user enrolls again on the next login if
+// its roles require MFA. It is an admin route, only registered if casbin authorizes it.
+func (h *AuthHandler) ResetUserMFA(ctx *gin.Context) {
+	username := ctx.Param("username")
+	if err := h.mfa.Disable(ctx.Request.Context(), username); err != nil {
+		h.mfaError(ctx, err)
+		return
+	}
+	h.logger.Info(ctx.GetString("username") + " reset MFA of " + username)
+	bootstrap.NewResult(http.StatusOK, "ok", nil).OK(ctx)
+}
+
+// mfaError responds with the status of the MFA errors
+func (h *AuthHandler) mfaError(ctx *gin.Context, err error) {
+	var status int
+	switch {
+	case errors.Is(err, bootstrap.ErrInvalidMFACode):
+		status = http.StatusBadRequest
+	case errors.Is(err, bootstrap.ErrMFANotEnrolled), errors.Is(err, bootstrap.ErrUserNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, bootstrap.ErrMFAEnabled):
+		status = http.StatusConflict
+	default:
+		_ = ctx.Error(err)
+		return
+	}
+	bootstrap.NewResult(status, err.Error(), nil).Abort(ctx, status)
+}
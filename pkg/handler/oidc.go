@@ -0,0 +1,315 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+const (
+	// the state of the login in progress in the session
+	oidcSessionKey = "oidc_login"
+	// the login must be completed at the provider in time
+	oidcLoginTimeout = 10 * time.Minute
+	// discovery of the provider
+	oidcDiscoveryTimeout = 10 * time.Second
+)
+
+// OIDCHandler logs the users in by OpenID Connect providers with the authorization code flow and PKCE
+type OIDCHandler struct {
+	cfg       types.AppConfig
+	auth      *AuthHandler
+	users     *bootstrap.UserManager
+	providers map[string]*oidcProvider
+	logger    *bootstrap.AppLogger
+}
+
+// oidcProvider is discovered on the first login, so that the application starts without the provider
+type oidcProvider struct {
+	cfg types.AppOIDCProvider
+
+	mu       sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+type oidcLogin struct {
+	Provider string    `json:"provider"`
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Created  time.Time `json:"created"`
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func NewOIDCHandler(cfg types.AppConfig, auth *AuthHandler, db *bootstrap.DBToolKit, logger *bootstrap.AppLogger) (*OIDCHandler, error) {
+	providers := map[string]*oidcProvider{}
+	for _, provider := range cfg.Middlewares.Auth.OIDC.Providers {
+		if _, ok := providers[provider.Name]; ok {
+			return nil, fmt.Errorf("duplicate OIDC provider %s", provider.Name)
+		}
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+		}
+		if provider.UsernameClaim == "" {
+			provider.UsernameClaim = "preferred_username"
+		}
+		providers[provider.Name] = &oidcProvider{cfg: provider}
+	}
+
+	return &OIDCHandler{
+		cfg:       cfg,
+		auth:      auth,
+		users:     bootstrap.NewUserManager(db),
+		providers: providers,
+		logger:    logger,
+	}, nil
+}
+
+// Register adds the login and callback routes of the providers to the router of the auth routes
+func (h *OIDCHandler) Register(router gin.IRouter) error {
+	if !h.cfg.Middlewares.Session.Enable {
+		return errors.New("middlewares.session is required by middlewares.auth.oidc")
+	}
+
+	router.GET("/oidc/:provider/login", h.Login)
+	router.GET("/oidc/:provider/callback", h.Callback)
+	return nil
+}
+
+// Login redirects to the provider, the state, the nonce and the PKCE verifier are kept in the session
+func (h *OIDCHandler) Login(ctx *gin.Context) {
+	provider, config, ok := h.provider(ctx)
+	if !ok {
+		return
+	}
+
+	login := oidcLogin{
+		Provider: provider.cfg.Name,
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: oauth2.GenerateVerifier(),
+		Created:  time.Now(),
+	}
+	data, err := json.Marshal(login)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	session := sessions.Default(ctx)
+	session.Set(oidcSessionKey, string(data))
+	if err = session.Save(); err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	url := config.AuthCodeURL(login.State, oidc.Nonce(login.Nonce), oauth2.S256ChallengeOption(login.Verifier))
+	ctx.Redirect(http.StatusFound, url)
+}
+
+// Callback exchanges the authorization code for the ID token, and its user for a token pair
+func (h *OIDCHandler) Callback(ctx *gin.Context) {
+	provider, config, ok := h.provider(ctx)
+	if !ok {
+		return
+	}
+
+	// the state is used once
+	session := sessions.Default(ctx)
+	data, _ := session.Get(oidcSessionKey).(string)
+	session.Delete(oidcSessionKey)
+	if err := session.Save(); err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	var login oidcLogin
+	if data == "" || json.Unmarshal([]byte(data), &login) != nil || login.Provider != provider.cfg.Name ||
+		subtle.ConstantTimeCompare([]byte(login.State), []byte(ctx.Query("state"))) != 1 ||
+		time.Since(login.Created) > oidcLoginTimeout {
+		bootstrap.NewResult(http.StatusBadRequest, "invalid or expired login state", nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+	if reason := ctx.Query("error"); reason != "" {
+		msg := reason
+		if desc := ctx.Query("error_description"); desc != "" {
+			msg += ": " + desc
+		}
+		bootstrap.NewResult(http.StatusUnauthorized, msg, nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	}
+
+	token, err := config.Exchange(ctx.Request.Context(), ctx.Query("code"), oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		h.logger.Warn("Failed to exchange the authorization code of " + provider.cfg.Name + ": " + err.Error())
+		bootstrap.NewResult(http.StatusUnauthorized, "failed to exchange the authorization code", nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		bootstrap.NewResult(http.StatusUnauthorized, "missing ID token", nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	}
+	idToken, err := provider.verifier.Verify(ctx.Request.Context(), rawIDToken)
+	if err != nil {
+		bootstrap.NewResult(http.StatusUnauthorized, "invalid ID token: "+err.Error(), nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(login.Nonce)) != 1 {
+		bootstrap.NewResult(http.StatusUnauthorized, "invalid ID token nonce", nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	}
+
+	var claims map[string]interface{}
+	if err = idToken.Claims(&claims); err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	username, email := provider.identity(idToken.Subject, claims)
+	if username == "" {
+		bootstrap.NewResult(http.StatusUnauthorized, "missing "+provider.cfg.UsernameClaim+" claim", nil).Abort(ctx, http.StatusUnauthorized)
+		return
+	}
+
+	user, created, err := h.users.ProvisionUser(ctx.Request.Context(), provider.cfg.Name, idToken.Subject, username, email, provider.roles(claims))
+	if errors.Is(err, bootstrap.ErrUserExists) {
+		bootstrap.NewResult(http.StatusConflict, err.Error(), nil).Abort(ctx, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	if created {
+		h.logger.Info("User " + user.Username + " provisioned by " + provider.cfg.Name)
+	}
+
+	// the same second factor as the password logins
+	if h.auth.mfa != nil {
+		challenge, err := h.auth.mfaChallenge(ctx, user.Username, provider.cfg.Name)
+		if err != nil {
+			_ = ctx.Error(err)
+			return
+		}
+		if challenge != nil {
+			bootstrap.NewResult(http.StatusOK, "mfa required", challenge).OK(ctx)
+			return
+		}
+	}
+
+	resp, err := h.auth.issueTokens(ctx, user.Username, provider.cfg.Name)
+	if err != nil {
+		h.auth.tokenError(ctx, err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", resp).OK(ctx)
+}
+
+// provider returns the discovered provider of the route, it responds with the error if not found or not reachable
+func (h *OIDCHandler) provider(ctx *gin.Context) (*oidcProvider, *oauth2.Config, bool) {
+	provider, ok := h.providers[ctx.Param("provider")]
+	if !ok {
+		bootstrap.NewResult(http.StatusNotFound, "unknown OIDC provider", nil).Abort(ctx, http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	config, err := provider.discover()
+	if err != nil {
+		h.logger.Error("Failed to discover OIDC provider " + provider.cfg.Name + ": " + err.Error())
+		bootstrap.NewResult(http.StatusBadGateway, "OIDC provider unavailable", nil).Abort(ctx, http.StatusBadGateway)
+		return nil, nil, false
+	}
+	return provider, config, true
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// discover loads the endpoints and the keys of the provider, it is retried on the next login if failed
+func (p *oidcProvider) discover() (*oauth2.Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth2 != nil {
+		return p.oauth2, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oidcDiscoveryTimeout)
+	defer cancel()
+	provider, err := oidc.NewProvider(ctx, p.cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.cfg.ClientID})
+	p.oauth2 = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       p.cfg.Scopes,
+	}
+	return p.oauth2, nil
+}
+
+// identity returns the username and the email of the claims
+func (p *oidcProvider) identity(subject string, claims map[string]interface{}) (username string, email string) {
+	email, _ = claims["email"].(string)
+	username, _ = claims[p.cfg.UsernameClaim].(string)
+	if username == "" {
+		username = email
+	}
+	if email == "" {
+		// the email of the users is unique and required
+		email = subject + "@" + p.cfg.Name + ".invalid"
+	}
+	return username, email
+}
+
+// roles maps the roles claim to the local roles
+func (p *oidcProvider) roles(claims map[string]interface{}) bootstrap.ProvisionedRoles {
+	roles := bootstrap.ProvisionedRoles{Default: p.cfg.DefaultRoles}
+	if p.cfg.RolesClaim == "" {
+		return roles
+	}
+	for _, mapped := range p.cfg.RoleMapping {
+		roles.Managed = append(roles.Managed, mapped...)
+	}
+
+	var values []string
+	switch v := claims[p.cfg.RolesClaim].(type) {
+	case string:
+		values = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	for _, value := range values {
+		roles.Mapped = append(roles.Mapped, p.cfg.RoleMapping[value]...)
+	}
+	return roles
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
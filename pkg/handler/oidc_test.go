@@ -0,0 +1,278 @@
+package handler_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+// mockOIDCProvider is an OpenID Connect provider which logs in the user of its claims without asking
+type mockOIDCProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims jwt.MapClaims // of the next login
+	nonce  string        // replaces the nonce of the next login if not empty
+	codes  map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	challenge   string
+	redirectURI string
+	claims      jwt.MapClaims
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p := &mockOIDCProvider{key: key, codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "mock",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("response_type") != "code" || q.Get("client_id") != "app" || q.Get("code_challenge_method") != "S256" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		p.mu.Lock()
+		claims := jwt.MapClaims{}
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+		claims["nonce"] = q.Get("nonce")
+		if p.nonce != "" {
+			claims["nonce"], p.nonce = p.nonce, ""
+		}
+		code := randomCode()
+		p.codes[code] = mockAuthorization{challenge: q.Get("code_challenge"), redirectURI: q.Get("redirect_uri"), claims: claims}
+		p.mu.Unlock()
+
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		params := redirect.Query()
+		params.Set("code", code)
+		params.Set("state", q.Get("state"))
+		redirect.RawQuery = params.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		auth, ok := p.codes[r.FormValue("code")]
+		delete(p.codes, r.FormValue("code"))
+		p.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge || r.FormValue("redirect_uri") != auth.redirectURI {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		now := time.Now()
+		auth.claims["iss"] = p.URL
+		auth.claims["aud"] = "app"
+		auth.claims["iat"] = now.Unix()
+		auth.claims["exp"] = now.Add(time.Minute).Unix()
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, auth.claims)
+		token.Header["kid"] = "mock"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"access_token": "mock",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *mockOIDCProvider) login(claims jwt.MapClaims) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.claims = claims
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomCode() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func TestOIDCLogin(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	var cfg types.AppConfig
+	router := newAuthRouter(t, func(c *types.AppConfig) {
+		c.Middlewares.Auth.MFA.Enable = true
+		cfg = *c
+	}, func(cfg *types.AppConfig) {
+		cfg.Middlewares.Auth.OIDC.Enable = true
+		cfg.Middlewares.Auth.OIDC.Providers = []types.AppOIDCProvider{{
+			Name:         "mock",
+			Issuer:       provider.URL,
+			ClientID:     "app",
+			ClientSecret: "secret",
+			RedirectURL:  "http://localhost/auth/oidc/mock/callback",
+			RolesClaim:   "groups",
+			RoleMapping:  map[string][]string{"devs": {"developer"}},
+			DefaultRoles: []string{"user"},
+		}}
+	})
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	// login returns the callback URL and the session cookie
+	login := func() (*url.URL, string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/login", nil))
+		require.Equal(t, http.StatusFound, w.Code)
+
+		resp, err := noRedirect.Get(w.Header().Get("Location"))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+		callback, err := url.Parse(resp.Header.Get("Location"))
+		require.NoError(t, err)
+		return callback, w.Header().Get("Set-Cookie")
+	}
+	callback := func(callback *url.URL, cookie string) (int, result) {
+		req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+		req.Header.Set("Cookie", cookie)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var res result
+		_ = json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res
+	}
+
+	provider.login(jwt.MapClaims{"sub": "u-1", "preferred_username": "bob", "email": "bob@example.com", "groups": []string{"devs", "ops"}})
+	url, cookie := login()
+	code, res := callback(url, cookie)
+	require.Equal(t, http.StatusOK, code, res.Message)
+	var pair struct {
+		AccessToken string `json:"access_token"`
+	}
+	require.NoError(t, json.Unmarshal(res.Data, &pair))
+	code, res = call(t, router, http.MethodGet, "/auth/me", pair.AccessToken, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"id":2,"username":"bob","email":"bob@example.com","email_verified":false,"roles":["user","developer"]}`, string(res.Data))
+
+	// the authorization code is used once, the cookie store of the test still has the state
+	code, _ = callback(url, cookie)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// another state
+	url, cookie = login()
+	params := url.Query()
+	params.Set("state", "forged")
+	url.RawQuery = params.Encode()
+	code, _ = callback(url, cookie)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// the user is linked by the subject, even if renamed at the provider, and the mapped roles are synced
+	db, err := bootstrap.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, bootstrap.NewUserManager(db).RemoveRole(context.Background(), "bob", "user"))
+	provider.login(jwt.MapClaims{"sub": "u-1", "preferred_username": "bobby", "groups": []string{"ops"}})
+	url, cookie = login()
+	code, res = callback(url, cookie)
+	require.Equal(t, http.StatusOK, code, res.Message)
+	require.NoError(t, json.Unmarshal(res.Data, &pair))
+	code, res = call(t, router, http.MethodGet, "/auth/me", pair.AccessToken, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, string(res.Data), `"username":"bob"`)
+	roles, err := bootstrap.NewUserManager(db).UserRoles(context.Background(), "bob")
+	require.NoError(t, err)
+	assert.Empty(t, roles, "the default roles are added once, the roles no longer mapped are removed")
+
+	// an ID token of another login
+	provider.mu.Lock()
+	provider.nonce = "replayed"
+	provider.mu.Unlock()
+	url, cookie = login()
+	code, _ = callback(url, cookie)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// a local user is never taken over
+	provider.login(jwt.MapClaims{"sub": "u-2", "preferred_username": "alice"})
+	url, cookie = login()
+	code, _ = callback(url, cookie)
+	assert.Equal(t, http.StatusConflict, code)
+
+	// the second factor is required as by the password logins
+	require.NoError(t, bootstrap.NewMFAManager(db, "test").SetRoleRequired(context.Background(), "developer", true))
+	provider.login(jwt.MapClaims{"sub": "u-1", "preferred_username": "bob", "groups": "devs"})
+	url, cookie = login()
+	code, res = callback(url, cookie)
+	require.Equal(t, http.StatusOK, code, res.Message)
+	var challenge struct {
+		MFARequired bool   `json:"mfa_required"`
+		AccessToken string `json:"access_token"`
+	}
+	require.NoError(t, json.Unmarshal(res.Data, &challenge))
+	assert.True(t, challenge.MFARequired)
+	assert.Empty(t, challenge.AccessToken)
+
+	// provisioned users can't login by password
+	code, _ = call(t, router, http.MethodPost, "/auth/login", "", `{"username":"bob","password":"!"}`)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/oidc/unknown/login", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+)
+
+const (
+	// the latest changes returned by the audit trail by default, and at most
+	defaultPolicyAuditLimit = 100
+	maxPolicyAuditLimit     = 1000
+
+	csvContentType = "text/csv"
+)
+
+// PolicyHandler manages the casbin policy, its routes are authorized by casbin like other admin routes
+type PolicyHandler struct {
+	auth     *AuthHandler
+	policies *bootstrap.PolicyManager
+	logger   *bootstrap.AppLogger
+}
+
+type policyQuery struct {
+	PType   string `form:"ptype"`
+	Subject string `form:"sub"`
+	Object  string `form:"obj"`
+	Tenant  string `form:"dom"`
+}
+
+type policyRequest struct {
+	PType string   `json:"ptype" binding:"required"`
+	Rule  []string `json:"rule" binding:"required"`
+}
+
+type importQuery struct {
+	Replace bool `form:"replace"` // remove the rules missing in the import
+}
+
+type changedResponse struct {
+	Changed int `json:"changed"`
+}
+
+type importResponse struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func NewPolicyHandler(auth *AuthHandler, policies *bootstrap.PolicyManager, logger *bootstrap.AppLogger) *PolicyHandler {
+	return &PolicyHandler{auth: auth, policies: policies, logger: logger}
+}
+
+// RegisterAdmin adds the policy routes to the router of the admin routes
+func (h *PolicyHandler) RegisterAdmin(router gin.IRouter) {
+	authed := h.auth.authed
+	router.GET("/policies", authed, h.List)
+	router.POST("/policies", authed, h.Add)
+	router.DELETE("/policies", authed, h.Remove)
+	router.GET("/policies/export", authed, h.Export)
+	router.POST("/policies/import", authed, h.Import)
+	router.GET("/policies/audit", authed, h.Audit)
+}
+
+// List returns the rules, filtered by the type, the subject, the object and the domain
+func (h *PolicyHandler) List(ctx *gin.Context) {
+	var query policyQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		bootstrap.NewResult(http.StatusBadRequest, err.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+
+	rules := h.policies.Rules(bootstrap.PolicyFilter{PType: query.PType, Subject: query.Subject, Object: query.Object, Tenant: query.Tenant})
+	bootstrap.NewResult(http.StatusOK, "ok", rules).OK(ctx)
+}
+
+// Add adds a rule, a p rule or a role link
+func (h *PolicyHandler) Add(ctx *gin.Context) {
+	h.change(ctx, h.policies.Add)
+}
+
+// Remove removes a rule, a p rule or a role link
+func (h *PolicyHandler) Remove(ctx *gin.Context) {
+	h.change(ctx, h.policies.Remove)
+}
+
+// Export returns all rules as JSON, or as CSV in the format of the casbin policy files if format=csv
+func (h *PolicyHandler) Export(ctx *gin.Context) {
+	rules := h.policies.Rules(bootstrap.PolicyFilter{})
+	if ctx.Query("format") != "csv" {
+		bootstrap.NewResult(http.StatusOK, "ok", rules).OK(ctx)
+		return
+	}
+
+	ctx.Header("Content-Disposition", `attachment; filename="policy.csv"`)
+	ctx.Header("Content-Type", csvContentType+"; charset=utf-8")
+	ctx.Status(http.StatusOK)
+	if err := bootstrap.WritePolicyCSV(ctx.Writer, rules); err != nil {
+		_ = ctx.Error(err)
+	}
+}
+
+// Import adds the rules of the body, a JSON array or CSV if the content type is text/csv. All rules are validated
+// before any is changed.
+func (h *PolicyHandler) Import(ctx *gin.Context) {
+	var query importQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		bootstrap.NewResult(http.StatusBadRequest, err.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+
+	var (
+		rules []bootstrap.PolicyRule
+		err   error
+	)
+	if ctx.ContentType() == csvContentType {
+		rules, err = bootstrap.ParsePolicyCSV(ctx.Request.Body)
+	} else {
+		var reqs []policyRequest
+		if err = ctx.ShouldBindJSON(&reqs); err == nil {
+			for _, req := range reqs {
+				rules = append(rules, bootstrap.PolicyRule{PType: req.PType, Rule: req.Rule})
+			}
+		}
+	}
+	if err != nil {
+		bootstrap.NewResult(http.StatusBadRequest, err.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+
+	actor := ctx.GetString("username")
+	added, removed, err := h.policies.Import(ctx.Request.Context(), actor, rules, query.Replace)
+	if err != nil {
+		h.policyError(ctx, err)
+		return
+	}
+	h.logger.Info(fmt.Sprintf("%s imported the policy, added %d and removed %d rules", actor, added, removed))
+	bootstrap.NewResult(http.StatusOK, "ok", importResponse{Added: added, Removed: removed}).OK(ctx)
+}
+
+// Audit returns the latest changes of the policy, limit=100 by default
+func (h *PolicyHandler) Audit(ctx *gin.Context) {
+	limit := defaultPolicyAuditLimit
+	if s := ctx.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPolicyAuditLimit {
+			msg := fmt.Sprintf("limit must be between 1 and %d", maxPolicyAuditLimit)
+			bootstrap.NewResult(http.StatusBadRequest, msg, nil).Abort(ctx, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	entries, err := h.policies.Audit(ctx.Request.Context(), limit)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", entries).OK(ctx)
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// change adds or removes the rule of the request, it responds 200 with changed 0 if there was nothing to change
+func (h *PolicyHandler) change(ctx *gin.Context, apply func(ctx context.Context, actor string, rules ...bootstrap.PolicyRule) (int, error)) {
+	var req policyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		bootstrap.NewResult(http.StatusBadRequest, err.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+
+	rule := bootstrap.PolicyRule{PType: req.PType, Rule: req.Rule}
+	n, err := apply(ctx.Request.Context(), ctx.GetString("username"), rule)
+	if err != nil {
+		h.policyError(ctx, err)
+		return
+	}
+	bootstrap.NewResult(http.StatusOK, "ok", changedResponse{Changed: n}).OK(ctx)
+}
+
+// policyError responds 400 to the invalid rules
+func (h *PolicyHandler) policyError(ctx *gin.Context, err error) {
+	if errors.Is(err, bootstrap.ErrInvalidRule) {
+		bootstrap.NewResult(http.StatusBadRequest, err.Error(), nil).Abort(ctx, http.StatusBadRequest)
+		return
+	}
+	_ = ctx.Error(err)
+}
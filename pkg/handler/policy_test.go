@@ -0,0 +1,106 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+)
+
+func TestPolicyRoutes(t *testing.T) {
+	var cfg types.AppConfig
+	router := newAuthRouter(t, func(c *types.AppConfig) {
+		cfg = *c
+	})
+	db, err := bootstrap.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, bootstrap.NewUserManager(db).CreateUser(context.Background(), "bob", "password", "bob@example.com"))
+
+	login := func(username, password string) string {
+		code, res := call(t, router, http.MethodPost, "/auth/login", "", `{"username":"`+username+`","password":"`+password+`"}`)
+		require.Equal(t, http.StatusOK, code)
+		var pair struct {
+			AccessToken string `json:"access_token"`
+		}
+		require.NoError(t, json.Unmarshal(res.Data, &pair))
+		return pair.AccessToken
+	}
+	csv := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/csv")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	alice, bob := login("alice", "secret"), login("bob", "password")
+
+	// protected by casbin, alice is root as admin
+	code, _ := call(t, router, http.MethodGet, "/admin/policies", "", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = call(t, router, http.MethodGet, "/admin/policies", bob, "")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	rule := `{"ptype":"p","rule":["ops","/admin/policies","GET"]}`
+	code, res := call(t, router, http.MethodPost, "/admin/policies", alice, rule)
+	require.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"changed":1}`, string(res.Data))
+	code, res = call(t, router, http.MethodPost, "/admin/policies", alice, rule)
+	require.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"changed":0}`, string(res.Data))
+	code, _ = call(t, router, http.MethodPost, "/admin/policies", alice, `{"ptype":"p","rule":["ops","/admin/policies"]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = call(t, router, http.MethodPost, "/admin/policies", alice, `{"ptype":"g","rule":["bob","ops"]}`)
+	require.Equal(t, http.StatusOK, code)
+
+	// bob reads the policy by the role ops, but can't change it
+	code, res = call(t, router, http.MethodGet, "/admin/policies?sub=ops", bob, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `[{"ptype":"p","rule":["ops","/admin/policies","GET"]}]`, string(res.Data))
+	code, _ = call(t, router, http.MethodPost, "/admin/policies", bob, `{"ptype":"g","rule":["bob","admin"]}`)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	w := csv(http.MethodGet, "/admin/policies/export?format=csv", alice, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/csv")
+	assert.Equal(t, "p, ops, /admin/policies, GET\ng, bob, ops\n", w.Body.String())
+
+	// the import replaces the role link of bob
+	w = csv(http.MethodPost, "/admin/policies/import?replace=true", alice, "p, ops, /admin/policies, GET\np, ops, /admin/policies/export, GET\n")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"added":1,"removed":1`)
+	code, _ = call(t, router, http.MethodGet, "/admin/policies", bob, "")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// nothing is imported if any rule is invalid
+	code, _ = call(t, router, http.MethodPost, "/admin/policies/import", alice, `[{"ptype":"g","rule":["bob","ops"]},{"ptype":"p","rule":["x"]}]`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, res = call(t, router, http.MethodPost, "/admin/policies/import", alice, `[{"ptype":"g","rule":["bob","ops"]}]`)
+	require.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"added":1,"removed":0}`, string(res.Data))
+
+	code, res = call(t, router, http.MethodDelete, "/admin/policies", alice, `{"ptype":"p","rule":["ops","/admin/policies/export","GET"]}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"changed":1}`, string(res.Data))
+
+	code, _ = call(t, router, http.MethodGet, "/admin/policies/audit?limit=0", alice, "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, res = call(t, router, http.MethodGet, "/admin/policies/audit?limit=2", alice, "")
+	require.Equal(t, http.StatusOK, code)
+	var audit []bootstrap.PolicyAudit
+	require.NoError(t, json.Unmarshal(res.Data, &audit))
+	require.Len(t, audit, 2)
+	assert.Equal(t, "alice", audit[0].Actor)
+	assert.Equal(t, bootstrap.PolicyAuditRemove, audit[0].Action)
+	assert.Equal(t, "p, ops, /admin/policies/export, GET", audit[0].Rule.String())
+	assert.Equal(t, "g, bob, ops", audit[1].Rule.String())
+}
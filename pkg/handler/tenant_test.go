@@ -0,0 +1,104 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/bootstrap/types"
+	"github.com/robinmin/gin-starter/pkg/utility"
+)
+
+// callTenant is call of a request naming the tenant by the header
+func callTenant(t *testing.T, router *gin.Engine, method, path, tenant, token, body string) (int, result) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Tenant", tenant)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var res result
+	_ = json.Unmarshal(w.Body.Bytes(), &res)
+	return w.Code, res
+}
+
+func TestTenantRoutes(t *testing.T) {
+	var cfg types.AppConfig
+	router := newAuthRouter(t, func(c *types.AppConfig) {
+		c.Middlewares.Auth.ModelFile = "../../config/rbac_model_domains.conf"
+		c.Middlewares.Auth.Tenant.Enable = true
+		cfg = *c
+	})
+	db, err := bootstrap.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	um := bootstrap.NewUserManager(db)
+	require.NoError(t, um.CreateUser(ctx, "bob", "password", "bob@example.com"))
+	require.NoError(t, um.AddRole(utility.NewTenant(ctx, "acme"), "bob", "member"))
+	require.NoError(t, um.AddRole(utility.NewTenant(ctx, "globex"), "bob", "guest"))
+
+	login := func(tenant, body string) (int, string) {
+		code, res := callTenant(t, router, http.MethodPost, "/auth/login", tenant, "", body)
+		var pair struct {
+			AccessToken string `json:"access_token"`
+		}
+		_ = json.Unmarshal(res.Data, &pair)
+		return code, pair.AccessToken
+	}
+	bob := `{"username":"bob","password":"password"}`
+
+	// alice is root without tenant
+	code, root := login("", `{"username":"alice","password":"secret"}`)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callTenant(t, router, http.MethodPost, "/admin/policies", "", root, `{"ptype":"p","rule":["member","acme","/admin/policies","GET"]}`)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callTenant(t, router, http.MethodGet, "/admin/policies", "acme", root, "")
+	assert.Equal(t, http.StatusForbidden, code, "the token has no tenant")
+
+	code, _ = login("initech", bob)
+	assert.Equal(t, http.StatusForbidden, code, "not a member of the tenant")
+	code, _ = login("ac,me", bob)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = login("globex", `{"username":"bob","password":"password","tenant":"acme"}`)
+	assert.Equal(t, http.StatusBadRequest, code, "the tenant of the body is another")
+	code, token := login("", `{"username":"bob","password":"password","tenant":"acme"}`)
+	require.Equal(t, http.StatusOK, code)
+	code, other := login("globex", bob)
+	require.Equal(t, http.StatusOK, code)
+
+	// the roles of the tenant of the token
+	code, res := callTenant(t, router, http.MethodGet, "/auth/me", "", token, "")
+	require.Equal(t, http.StatusOK, code)
+	var me struct {
+		Roles []string `json:"roles"`
+	}
+	require.NoError(t, json.Unmarshal(res.Data, &me))
+	assert.Equal(t, []string{"member"}, me.Roles)
+
+	// authorized in the tenant, the token of another tenant is rejected
+	code, _ = callTenant(t, router, http.MethodGet, "/admin/policies", "acme", token, "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = callTenant(t, router, http.MethodGet, "/admin/policies", "", token, "")
+	assert.Equal(t, http.StatusOK, code, "the tenant of the token")
+	code, _ = callTenant(t, router, http.MethodGet, "/admin/policies", "globex", token, "")
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = callTenant(t, router, http.MethodGet, "/admin/policies", "globex", other, "")
+	assert.Equal(t, http.StatusForbidden, code, "denied to the role of the other tenant")
+	code, res = callTenant(t, router, http.MethodGet, "/admin/policies?dom=globex", "acme", token, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `[]`, string(res.Data), "filtered by the domain")
+}
@@ -7,17 +7,259 @@ package dbo
 
 import (
 	"context"
+	"time"
 )
 
+const addUserRole = `-- name: AddUserRole :exec
+INSERT INTO auth_user_roles (user_id, role_id, tenant) VALUES (?1, ?2, ?3)
+`
+
+func (q *Queries) AddUserRole(ctx context.Context, userID int64, roleID int64, tenant string) error {
+	_, err := q.db.ExecContext(ctx, addUserRole, userID, roleID, tenant)
+	return err
+}
+
+const countRecoveryCodes = `-- name: CountRecoveryCodes :one
+SELECT count(1) as n_count FROM auth_recovery_codes WHERE user_id = ?1 AND used_at IS NULL
+`
+
+func (q *Queries) CountRecoveryCodes(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countRecoveryCodes, userID)
+	var n_count int64
+	err := row.Scan(&n_count)
+	return n_count, err
+}
+
+const createAPIKey = `-- name: CreateAPIKey :exec
+INSERT INTO auth_api_keys (user_id, name, prefix, hash, scopes, rate_limit, expires_at)
+VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)
+`
+
+type CreateAPIKeyParams struct {
+	UserID    int64      `json:"user_id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Hash      string     `json:"hash"`
+	Scopes    string     `json:"scopes"`
+	RateLimit int64      `json:"rate_limit"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (q *Queries) CreateAPIKey(ctx context.Context, arg CreateAPIKeyParams) error {
+	_, err := q.db.ExecContext(ctx, createAPIKey,
+		arg.UserID,
+		arg.Name,
+		arg.Prefix,
+		arg.Hash,
+		arg.Scopes,
+		arg.RateLimit,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createIdentity = `-- name: CreateIdentity :exec
+INSERT INTO auth_identities (provider, subject, user_id) VALUES (?1, ?2, ?3)
+`
+
+func (q *Queries) CreateIdentity(ctx context.Context, provider string, subject string, userID int64) error {
+	_, err := q.db.ExecContext(ctx, createIdentity, provider, subject, userID)
+	return err
+}
+
+const createMFA = `-- name: CreateMFA :exec
+INSERT INTO auth_mfa (user_id, secret) VALUES (?1, ?2)
+`
+
+func (q *Queries) CreateMFA(ctx context.Context, userID int64, secret string) error {
+	_, err := q.db.ExecContext(ctx, createMFA, userID, secret)
+	return err
+}
+
+const createPolicyAudit = `-- name: CreatePolicyAudit :exec
+INSERT INTO auth_policy_audit (actor, action, ptype, rule) VALUES (?1, ?2, ?3, ?4)
+`
+
+func (q *Queries) CreatePolicyAudit(ctx context.Context, actor string, action string, ptype string, rule string) error {
+	_, err := q.db.ExecContext(ctx, createPolicyAudit, actor, action, ptype, rule)
+	return err
+}
+
+const createRecoveryCode = `-- name: CreateRecoveryCode :exec
+INSERT INTO auth_recovery_codes (user_id, hash) VALUES (?1, ?2)
+`
+
+func (q *Queries) CreateRecoveryCode(ctx context.Context, userID int64, hash string) error {
+	_, err := q.db.ExecContext(ctx, createRecoveryCode, userID, hash)
+	return err
+}
+
+const createRole = `-- name: CreateRole :exec
+INSERT INTO auth_roles (name, description) VALUES (?1, ?2)
+`
+
+func (q *Queries) CreateRole(ctx context.Context, name string, description *string) error {
+	_, err := q.db.ExecContext(ctx, createRole, name, description)
+	return err
+}
+
+const createUser = `-- name: CreateUser :exec
+INSERT INTO auth_users (username, password, email) VALUES (?1, ?2, ?3)
+`
+
+func (q *Queries) CreateUser(ctx context.Context, username string, password string, email string) error {
+	_, err := q.db.ExecContext(ctx, createUser, username, password, email)
+	return err
+}
+
+const deleteMFA = `-- name: DeleteMFA :execrows
+DELETE FROM auth_mfa WHERE user_id = ?1
+`
+
+func (q *Queries) DeleteMFA(ctx context.Context, userID int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteMFA, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteRecoveryCodes = `-- name: DeleteRecoveryCodes :exec
+DELETE FROM auth_recovery_codes WHERE user_id = ?1
+`
+
+func (q *Queries) DeleteRecoveryCodes(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteRecoveryCodes, userID)
+	return err
+}
+
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM auth_users WHERE username = ?1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, username string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUser, username)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUserIdentities = `-- name: DeleteUserIdentities :exec
+DELETE FROM auth_identities WHERE user_id = ?1
+`
+
+func (q *Queries) DeleteUserIdentities(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserIdentities, userID)
+	return err
+}
+
+const deleteUserRoles = `-- name: DeleteUserRoles :exec
+DELETE FROM auth_user_roles WHERE user_id = ?1
+`
+
+func (q *Queries) DeleteUserRoles(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserRoles, userID)
+	return err
+}
+
+const enableMFA = `-- name: EnableMFA :execrows
+UPDATE auth_mfa SET enabled_at = CURRENT_TIMESTAMP, last_step = ?1 WHERE user_id = ?2 AND enabled_at IS NULL
+`
+
+func (q *Queries) EnableMFA(ctx context.Context, lastStep int64, userID int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, enableMFA, lastStep, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getAPIKeyByPrefix = `-- name: GetAPIKeyByPrefix :one
+SELECT auth_api_keys.id, auth_api_keys.user_id, auth_api_keys.name, auth_api_keys.prefix, auth_api_keys.hash, auth_api_keys.scopes, auth_api_keys.rate_limit, auth_api_keys.expires_at, auth_api_keys.last_used_at, auth_api_keys.revoked_at, auth_api_keys.created_at, auth_users.username FROM auth_api_keys
+JOIN auth_users ON auth_users.id = auth_api_keys.user_id
+WHERE auth_api_keys.prefix = ?1 limit 1
+`
+
+type GetAPIKeyByPrefixRow struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"hash"`
+	Scopes     string     `json:"scopes"`
+	RateLimit  int64      `json:"rate_limit"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  *time.Time `json:"created_at"`
+	Username   string     `json:"username"`
+}
+
+func (q *Queries) GetAPIKeyByPrefix(ctx context.Context, prefix string) (GetAPIKeyByPrefixRow, error) {
+	row := q.db.QueryRowContext(ctx, getAPIKeyByPrefix, prefix)
+	var i GetAPIKeyByPrefixRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Prefix,
+		&i.Hash,
+		&i.Scopes,
+		&i.RateLimit,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+		&i.CreatedAt,
+		&i.Username,
+	)
+	return i, err
+}
+
+const getMFA = `-- name: GetMFA :one
+SELECT user_id, secret, last_step, enabled_at, created_at FROM auth_mfa WHERE user_id = ?1 limit 1
+`
+
+func (q *Queries) GetMFA(ctx context.Context, userID int64) (AuthMfa, error) {
+	row := q.db.QueryRowContext(ctx, getMFA, userID)
+	var i AuthMfa
+	err := row.Scan(
+		&i.UserID,
+		&i.Secret,
+		&i.LastStep,
+		&i.EnabledAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getRoleByName = `-- name: GetRoleByName :one
+SELECT id, name, description, created_at, updated_at, mfa_required FROM auth_roles WHERE name = ?1 limit 1
+`
+
+func (q *Queries) GetRoleByName(ctx context.Context, name string) (AuthRole, error) {
+	row := q.db.QueryRowContext(ctx, getRoleByName, name)
+	var i AuthRole
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.MfaRequired,
+	)
+	return i, err
+}
+
 const getRoleNamesByUsername = `-- name: GetRoleNamesByUsername :many
 SELECT auth_roles.name FROM auth_user_roles
 LEFT JOIN auth_users ON auth_users.id = auth_user_roles.user_id
 LEFT JOIN auth_roles ON auth_roles.id = auth_user_roles.role_id
-WHERE auth_users.username = ?1
+WHERE auth_users.username = ?1 AND auth_user_roles.tenant = ?2
 `
 
-func (q *Queries) GetRoleNamesByUsername(ctx context.Context, username string) ([]*string, error) {
-	rows, err := q.db.QueryContext(ctx, getRoleNamesByUsername, username)
+func (q *Queries) GetRoleNamesByUsername(ctx context.Context, username string, tenant string) ([]*string, error) {
+	rows, err := q.db.QueryContext(ctx, getRoleNamesByUsername, username, tenant)
 	if err != nil {
 		return nil, err
 	}
@@ -39,8 +281,67 @@ func (q *Queries) GetRoleNamesByUsername(ctx context.Context, username string) (
 	return items, nil
 }
 
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT id, username, password, email, created_at, updated_at, email_verified_at FROM auth_users WHERE email = ?1 limit 1
+`
+
+func (q *Queries) GetUserByEmail(ctx context.Context, email string) (AuthUser, error) {
+	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
+	var i AuthUser
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Password,
+		&i.Email,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.EmailVerifiedAt,
+	)
+	return i, err
+}
+
+const getUserByIdentity = `-- name: GetUserByIdentity :one
+SELECT auth_users.id, auth_users.username, auth_users.password, auth_users.email, auth_users.created_at, auth_users.updated_at, auth_users.email_verified_at FROM auth_identities
+JOIN auth_users ON auth_users.id = auth_identities.user_id
+WHERE auth_identities.provider = ?1 AND auth_identities.subject = ?2 limit 1
+`
+
+func (q *Queries) GetUserByIdentity(ctx context.Context, provider string, subject string) (AuthUser, error) {
+	row := q.db.QueryRowContext(ctx, getUserByIdentity, provider, subject)
+	var i AuthUser
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Password,
+		&i.Email,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.EmailVerifiedAt,
+	)
+	return i, err
+}
+
+const getUserByUsername = `-- name: GetUserByUsername :one
+SELECT id, username, password, email, created_at, updated_at, email_verified_at FROM auth_users WHERE username = ?1 limit 1
+`
+
+func (q *Queries) GetUserByUsername(ctx context.Context, username string) (AuthUser, error) {
+	row := q.db.QueryRowContext(ctx, getUserByUsername, username)
+	var i AuthUser
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Password,
+		&i.Email,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.EmailVerifiedAt,
+	)
+	return i, err
+}
+
 const getValidUserInfo = `-- name: GetValidUserInfo :one
-SELECT id, username, password, email, created_at, updated_at FROM auth_users WHERE username = ?1 AND password = ?2 limit 1
+SELECT id, username, password, email, created_at, updated_at, email_verified_at FROM auth_users WHERE username = ?1 AND password = ?2 limit 1
 `
 
 func (q *Queries) GetValidUserInfo(ctx context.Context, username string, password string) (AuthUser, error) {
@@ -53,10 +354,228 @@ func (q *Queries) GetValidUserInfo(ctx context.Context, username string, passwor
 		&i.Email,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.EmailVerifiedAt,
 	)
 	return i, err
 }
 
+const isMFARequired = `-- name: IsMFARequired :one
+SELECT count(1) as n_count FROM auth_user_roles
+JOIN auth_roles ON auth_roles.id = auth_user_roles.role_id
+WHERE auth_user_roles.user_id = ?1 AND auth_roles.mfa_required
+`
+
+func (q *Queries) IsMFARequired(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, isMFARequired, userID)
+	var n_count int64
+	err := row.Scan(&n_count)
+	return n_count, err
+}
+
+const listAPIKeys = `-- name: ListAPIKeys :many
+SELECT auth_api_keys.id, auth_api_keys.user_id, auth_api_keys.name, auth_api_keys.prefix, auth_api_keys.hash, auth_api_keys.scopes, auth_api_keys.rate_limit, auth_api_keys.expires_at, auth_api_keys.last_used_at, auth_api_keys.revoked_at, auth_api_keys.created_at, auth_users.username FROM auth_api_keys
+JOIN auth_users ON auth_users.id = auth_api_keys.user_id
+ORDER BY auth_api_keys.id
+`
+
+type ListAPIKeysRow struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"hash"`
+	Scopes     string     `json:"scopes"`
+	RateLimit  int64      `json:"rate_limit"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  *time.Time `json:"created_at"`
+	Username   string     `json:"username"`
+}
+
+func (q *Queries) ListAPIKeys(ctx context.Context) ([]ListAPIKeysRow, error) {
+	rows, err := q.db.QueryContext(ctx, listAPIKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListAPIKeysRow
+	for rows.Next() {
+		var i ListAPIKeysRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.Prefix,
+			&i.Hash,
+			&i.Scopes,
+			&i.RateLimit,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.RevokedAt,
+			&i.CreatedAt,
+			&i.Username,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPolicyAudit = `-- name: ListPolicyAudit :many
+SELECT id, actor, action, ptype, rule, created_at FROM auth_policy_audit ORDER BY id DESC limit ?1
+`
+
+func (q *Queries) ListPolicyAudit(ctx context.Context, limit int64) ([]AuthPolicyAudit, error) {
+	rows, err := q.db.QueryContext(ctx, listPolicyAudit, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuthPolicyAudit
+	for rows.Next() {
+		var i AuthPolicyAudit
+		if err := rows.Scan(
+			&i.ID,
+			&i.Actor,
+			&i.Action,
+			&i.Ptype,
+			&i.Rule,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsers = `-- name: ListUsers :many
+SELECT id, username, password, email, created_at, updated_at, email_verified_at FROM auth_users ORDER BY id
+`
+
+func (q *Queries) ListUsers(ctx context.Context) ([]AuthUser, error) {
+	rows, err := q.db.QueryContext(ctx, listUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuthUser
+	for rows.Next() {
+		var i AuthUser
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.Password,
+			&i.Email,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.EmailVerifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const removeUserRole = `-- name: RemoveUserRole :execrows
+DELETE FROM auth_user_roles WHERE user_id = ?1 AND role_id = ?2 AND tenant = ?3
+`
+
+func (q *Queries) RemoveUserRole(ctx context.Context, userID int64, roleID int64, tenant string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, removeUserRole, userID, roleID, tenant)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeAPIKey = `-- name: RevokeAPIKey :execrows
+UPDATE auth_api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE prefix = ?1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAPIKey(ctx context.Context, prefix string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeAPIKey, prefix)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setRoleMFARequired = `-- name: SetRoleMFARequired :exec
+UPDATE auth_roles SET mfa_required = ?1, updated_at = CURRENT_TIMESTAMP WHERE id = ?2
+`
+
+func (q *Queries) SetRoleMFARequired(ctx context.Context, mfaRequired bool, iD int64) error {
+	_, err := q.db.ExecContext(ctx, setRoleMFARequired, mfaRequired, iD)
+	return err
+}
+
+const touchAPIKey = `-- name: TouchAPIKey :exec
+UPDATE auth_api_keys SET last_used_at = ?1 WHERE id = ?2
+`
+
+func (q *Queries) TouchAPIKey(ctx context.Context, lastUsedAt *time.Time, iD int64) error {
+	_, err := q.db.ExecContext(ctx, touchAPIKey, lastUsedAt, iD)
+	return err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :execrows
+UPDATE auth_users SET password = ?1, updated_at = CURRENT_TIMESTAMP WHERE username = ?2
+`
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, password string, username string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateUserPassword, password, username)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const useMFAStep = `-- name: UseMFAStep :execrows
+UPDATE auth_mfa SET last_step = ?1 WHERE user_id = ?2 AND last_step < ?1
+`
+
+func (q *Queries) UseMFAStep(ctx context.Context, lastStep int64, userID int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useMFAStep, lastStep, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const useRecoveryCode = `-- name: UseRecoveryCode :execrows
+UPDATE auth_recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = ?1 AND hash = ?2 AND used_at IS NULL
+`
+
+func (q *Queries) UseRecoveryCode(ctx context.Context, userID int64, hash string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useRecoveryCode, userID, hash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const verifyUserCredentials = `-- name: VerifyUserCredentials :one
 SELECT count(1) as n_count FROM auth_users WHERE username = ?1 AND password = ?2 limit 1
 `
@@ -67,3 +586,16 @@ func (q *Queries) VerifyUserCredentials(ctx context.Context, username string, pa
 	err := row.Scan(&n_count)
 	return n_count, err
 }
+
+const verifyUserEmail = `-- name: VerifyUserEmail :execrows
+UPDATE auth_users SET email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+WHERE username = ?1 AND email = ?2 AND email_verified_at IS NULL
+`
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, username string, email string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, verifyUserEmail, username, email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,124 @@
+package dbo
+
+// This file is maintained by hand. The queries are generated by sqlc for sqlite, which numbers its bind variables
+// like ?1. The wrapper below translates them into the dialect of the database on first use.
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// NewWithDialect creates the queries for the database driver, e.g. sqlite, mysql or pgx
+func NewWithDialect(db DBTX, driverName string) *Queries {
+	bindType := sqlx.BindType(driverName)
+	if driverName == "sqlite" || driverName == "sqlite3" || bindType == sqlx.UNKNOWN {
+		return New(db)
+	}
+	return New(&dialectDB{db: db, bindType: bindType})
+}
+
+// dialectDB rewrites the numbered bind variables of each query before handing it to the database
+type dialectDB struct {
+	db       DBTX
+	bindType int
+}
+
+type translatedQuery struct {
+	query string
+	// indexes of the arguments in the order of the bind variables, for dialects without numbered variables
+	order []int
+}
+
+var translatedQueries sync.Map
+
+func (d *dialectDB) translate(query string, args []interface{}) (string, []interface{}) {
+	key := strconv.Itoa(d.bindType) + query
+	cached, ok := translatedQueries.Load(key)
+	if !ok {
+		cached, _ = translatedQueries.LoadOrStore(key, translateQuery(query, d.bindType))
+	}
+
+	tq := cached.(translatedQuery)
+	if tq.order == nil {
+		return tq.query, args
+	}
+	ordered := make([]interface{}, 0, len(tq.order))
+	for _, idx := range tq.order {
+		if idx < len(args) {
+			ordered = append(ordered, args[idx])
+		}
+	}
+	return tq.query, ordered
+}
+
+// translateQuery rewrites ?N outside of quoted strings into $N, or into plain ? with the arguments reordered
+func translateQuery(query string, bindType int) translatedQuery {
+	var (
+		b     strings.Builder
+		order []int
+		quote byte
+	)
+	if bindType != sqlx.DOLLAR {
+		order = []int{}
+	}
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			j := i + 1
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			if j == i+1 {
+				break
+			}
+
+			n, _ := strconv.Atoi(query[i+1 : j])
+			switch bindType {
+			case sqlx.DOLLAR:
+				b.WriteString("$" + query[i+1:j])
+			default:
+				b.WriteByte('?')
+				order = append(order, n-1)
+			}
+			i = j - 1
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return translatedQuery{query: b.String(), order: order}
+}
+
+func (d *dialectDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	query, args = d.translate(query, args)
+	return d.db.ExecContext(ctx, query, args...)
+}
+
+func (d *dialectDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	// prepared statements of dialects without numbered variables would need the arguments reordered, which is left
+	// to the caller; the generated queries never prepare statements
+	query, _ = d.translate(query, nil)
+	return d.db.PrepareContext(ctx, query)
+}
+
+func (d *dialectDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	query, args = d.translate(query, args)
+	return d.db.QueryContext(ctx, query, args...)
+}
+
+func (d *dialectDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	query, args = d.translate(query, args)
+	return d.db.QueryRowContext(ctx, query, args...)
+}
@@ -0,0 +1,55 @@
+package dbo_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/gin-starter/pkg/internal/dbo"
+)
+
+// recorder keeps the last query instead of running it
+type recorder struct {
+	query string
+	args  []interface{}
+}
+
+func (r *recorder) ExecContext(_ context.Context, query string, args ...interface{}) (sql.Result, error) {
+	r.query, r.args = query, args
+	return nil, sql.ErrConnDone
+}
+
+func (r *recorder) PrepareContext(_ context.Context, query string) (*sql.Stmt, error) {
+	r.query = query
+	return nil, sql.ErrConnDone
+}
+
+func (r *recorder) QueryContext(_ context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	r.query, r.args = query, args
+	return nil, sql.ErrConnDone
+}
+
+func (r *recorder) QueryRowContext(_ context.Context, query string, args ...interface{}) *sql.Row {
+	r.query, r.args = query, args
+	return nil
+}
+
+func TestNewWithDialect(t *testing.T) {
+	ctx := context.Background()
+
+	rec := &recorder{}
+	_, _ = dbo.NewWithDialect(rec, "sqlite").UpdateUserPassword(ctx, "hash", "alice")
+	assert.Contains(t, rec.query, "SET password = ?1, updated_at = CURRENT_TIMESTAMP WHERE username = ?2")
+
+	rec = &recorder{}
+	_, _ = dbo.NewWithDialect(rec, "pgx").UpdateUserPassword(ctx, "hash", "alice")
+	assert.Contains(t, rec.query, "SET password = $1, updated_at = CURRENT_TIMESTAMP WHERE username = $2")
+	assert.Equal(t, []interface{}{"hash", "alice"}, rec.args)
+
+	rec = &recorder{}
+	_, _ = dbo.NewWithDialect(rec, "mysql").RemoveUserRole(ctx, 1, 2, "acme")
+	assert.Contains(t, rec.query, "WHERE user_id = ? AND role_id = ? AND tenant = ?")
+	assert.Equal(t, []interface{}{int64(1), int64(2), "acme"}, rec.args)
+}
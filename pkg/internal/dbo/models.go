@@ -8,12 +8,60 @@ import (
 	"time"
 )
 
+type AuthApiKey struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"hash"`
+	Scopes     string     `json:"scopes"`
+	RateLimit  int64      `json:"rate_limit"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  *time.Time `json:"created_at"`
+}
+
+type AuthIdentity struct {
+	ID        int64      `json:"id"`
+	Provider  string     `json:"provider"`
+	Subject   string     `json:"subject"`
+	UserID    int64      `json:"user_id"`
+	CreatedAt *time.Time `json:"created_at"`
+}
+
+type AuthMfa struct {
+	UserID    int64      `json:"user_id"`
+	Secret    string     `json:"secret"`
+	LastStep  int64      `json:"last_step"`
+	EnabledAt *time.Time `json:"enabled_at"`
+	CreatedAt *time.Time `json:"created_at"`
+}
+
+type AuthPolicyAudit struct {
+	ID        int64      `json:"id"`
+	Actor     string     `json:"actor"`
+	Action    string     `json:"action"`
+	Ptype     string     `json:"ptype"`
+	Rule      string     `json:"rule"`
+	CreatedAt *time.Time `json:"created_at"`
+}
+
+type AuthRecoveryCode struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	Hash      string     `json:"hash"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt *time.Time `json:"created_at"`
+}
+
 type AuthRole struct {
 	ID          int64      `json:"id"`
 	Name        string     `json:"name"`
 	Description *string    `json:"description"`
 	CreatedAt   *time.Time `json:"created_at"`
 	UpdatedAt   *time.Time `json:"updated_at"`
+	MfaRequired bool       `json:"mfa_required"`
 }
 
 type AuthRule struct {
@@ -28,15 +76,17 @@ type AuthRule struct {
 }
 
 type AuthUser struct {
-	ID        int64      `json:"id"`
-	Username  string     `json:"username"`
-	Password  string     `json:"password"`
-	Email     string     `json:"email"`
-	CreatedAt *time.Time `json:"created_at"`
-	UpdatedAt *time.Time `json:"updated_at"`
+	ID              int64      `json:"id"`
+	Username        string     `json:"username"`
+	Password        string     `json:"password"`
+	Email           string     `json:"email"`
+	CreatedAt       *time.Time `json:"created_at"`
+	UpdatedAt       *time.Time `json:"updated_at"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
 }
 
 type AuthUserRole struct {
-	UserID int64 `json:"user_id"`
-	RoleID int64 `json:"role_id"`
+	UserID int64  `json:"user_id"`
+	RoleID int64  `json:"role_id"`
+	Tenant string `json:"tenant"`
 }
@@ -6,12 +6,46 @@ package dbo
 
 import (
 	"context"
+	"time"
 )
 
 type Querier interface {
-	GetRoleNamesByUsername(ctx context.Context, username string) ([]*string, error)
+	AddUserRole(ctx context.Context, userID int64, roleID int64, tenant string) error
+	CountRecoveryCodes(ctx context.Context, userID int64) (int64, error)
+	CreateAPIKey(ctx context.Context, arg CreateAPIKeyParams) error
+	CreateIdentity(ctx context.Context, provider string, subject string, userID int64) error
+	CreateMFA(ctx context.Context, userID int64, secret string) error
+	CreatePolicyAudit(ctx context.Context, actor string, action string, ptype string, rule string) error
+	CreateRecoveryCode(ctx context.Context, userID int64, hash string) error
+	CreateRole(ctx context.Context, name string, description *string) error
+	CreateUser(ctx context.Context, username string, password string, email string) error
+	DeleteMFA(ctx context.Context, userID int64) (int64, error)
+	DeleteRecoveryCodes(ctx context.Context, userID int64) error
+	DeleteUser(ctx context.Context, username string) (int64, error)
+	DeleteUserIdentities(ctx context.Context, userID int64) error
+	DeleteUserRoles(ctx context.Context, userID int64) error
+	EnableMFA(ctx context.Context, lastStep int64, userID int64) (int64, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (GetAPIKeyByPrefixRow, error)
+	GetMFA(ctx context.Context, userID int64) (AuthMfa, error)
+	GetRoleByName(ctx context.Context, name string) (AuthRole, error)
+	GetRoleNamesByUsername(ctx context.Context, username string, tenant string) ([]*string, error)
+	GetUserByEmail(ctx context.Context, email string) (AuthUser, error)
+	GetUserByIdentity(ctx context.Context, provider string, subject string) (AuthUser, error)
+	GetUserByUsername(ctx context.Context, username string) (AuthUser, error)
 	GetValidUserInfo(ctx context.Context, username string, password string) (AuthUser, error)
+	IsMFARequired(ctx context.Context, userID int64) (int64, error)
+	ListAPIKeys(ctx context.Context) ([]ListAPIKeysRow, error)
+	ListPolicyAudit(ctx context.Context, limit int64) ([]AuthPolicyAudit, error)
+	ListUsers(ctx context.Context) ([]AuthUser, error)
+	RemoveUserRole(ctx context.Context, userID int64, roleID int64, tenant string) (int64, error)
+	RevokeAPIKey(ctx context.Context, prefix string) (int64, error)
+	SetRoleMFARequired(ctx context.Context, mfaRequired bool, iD int64) error
+	TouchAPIKey(ctx context.Context, lastUsedAt *time.Time, iD int64) error
+	UpdateUserPassword(ctx context.Context, password string, username string) (int64, error)
+	UseMFAStep(ctx context.Context, lastStep int64, userID int64) (int64, error)
+	UseRecoveryCode(ctx context.Context, userID int64, hash string) (int64, error)
 	VerifyUserCredentials(ctx context.Context, username string, password string) (int64, error)
+	VerifyUserEmail(ctx context.Context, username string, email string) (int64, error)
 }
 
 var _ Querier = (*Queries)(nil)
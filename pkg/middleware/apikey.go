@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/utility"
+)
+
+// DefaultAPIKeyHeader carries the API keys unless they are sent as Bearer tokens
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// the name of the handlers created by RequireScopes, as listed by gin.Context.HandlerNames
+var requireScopesName = runtime.FuncForPC(reflect.ValueOf(RequireScopes()).Pointer()).Name()
+
+// RequireScopes declares the scopes the API keys need for the route, none lets any key through. The API keys are
+// denied on the routes declaring no scopes at all, the access tokens of the users are not limited by the scopes.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.GetString("api_key") == "" {
+			ctx.Next()
+			return
+		}
+
+		claims := ctx.MustGet("claims").(*Claims)
+		for _, scope := range scopes {
+			if !claims.HasScope(scope) {
+				bootstrap.NewResult(http.StatusForbidden, "API key缺少权限范围 "+scope, nil).Abort(ctx, http.StatusForbidden)
+				return
+			}
+		}
+		ctx.Next()
+	}
+}
+
+// declaresScopes reports whether the route of the request declares its scopes by RequireScopes
+func declaresScopes(ctx *gin.Context) bool {
+	for _, name := range ctx.HandlerNames() {
+		if name == requireScopesName {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKey returns the API key of the request, from the header or in place of the token
+func apiKey(ctx *gin.Context, header string, token string) string {
+	if header == "" {
+		header = DefaultAPIKeyHeader
+	}
+	if key := ctx.GetHeader(header); key != "" {
+		return key
+	}
+	if strings.HasPrefix(token, bootstrap.APIKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+// authenticateAPIKey puts the user of the key into the context like an access token, with the scopes of the key. The
+// keys are only accepted by the routes declaring their scopes, see RequireScopes.
+func authenticateAPIKey(ctx *gin.Context, config JWTAuthConfig, realm string, key string) {
+	apiKey, err := config.APIKeys.Authenticate(ctx.Request.Context(), key)
+	if err == bootstrap.ErrInvalidAPIKey {
+		unauthorized(ctx, realm, "无效的API key", err.Error())
+		return
+	}
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+
+	if !declaresScopes(ctx) {
+		bootstrap.NewResult(http.StatusForbidden, "路由不接受API key", nil).Abort(ctx, http.StatusForbidden)
+		return
+	}
+	if ok, wait := config.APIKeys.Allow(apiKey); !ok {
+		ctx.Header("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		bootstrap.NewResult(http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests), nil).Abort(ctx, http.StatusTooManyRequests)
+		return
+	}
+
+	// the roles of the key are those of the tenant of the request
+	claims := &Claims{Username: apiKey.Username, Roles: apiKey.Roles, Scopes: apiKey.Scopes}
+	if config.Tenants {
+		claims.Tenant = utility.FromTenant(ctx.Request.Context())
+	}
+	ctx.Set("username", claims.Username)
+	ctx.Set("claims", claims)
+	ctx.Set("api_key", apiKey.Prefix)
+	ctx.Request = ctx.Request.WithContext(NewIdentity(ctx.Request.Context(), claims, key, config.RootRole))
+
+	ctx.Next()
+}
@@ -0,0 +1,44 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/gin-starter/pkg/middleware"
+)
+
+func TestRequireScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	testCases := []struct {
+		apiKey    string
+		scopes    []string
+		required  []string
+		expected  int
+		errorInfo string
+	}{
+		{"ab12", []string{"orders:read", "orders:write"}, []string{"orders:read"}, http.StatusOK, "granted"},
+		{"ab12", []string{"orders:read"}, []string{"orders:read", "orders:write"}, http.StatusForbidden, "all are required"},
+		{"ab12", nil, []string{"orders:read"}, http.StatusForbidden, "key without scopes"},
+		{"ab12", nil, nil, http.StatusOK, "any key"},
+		{"", nil, []string{"orders:read"}, http.StatusOK, "access tokens are not limited"},
+	}
+	for _, tc := range testCases {
+		router := gin.New()
+		router.Use(func(ctx *gin.Context) {
+			ctx.Set("api_key", tc.apiKey)
+			ctx.Set("claims", &middleware.Claims{Username: "alice", Scopes: tc.scopes})
+		})
+		router.GET("/orders", middleware.RequireScopes(tc.required...), func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders", nil))
+		assert.Equal(t, tc.expected, w.Code, tc.errorInfo)
+	}
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TokenExtractor returns the token of the request, or an empty string if not found
+type TokenExtractor func(ctx *gin.Context) string
+
+// FromHeader takes the token from the header, with the authentication scheme if not empty, e.g. "Bearer"
+func FromHeader(name string, scheme string) TokenExtractor {
+	return func(ctx *gin.Context) string {
+		value := strings.TrimSpace(ctx.GetHeader(name))
+		if scheme == "" {
+			return value
+		}
+
+		// the scheme is case-insensitive, RFC 7235
+		if len(value) <= len(scheme) || !strings.EqualFold(value[:len(scheme)], scheme) || value[len(scheme)] != ' ' {
+			return ""
+		}
+		return strings.TrimSpace(value[len(scheme)+1:])
+	}
+}
+
+// FromBearer takes the token from the Authorization header of the Bearer scheme, RFC 6750
+func FromBearer() TokenExtractor {
+	return FromHeader("Authorization", "Bearer")
+}
+
+// FromCookie takes the token from the cookie
+func FromCookie(name string) TokenExtractor {
+	return func(ctx *gin.Context) string {
+		value, err := ctx.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return value
+	}
+}
+
+// FromQuery takes the token from the query parameter, e.g. for WebSocket handshakes, which can't set headers
+func FromQuery(name string) TokenExtractor {
+	return func(ctx *gin.Context) string {
+		return ctx.Query(name)
+	}
+}
+
+// FromForm takes the token from the form field of a POST request
+func FromForm(name string) TokenExtractor {
+	return func(ctx *gin.Context) string {
+		if ctx.Request.Method != http.MethodPost {
+			return ""
+		}
+		return ctx.PostForm(name)
+	}
+}
+
+// ParseTokenLookup creates the extractors from the lookup, e.g. "header:Authorization,cookie:access_token,query:token".
+// The Authorization header takes the Bearer scheme, other headers the raw value unless a scheme follows, e.g.
+// "header:X-Auth:Token".
+func ParseTokenLookup(lookup string) ([]TokenExtractor, error) {
+	var extractors []TokenExtractor
+	for _, part := range strings.Split(lookup, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		fields := strings.Split(part, ":")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		if len(fields) < 2 || fields[1] == "" || len(fields) > 2 && fields[0] != "header" || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid token lookup %q", part)
+		}
+
+		switch name := fields[1]; fields[0] {
+		case "header":
+			scheme := ""
+			if len(fields) == 3 {
+				scheme = fields[2]
+			} else if strings.EqualFold(name, "Authorization") {
+				scheme = "Bearer"
+			}
+			extractors = append(extractors, FromHeader(name, scheme))
+		case "cookie":
+			extractors = append(extractors, FromCookie(name))
+		case "query":
+			extractors = append(extractors, FromQuery(name))
+		case "form":
+			extractors = append(extractors, FromForm(name))
+		default:
+			return nil, fmt.Errorf("unknown token source %q", fields[0])
+		}
+	}
+	return extractors, nil
+}
@@ -0,0 +1,108 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/middleware"
+)
+
+func TestTokenExtractors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	extract := func(extractor middleware.TokenExtractor, prepare func(req *http.Request)) string {
+		req := httptest.NewRequest(http.MethodPost, "/ws?token=q", strings.NewReader("token=f"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		prepare(req)
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = req
+		return extractor(ctx)
+	}
+	header := func(name, value string) func(req *http.Request) {
+		return func(req *http.Request) { req.Header.Set(name, value) }
+	}
+
+	testCases := []struct {
+		extractor middleware.TokenExtractor
+		prepare   func(req *http.Request)
+		expected  string
+		errorInfo string
+	}{
+		{middleware.FromBearer(), header("Authorization", "Bearer abc"), "abc", "Bearer scheme"},
+		{middleware.FromBearer(), header("Authorization", "bearer  abc "), "abc", "the scheme is case-insensitive"},
+		{middleware.FromBearer(), header("Authorization", "abc"), "", "the scheme is required"},
+		{middleware.FromBearer(), header("Authorization", "Basic YTpi"), "", "another scheme"},
+		{middleware.FromBearer(), header("Authorization", "Bearer"), "", "the token is missing"},
+		{middleware.FromHeader("X-Access-Token", ""), header("X-Access-Token", "abc"), "abc", "custom header"},
+		{middleware.FromCookie("access_token"), func(req *http.Request) {
+			req.AddCookie(&http.Cookie{Name: "access_token", Value: "c"})
+		}, "c", "cookie"},
+		{middleware.FromCookie("access_token"), func(req *http.Request) {}, "", "no cookie"},
+		{middleware.FromQuery("token"), func(req *http.Request) {}, "q", "query"},
+		{middleware.FromForm("token"), func(req *http.Request) {}, "f", "form"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, extract(tc.extractor, tc.prepare), tc.errorInfo)
+	}
+}
+
+func TestParseTokenLookup(t *testing.T) {
+	extractors, err := middleware.ParseTokenLookup("header:Authorization, header:X-Auth:Token, cookie:jwt, query:token, form:token")
+	require.NoError(t, err)
+	assert.Len(t, extractors, 5)
+
+	for _, lookup := range []string{"header", "header:", "param:token", "cookie:jwt:x", "header:a:b:c"} {
+		_, err = middleware.ParseTokenLookup(lookup)
+		assert.Error(t, err, lookup)
+	}
+}
+
+func TestJWTAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jtp := setup()
+	pair, err := jtp.GenerateTokenPair("user1", 10)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(middleware.JWTAuthMiddlewareWithConfig(jtp, middleware.JWTAuthConfig{
+		SkippedPathPrefixes: []string{"/public"},
+		Extractors:          []middleware.TokenExtractor{middleware.FromBearer(), middleware.FromQuery("token")},
+	}))
+	handler := func(ctx *gin.Context) { ctx.String(http.StatusOK, ctx.GetString("username")) }
+	router.GET("/private", handler)
+	router.GET("/public", handler)
+
+	serve := func(path, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/private", "Bearer "+pair.AccessToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "user1", w.Body.String())
+
+	w = serve("/private?token="+pair.AccessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve("/private", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="TestApp"`, w.Header().Get("WWW-Authenticate"))
+	assert.JSONEq(t, `{"code":401,"message":"未提供访问令牌","data":null}`, w.Body.String())
+
+	w = serve("/private", "Bearer bogus")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+
+	w = serve("/public", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,193 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/gin-contrib/cache/persistence"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	FamilyPrefix  = "sys_family_"
+	RotatedPrefix = "sys_rotated_"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused means a rotated refresh token was presented again, its family is revoked
+	ErrRefreshTokenReused = errors.New("refresh token reused, all tokens of the login are revoked")
+	// ErrRefreshTokenRotated means a refresh token was presented again within the reuse grace, e.g. by concurrent
+	// requests, its family is kept
+	ErrRefreshTokenRotated = errors.New("refresh token already rotated")
+)
+
+// TokenFamily links the refresh tokens rotated from one login, revoking it invalidates all of them
+type TokenFamily struct {
+	ID          string
+	Username    string
+	AccessToken string // the latest access token
+	Device      Device
+	Created     time.Time
+	Rotated     time.Time
+}
+
+// RefreshEntry is stored for every issued refresh token
+type RefreshEntry struct {
+	Family      string
+	AccessToken string
+}
+
+// RotationStore marks the refresh tokens as rotated, atomically among all instances sharing it
+type RotationStore interface {
+	// MarkRotated marks the token, it returns false and the time of the first mark if it was marked already
+	MarkRotated(token string, expires time.Duration) (first bool, rotatedAt time.Time, err error)
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// cacheRotationStore relies on Add of the cache store, which is only atomic for the in-memory store
+type cacheRotationStore struct {
+	store persistence.CacheStore
+}
+
+func (s cacheRotationStore) MarkRotated(token string, expires time.Duration) (bool, time.Time, error) {
+	now := time.Now()
+	err := s.store.Add(RotatedPrefix+token, now.UnixNano(), expires)
+	if err == nil {
+		return true, now, nil
+	}
+	if err != persistence.ErrNotStored {
+		return false, time.Time{}, err
+	}
+
+	var nanos int64
+	if err = s.store.Get(RotatedPrefix+token, &nanos); err != nil {
+		return false, time.Time{}, err
+	}
+	return false, time.Unix(0, nanos), nil
+}
+
+// RedisRotationStore marks the tokens with SET NX, as Add of persistence.RedisStore is not atomic
+type RedisRotationStore struct {
+	Pool *redis.Pool
+}
+
+func (s *RedisRotationStore) MarkRotated(token string, expires time.Duration) (bool, time.Time, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	now := time.Now()
+	reply, err := redis.String(conn.Do("SET", RotatedPrefix+token, now.UnixNano(), "PX", expires.Milliseconds(), "NX"))
+	if err == nil && reply == "OK" {
+		return true, now, nil
+	}
+	if err != nil && err != redis.ErrNil {
+		return false, time.Time{}, err
+	}
+
+	nanos, err := redis.Int64(conn.Do("GET", RotatedPrefix+token))
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	return false, time.Unix(0, nanos), nil
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (jtp *JWTTokenPair) rotations() RotationStore {
+	if jtp.Rotations != nil {
+		return jtp.Rotations
+	}
+	return cacheRotationStore{store: jtp.RDB}
+}
+
+func (jtp *JWTTokenPair) refreshTTL(defaultDuration int) time.Duration {
+	return time.Duration(defaultDuration+jtp.RefreshDelay) * time.Minute
+}
+
+// issueTokenPair generates the tokens of the family, a new family is started from the device if family is nil
+func (jtp *JWTTokenPair) issueTokenPair(custom Claims, device Device, family *TokenFamily, defaultDuration int) (*JWTTokenResult, error) {
+	accessToken, err := jtp.GenerateAccessToken(custom, defaultDuration)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := jtp.GenerateTokenWithClaims(custom, jtp.RefreshSecret, defaultDuration+jtp.RefreshDelay)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	started := family == nil
+	if started {
+		id := make([]byte, 16)
+		if _, err = rand.Read(id); err != nil {
+			return nil, err
+		}
+		family = &TokenFamily{ID: hex.EncodeToString(id), Username: custom.Username, Device: device, Created: now}
+	}
+	family.AccessToken = accessToken
+	family.Rotated = now
+
+	ttl := jtp.refreshTTL(defaultDuration)
+	access := AccessEntry{Username: custom.Username, Family: family.ID}
+	if err = jtp.RDB.Set(AccessTokenPrefix+accessToken, access, time.Duration(defaultDuration)*time.Minute); err != nil {
+		return nil, err
+	}
+	err = jtp.RDB.Set(RefreshTokenPrefix+refreshToken, RefreshEntry{Family: family.ID, AccessToken: accessToken}, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	if started {
+		err = jtp.RDB.Set(FamilyPrefix+family.ID, *family, ttl)
+	} else {
+		// never bring back a family revoked in the meantime
+		err = jtp.RDB.Replace(FamilyPrefix+family.ID, *family, ttl)
+	}
+	if err == persistence.ErrNotStored || err == persistence.ErrCacheMiss {
+		_ = jtp.RDB.Delete(AccessTokenPrefix + accessToken)
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = jtp.index().Add(custom.Username, family.ID, ttl); err != nil {
+		return nil, err
+	}
+
+	return &JWTTokenResult{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// Family returns the token family by its ID
+func (jtp *JWTTokenPair) Family(id string) (*TokenFamily, error) {
+	var family TokenFamily
+	if err := jtp.RDB.Get(FamilyPrefix+id, &family); err != nil {
+		return nil, err
+	}
+	return &family, nil
+}
+
+// RevokeFamily invalidates all refresh tokens of the family and its latest access token
+func (jtp *JWTTokenPair) RevokeFamily(id string) error {
+	family, err := jtp.Family(id)
+	if err == persistence.ErrCacheMiss {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = jtp.RDB.Delete(FamilyPrefix + id); err != nil && err != persistence.ErrCacheMiss {
+		return err
+	}
+	if err = jtp.RDB.Delete(AccessTokenPrefix + family.AccessToken); err != nil && err != persistence.ErrCacheMiss {
+		return err
+	}
+	if err = jtp.RDB.Delete(SeenPrefix + id); err != nil && err != persistence.ErrCacheMiss {
+		return err
+	}
+	return jtp.index().Remove(family.Username, id)
+}
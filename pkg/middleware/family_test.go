@@ -0,0 +1,123 @@
+package middleware_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/middleware"
+)
+
+// refreshConcurrently presents the refresh token n times at once
+func refreshConcurrently(jtp *middleware.JWTTokenPair, refreshToken string, n int) ([]*middleware.JWTTokenResult, []error) {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		pairs []*middleware.JWTTokenResult
+		errs  []error
+	)
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			pair, err := jtp.RefreshTokenPair(refreshToken, 10)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				pairs = append(pairs, pair)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return pairs, errs
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	jtp := setup()
+	var reused []string
+	jtp.OnReuse = func(username, family string) {
+		reused = append(reused, username)
+	}
+
+	pair, err := jtp.GenerateTokenPair("user1", 10)
+	require.NoError(t, err)
+	second, err := jtp.RefreshTokenPair(pair.RefreshToken, 10)
+	require.NoError(t, err)
+	third, err := jtp.RefreshTokenPair(second.RefreshToken, 10)
+	require.NoError(t, err)
+
+	// a stolen token of the family is replayed
+	_, err = jtp.RefreshTokenPair(pair.RefreshToken, 10)
+	assert.ErrorIs(t, err, middleware.ErrRefreshTokenReused)
+	assert.Equal(t, []string{"user1"}, reused)
+
+	// the whole family is revoked
+	assert.False(t, jtp.IsValidAccessToken("user1", third.AccessToken))
+	_, err = jtp.RefreshTokenPair(third.RefreshToken, 10)
+	assert.ErrorIs(t, err, middleware.ErrInvalidRefreshToken)
+
+	// other logins of the user are kept
+	other, err := jtp.GenerateTokenPair("user1", 10)
+	require.NoError(t, err)
+	_, err = jtp.RefreshTokenPair(other.RefreshToken, 10)
+	assert.NoError(t, err)
+}
+
+func TestRefreshTokenConcurrent(t *testing.T) {
+	// without grace, the losers of the race count as reuse. The winner may even fail if the family is revoked before
+	// it is stored, anyway no token of the family is left valid.
+	jtp := setup()
+	pair, err := jtp.GenerateTokenPair("user1", 10)
+	require.NoError(t, err)
+	pairs, errs := refreshConcurrently(jtp, pair.RefreshToken, 8)
+	require.LessOrEqual(t, len(pairs), 1)
+	reused := 0
+	for _, err := range errs {
+		if errors.Is(err, middleware.ErrRefreshTokenReused) {
+			reused++
+		}
+	}
+	assert.Equal(t, 7, reused)
+	for _, p := range pairs {
+		assert.False(t, jtp.IsValidAccessToken("user1", p.AccessToken))
+		_, err = jtp.RefreshTokenPair(p.RefreshToken, 10)
+		assert.Error(t, err)
+	}
+
+	// within the grace, only one wins and the family is kept
+	jtp = setup()
+	jtp.ReuseGrace = time.Minute
+	pair, err = jtp.GenerateTokenPair("user1", 10)
+	require.NoError(t, err)
+	pairs, errs = refreshConcurrently(jtp, pair.RefreshToken, 8)
+	require.Len(t, pairs, 1)
+	for _, err := range errs {
+		assert.True(t, errors.Is(err, middleware.ErrRefreshTokenRotated))
+	}
+	assert.True(t, jtp.IsValidAccessToken("user1", pairs[0].AccessToken))
+	_, err = jtp.RefreshTokenPair(pairs[0].RefreshToken, 10)
+	assert.NoError(t, err)
+}
+
+func TestReleaseTokenPairRevokesFamily(t *testing.T) {
+	jtp := setup()
+	pair, err := jtp.GenerateTokenPair("user1", 10)
+	require.NoError(t, err)
+	rotated, err := jtp.RefreshTokenPair(pair.RefreshToken, 10)
+	require.NoError(t, err)
+
+	_, err = jtp.ReleaseTokenPair(rotated.AccessToken, rotated.RefreshToken)
+	require.NoError(t, err)
+	_, err = jtp.RefreshTokenPair(rotated.RefreshToken, 10)
+	assert.ErrorIs(t, err, middleware.ErrInvalidRefreshToken)
+}
@@ -1,13 +1,21 @@
 package middleware
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-contrib/cache/persistence"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/robinmin/gin-starter/pkg/bootstrap"
+	"github.com/robinmin/gin-starter/pkg/utility"
 )
 
 const (
@@ -20,7 +28,32 @@ type JWTTokenPair struct {
 	RefreshSecret   string
 	RefreshDelay    int
 	ApplicationName string
-	RDB             *persistence.RedisStore
+	RDB             persistence.CacheStore // redis, or in memory for a single instance
+
+	// Keys signs the access tokens if set, instead of AccessSecret, so other services can verify them by the JWKS
+	Keys *KeySet
+	// Algorithms accepted by ParseToken, HS256 and the algorithms of Keys if empty
+	Algorithms []string
+	// Validation of the claims by ParseToken, beyond the signature and the expiration
+	Validation ClaimsValidation
+
+	// Rotations marks the rotated refresh tokens, by Add of RDB if nil, which is only atomic for the in-memory store
+	Rotations RotationStore
+	// ReuseGrace tolerates a rotated refresh token presented again shortly after, e.g. by concurrent requests,
+	// without revoking its family
+	ReuseGrace time.Duration
+	// OnReuse is called when the family is revoked because of a reused refresh token
+	OnReuse func(username string, family string)
+	// Index lists the sessions of the users, a list in RDB if nil, which only suits a single instance
+	Index SessionIndex
+}
+
+// ClaimsValidation configures the checks of the registered and the required claims
+type ClaimsValidation struct {
+	Issuer   string        // expected issuer, ApplicationName if empty
+	Audience []string      // the token must name one of them, they are also the audience of issued tokens
+	Leeway   time.Duration // tolerated clock skew
+	Required []string      // claims which must not be empty, e.g. sub, jti, roles or tenant
 }
 
 type JWTTokenResult struct {
@@ -28,20 +61,91 @@ type JWTTokenResult struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+// Claims are the registered claims with the custom ones of the application
 type Claims struct {
-	Username string `json:"username"`
-	jwt.StandardClaims
+	Username string   `json:"username"`
+	Roles    []string `json:"roles,omitempty"`
+	Tenant   string   `json:"tenant,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+	jwt.RegisteredClaims
+
+	required []string
+}
+
+// Validate checks the required claims, it is called by the parser after the registered claims are verified
+func (c *Claims) Validate() error {
+	for _, name := range c.required {
+		var present bool
+		switch name {
+		case "sub":
+			present = c.Subject != ""
+		case "iss":
+			present = c.Issuer != ""
+		case "aud":
+			present = len(c.Audience) > 0
+		case "jti":
+			present = c.ID != ""
+		case "iat":
+			present = c.IssuedAt != nil
+		case "nbf":
+			present = c.NotBefore != nil
+		case "username":
+			present = c.Username != ""
+		case "roles":
+			present = len(c.Roles) > 0
+		case "tenant":
+			present = c.Tenant != ""
+		case "scopes":
+			present = len(c.Scopes) > 0
+		default:
+			return fmt.Errorf("unknown required claim %s", name)
+		}
+		if !present {
+			return fmt.Errorf("%w: %s", jwt.ErrTokenRequiredClaimMissing, name)
+		}
+	}
+	return nil
+}
+
+// HasScope reports whether the token grants the scope
+func (c *Claims) HasScope(scope string) bool {
+	return contains(c.Scopes, scope)
+}
+
+// newClaims fills the registered claims of the custom ones
+func (jtp *JWTTokenPair) newClaims(custom Claims, expiryDuration int) (*Claims, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims := custom
+	claims.required = nil
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    jtp.ApplicationName,
+		Subject:   custom.Username,
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Duration(expiryDuration) * time.Minute)),
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        hex.EncodeToString(id),
+	}
+	if len(jtp.Validation.Audience) > 0 {
+		claims.Audience = jwt.ClaimStrings(jtp.Validation.Audience)
+	}
+	return &claims, nil
 }
 
 // GenerateToken generates a JWT token with specific claims.
 func (jtp *JWTTokenPair) GenerateToken(username string, secret string, expiryDuration int) (string, error) {
-	expireTime := time.Now().Add(time.Duration(expiryDuration) * time.Minute)
-	claims := Claims{
-		Username: username,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expireTime.Unix(),
-			Issuer:    jtp.ApplicationName,
-		},
+	return jtp.GenerateTokenWithClaims(Claims{Username: username}, secret, expiryDuration)
+}
+
+// GenerateTokenWithClaims generates a JWT token with the custom claims, signed by the secret.
+func (jtp *JWTTokenPair) GenerateTokenWithClaims(custom Claims, secret string, expiryDuration int) (string, error) {
+	claims, err := jtp.newClaims(custom, expiryDuration)
+	if err != nil {
+		return "", err
 	}
 
 	tokenClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -53,126 +157,343 @@ func (jtp *JWTTokenPair) GenerateToken(username string, secret string, expiryDur
 	return token, nil
 }
 
-// GenerateTokenPair generates a pair of access and refresh tokens.
-func (jtp *JWTTokenPair) GenerateTokenPair(username string, defaultDuration int) (tkrst *JWTTokenResult, err error) {
-	accessToken, err := jtp.GenerateToken(username, jtp.AccessSecret, defaultDuration)
-	if err != nil {
-		return nil, err
+// GenerateAccessToken generates an access token, signed by the current key of Keys if set.
+func (jtp *JWTTokenPair) GenerateAccessToken(custom Claims, expiryDuration int) (string, error) {
+	if jtp.Keys == nil {
+		return jtp.GenerateTokenWithClaims(custom, jtp.AccessSecret, expiryDuration)
 	}
 
-	refreshToken, err := jtp.GenerateToken(username, jtp.RefreshSecret, defaultDuration+jtp.RefreshDelay)
+	claims, err := jtp.newClaims(custom, expiryDuration)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	key := jtp.Keys.Signer()
+	tokenClaims := jwt.NewWithClaims(key.Method(), claims)
+	tokenClaims.Header["kid"] = key.ID
+	return tokenClaims.SignedString(key.Private)
+}
 
-	err = jtp.RDB.Set(AccessTokenPrefix+accessToken, username, time.Duration(defaultDuration)*time.Minute)
+// GenerateTokenPair generates a pair of access and refresh tokens.
+func (jtp *JWTTokenPair) GenerateTokenPair(username string, defaultDuration int) (tkrst *JWTTokenResult, err error) {
+	return jtp.GenerateTokenPairWithClaims(Claims{Username: username}, Device{}, defaultDuration)
+}
+
+// GenerateTokenPairWithClaims generates a pair of tokens with the custom claims, e.g. roles, tenant and scopes. It
+// starts a new session of the device, i.e. a new token family.
+func (jtp *JWTTokenPair) GenerateTokenPairWithClaims(custom Claims, device Device, defaultDuration int) (tkrst *JWTTokenResult, err error) {
+	return jtp.issueTokenPair(custom, device, nil, defaultDuration)
+}
+
+// ParseToken parses a JWT token and returns its claims. Tokens with a kid are verified by the key of Keys, the others
+// by the secret. Besides the signature, the expiration is required, and the issuer, the audience, the time claims and
+// the required claims are checked as configured by Validation.
+func (jtp *JWTTokenPair) ParseToken(token string, secret string) (*Claims, error) {
+	claims := &Claims{required: jtp.Validation.Required}
+	tokenClaims, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return jtp.verifyKey(token, secret)
+	}, jtp.parserOptions()...)
 	if err != nil {
 		return nil, err
 	}
+	if !tokenClaims.Valid {
+		return nil, jwt.ErrTokenSignatureInvalid
+	}
+	claims.required = nil
+	return claims, nil
+}
 
-	err = jtp.RDB.Set(RefreshTokenPrefix+refreshToken, accessToken, time.Duration(defaultDuration+jtp.RefreshDelay)*time.Minute)
-	if err != nil {
-		return nil, err
+func (jtp *JWTTokenPair) parserOptions() []jwt.ParserOption {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(jtp.algorithms()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(jtp.Validation.Leeway),
 	}
 
-	tkrst = &JWTTokenResult{AccessToken: accessToken, RefreshToken: refreshToken}
-	return tkrst, nil
+	issuer := jtp.Validation.Issuer
+	if issuer == "" {
+		issuer = jtp.ApplicationName
+	}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	if len(jtp.Validation.Audience) > 0 {
+		opts = append(opts, jwt.WithAudience(jtp.Validation.Audience...))
+	}
+	return opts
 }
 
-// ParseToken parses a JWT token and returns its claims.
-func (jtp *JWTTokenPair) ParseToken(token string, secret string) (*Claims, error) {
-	tokenClaims, err := jwt.ParseWithClaims(token, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
+func (jtp *JWTTokenPair) algorithms() []string {
+	if len(jtp.Algorithms) > 0 {
+		return jtp.Algorithms
+	}
+	algs := []string{AlgHS256}
+	if jtp.Keys != nil {
+		algs = append(algs, jtp.Keys.Algorithms()...)
+	}
+	return algs
+}
 
-	if claims, ok := tokenClaims.Claims.(*Claims); ok && tokenClaims.Valid {
-		return claims, nil
+func (jtp *JWTTokenPair) verifyKey(token *jwt.Token, secret string) (interface{}, error) {
+	if kid, ok := token.Header["kid"].(string); ok && jtp.Keys != nil {
+		key, found := jtp.Keys.lookup(kid)
+		if !found {
+			return nil, errors.New("unknown signing key " + kid)
+		}
+		// never verify with a key of another algorithm
+		if token.Method.Alg() != key.Algorithm {
+			return nil, errors.New("unexpected signing method " + token.Method.Alg())
+		}
+		return key.Public(), nil
 	}
 
-	return nil, err
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || secret == "" {
+		return nil, errors.New("unexpected signing method " + token.Method.Alg())
+	}
+	return []byte(secret), nil
 }
 
 // IsValidAccessToken checks if the provided access token is valid and not expired.
 func (jtp *JWTTokenPair) IsValidAccessToken(username string, accessToken string) bool {
-	var storedUsername string
-	err := jtp.RDB.Get(AccessTokenPrefix+accessToken, &storedUsername)
-	return err == nil && storedUsername == username
+	entry, err := jtp.accessEntry(accessToken)
+	return err == nil && entry.Username == username
+}
+
+// accessEntry looks up the access token, it is invalid once its session is revoked
+func (jtp *JWTTokenPair) accessEntry(accessToken string) (*AccessEntry, error) {
+	var entry AccessEntry
+	if err := jtp.RDB.Get(AccessTokenPrefix+accessToken, &entry); err != nil {
+		return nil, err
+	}
+	if entry.Family != "" {
+		if _, err := jtp.Family(entry.Family); err != nil {
+			return nil, err
+		}
+	}
+	return &entry, nil
+}
+
+// JWTAuthConfig configures JWTAuthMiddlewareWithConfig
+type JWTAuthConfig struct {
+	AllowedPathPrefixes []string
+	SkippedPathPrefixes []string
+	// Extractors are tried in order, the first token found is validated
+	Extractors []TokenExtractor
+	// Realm of the WWW-Authenticate header, the application name if empty
+	Realm string
+	// Optional lets the requests without any token through anonymously, e.g. to be authorized by casbin later
+	Optional bool
+	// RootRole makes its users root, see utility.FromIsRootUser
+	RootRole string
+	// APIKeys accepts the API keys in the APIKeyHeader, or in place of the tokens, if not nil
+	APIKeys      *bootstrap.APIKeyManager
+	APIKeyHeader string
+	// Tenants binds the tokens to the tenant of the request, see utility.FromTenant. The tokens of other tenants are
+	// rejected, and the tenant of the token is taken if the request names none.
+	Tenants bool
+}
+
+var DefaultJWTAuthConfig = JWTAuthConfig{
+	Extractors: []TokenExtractor{FromBearer()},
 }
 
-// JWTAuthMiddleware creates a middleware for validating access tokens.
+// JWTAuthMiddleware creates a middleware for validating the Bearer access tokens
 func JWTAuthMiddleware(jtp *JWTTokenPair) gin.HandlerFunc {
+	return JWTAuthMiddlewareWithConfig(jtp, DefaultJWTAuthConfig)
+}
+
+// JWTAuthMiddlewareWithConfig creates a middleware for validating access tokens. It sets the username, the claims, the
+// token and the ID of the session into the context.
+func JWTAuthMiddlewareWithConfig(jtp *JWTTokenPair, config JWTAuthConfig) gin.HandlerFunc {
+	extractors := config.Extractors
+	if len(extractors) == 0 {
+		extractors = DefaultJWTAuthConfig.Extractors
+	}
+	realm := config.Realm
+	if realm == "" {
+		realm = jtp.ApplicationName
+	}
+
 	return func(ctx *gin.Context) {
-		token := ctx.GetHeader("Authorization")
+		if !AllowedPathPrefixes(ctx, config.AllowedPathPrefixes...) ||
+			SkippedPathPrefixes(ctx, config.SkippedPathPrefixes...) {
+			ctx.Next()
+			return
+		}
+
+		var token string
+		for _, extract := range extractors {
+			if token = extract(ctx); token != "" {
+				break
+			}
+		}
+		if config.APIKeys != nil {
+			if key := apiKey(ctx, config.APIKeyHeader, token); key != "" {
+				authenticateAPIKey(ctx, config, realm, key)
+				return
+			}
+		}
+		if token == "" && config.Optional {
+			ctx.Next()
+			return
+		}
 		if token == "" {
-			ctx.JSON(http.StatusUnauthorized, gin.H{"message": "未提供访问令牌"})
-			ctx.Abort()
+			// no error code if the request lacks any authentication, RFC 6750 section 3.1
+			ctx.Header("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s"`, realm))
+			bootstrap.NewResult(http.StatusUnauthorized, "未提供访问令牌", nil).Abort(ctx, http.StatusUnauthorized)
 			return
 		}
 
 		claims, err := jtp.ParseToken(token, jtp.AccessSecret)
 		if err != nil {
-			ctx.JSON(http.StatusUnauthorized, gin.H{"message": "无效的令牌"})
-			ctx.Abort()
+			unauthorized(ctx, realm, "无效的令牌", err.Error())
 			return
 		}
 
-		if !jtp.IsValidAccessToken(claims.Username, token) {
-			ctx.JSON(http.StatusUnauthorized, gin.H{"message": "无效或过期的访问令牌"})
-			ctx.Abort()
+		entry, err := jtp.accessEntry(token)
+		if err != nil || entry.Username != claims.Username {
+			unauthorized(ctx, realm, "无效或过期的访问令牌", "the token is revoked or expired")
 			return
 		}
+		if entry.Family != "" {
+			jtp.touch(entry.Family, ctx.ClientIP(), claims.ExpiresAt.Time)
+		}
+		if config.Tenants {
+			tenant := utility.FromTenant(ctx.Request.Context())
+			if tenant != "" && tenant != claims.Tenant {
+				bootstrap.NewResult(http.StatusForbidden, "令牌不属于当前租户", nil).Abort(ctx, http.StatusForbidden)
+				return
+			}
+			if tenant == "" && claims.Tenant != "" {
+				ctx.Set("tenant", claims.Tenant)
+				ctx.Request = ctx.Request.WithContext(utility.NewTenant(ctx.Request.Context(), claims.Tenant))
+			}
+		}
 
 		ctx.Set("username", claims.Username)
+		ctx.Set("claims", claims)
+		ctx.Set("token", token)
+		ctx.Set("session", entry.Family)
+		ctx.Request = ctx.Request.WithContext(NewIdentity(ctx.Request.Context(), claims, token, config.RootRole))
 
 		ctx.Next()
 	}
 }
 
-// RefreshTokenPair uses a refresh token to generate a new access token.
-func (jtp *JWTTokenPair) RefreshTokenPair(refreshToken string, defaultDuration int) (newAccessToken string, err error) {
-	var oldAccessToken string
-	err = jtp.RDB.Get(RefreshTokenPrefix+refreshToken, &oldAccessToken)
+// NewIdentity puts the user of the claims into the context, the username is the user ID and the casbin subject
+func NewIdentity(c context.Context, claims *Claims, token string, rootRole string) context.Context {
+	c = utility.NewUserID(c, claims.Username)
+	c = utility.NewUserToken(c, token)
+	c = utility.NewUserRoles(c, claims.Roles)
+	if rootRole != "" && contains(claims.Roles, rootRole) {
+		c = utility.NewIsRootUser(c)
+	}
+	return c
+}
+
+func unauthorized(ctx *gin.Context, realm string, message string, description string) {
+	description = strings.ReplaceAll(description, `"`, `'`)
+	ctx.Header("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s", error="invalid_token", error_description="%s"`, realm, description))
+	bootstrap.NewResult(http.StatusUnauthorized, message, nil).Abort(ctx, http.StatusUnauthorized)
+}
+
+// RefreshTokenPair uses a refresh token to generate a new pair of tokens, the refresh token is rotated and must not be
+// used again. Presenting it again revokes all tokens of its family, unless within ReuseGrace.
+func (jtp *JWTTokenPair) RefreshTokenPair(refreshToken string, defaultDuration int) (*JWTTokenResult, error) {
+	return jtp.RefreshTokenPairWithClaims(refreshToken, defaultDuration, nil)
+}
+
+// RefreshTokenPairWithClaims is RefreshTokenPair taking the custom claims of the new pair from update, e.g. with the
+// current roles of the user, instead of carrying them over from the refresh token. If update fails, so does the
+// refresh, and the refresh token is not rotated.
+func (jtp *JWTTokenPair) RefreshTokenPairWithClaims(refreshToken string, defaultDuration int, update func(Claims) (Claims, error)) (tkrst *JWTTokenResult, err error) {
+	var entry RefreshEntry
+	err = jtp.RDB.Get(RefreshTokenPrefix+refreshToken, &entry)
 	if err != nil {
-		return "", errors.New("invalid or expired refresh token")
+		return nil, ErrInvalidRefreshToken
 	}
 
-	claims, err := jtp.ParseToken(oldAccessToken, jtp.AccessSecret)
+	// the old access token has usually expired, so the user is taken from the refresh token
+	claims, err := jtp.ParseToken(refreshToken, jtp.RefreshSecret)
 	if err != nil {
-		return "", errors.New("failed to parse old access token")
+		return nil, ErrInvalidRefreshToken
+	}
+	custom := Claims{
+		Username: claims.Username,
+		Roles:    claims.Roles,
+		Tenant:   claims.Tenant,
+		Scopes:   claims.Scopes,
+	}
+	if update != nil {
+		if custom, err = update(custom); err != nil {
+			return nil, err
+		}
 	}
 
-	newAccessToken, err = jtp.GenerateToken(claims.Username, jtp.AccessSecret, defaultDuration)
+	// only the first of concurrent requests rotates the token
+	first, rotatedAt, err := jtp.rotations().MarkRotated(refreshToken, jtp.refreshTTL(defaultDuration))
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if !first {
+		if time.Since(rotatedAt) < jtp.ReuseGrace {
+			return nil, ErrRefreshTokenRotated
+		}
+		if err = jtp.RevokeFamily(entry.Family); err != nil {
+			return nil, err
+		}
+		if jtp.OnReuse != nil {
+			jtp.OnReuse(claims.Username, entry.Family)
+		}
+		return nil, ErrRefreshTokenReused
 	}
 
-	// 设置新的 access token 到 Redis
-	err = jtp.RDB.Set(AccessTokenPrefix+newAccessToken, claims.Username, time.Duration(defaultDuration)*time.Minute)
+	family, err := jtp.Family(entry.Family)
+	if err == persistence.ErrCacheMiss {
+		// revoked, e.g. by logout
+		return nil, ErrInvalidRefreshToken
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	oldAccessToken := family.AccessToken
 
-	// 可选：更新 refresh token 的过期时间
-	err = jtp.RDB.Set(RefreshTokenPrefix+refreshToken, newAccessToken, time.Duration(defaultDuration+jtp.RefreshDelay)*time.Minute)
+	tkrst, err = jtp.issueTokenPair(custom, family.Device, family, defaultDuration)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return newAccessToken, nil
+	// the old access token must not be used any more
+	err = jtp.RDB.Delete(AccessTokenPrefix + oldAccessToken)
+	if err != nil && err != persistence.ErrCacheMiss {
+		return nil, err
+	}
+
+	return tkrst, nil
 }
 
-// ReleaseTokenPair removes the access and refresh tokens from Redis.
+// ReleaseTokenPair removes the access and refresh tokens from Redis, the refresh token may be empty. The family of the
+// refresh token is revoked as well.
 func (jtp *JWTTokenPair) ReleaseTokenPair(accessToken string, refreshToken string) (bool, error) {
 	// 删除与 access token 相关联的条目
 	err := jtp.RDB.Delete(AccessTokenPrefix + accessToken)
-	if err != nil {
+	if err != nil && err != persistence.ErrCacheMiss {
 		return false, err
 	}
 
 	// 删除与 refresh token 相关联的条目
-	err = jtp.RDB.Delete(RefreshTokenPrefix + refreshToken)
-	if err != nil {
-		return false, err
+	if refreshToken != "" {
+		var entry RefreshEntry
+		if err = jtp.RDB.Get(RefreshTokenPrefix+refreshToken, &entry); err == nil {
+			if err = jtp.RevokeFamily(entry.Family); err != nil {
+				return false, err
+			}
+		}
+
+		err = jtp.RDB.Delete(RefreshTokenPrefix + refreshToken)
+		if err != nil && err != persistence.ErrCacheMiss {
+			return false, err
+		}
 	}
 
 	return true, nil
@@ -1,153 +1,307 @@
 package middleware_test
 
-// func setup() *middleware.JWTTokenPair {
-// 	redisStore := persistence.NewRedisCache("localhost:6379", "", 10*time.Minute)
-// 	return &middleware.JWTTokenPair{
-// 		AccessSecret:    "test_access_secret",
-// 		RefreshSecret:   "test_refresh_secret",
-// 		RefreshDelay:    120,
-// 		ApplicationName: "TestApp",
-// 		RDB:             redisStore,
-// 	}
-// }
-
-// func TestGenerateTokenPair(t *testing.T) {
-// 	testCases := []struct {
-// 		username        string
-// 		defaultDuration int
-// 		expectError     bool
-// 		errorInfo       string
-// 	}{
-// 		{"testuser1", 10, false, "Failed for testuser1 with duration 10"},
-// 		{"testuser2", 5, false, "Failed for testuser2 with duration 5"},
-// 		// 添加更多测试案例
-// 	}
-
-// 	for _, tc := range testCases {
-// 		jtp := setup()
-// 		tokenPair, err := jtp.GenerateTokenPair(tc.username, tc.defaultDuration)
-// 		if tc.expectError {
-// 			assert.Error(t, err, tc.errorInfo)
-// 		} else {
-// 			assert.NoError(t, err, tc.errorInfo)
-// 			assert.NotNil(t, tokenPair, tc.errorInfo)
-// 			assert.NotEmpty(t, tokenPair.AccessToken, tc.errorInfo)
-// 			assert.NotEmpty(t, tokenPair.RefreshToken, tc.errorInfo)
-// 		}
-// 	}
-// }
-
-// func TestGenerateToken(t *testing.T) {
-// 	testCases := []struct {
-// 		username       string
-// 		secret         string
-// 		expiryDuration int
-// 		expectError    bool
-// 		errorInfo      string
-// 	}{
-// 		{"user1", "secret1", 10, false, "GenerateToken should succeed for user1"},
-// 		// 添加更多测试案例
-// 	}
-
-// 	for _, tc := range testCases {
-// 		jtp := setup()
-// 		token, err := jtp.GenerateToken(tc.username, tc.secret, tc.expiryDuration)
-// 		if tc.expectError {
-// 			assert.Error(t, err, tc.errorInfo)
-// 		} else {
-// 			assert.NoError(t, err, tc.errorInfo)
-// 			assert.NotEmpty(t, token, tc.errorInfo)
-// 		}
-// 	}
-// }
-
-// func TestParseToken(t *testing.T) {
-// 	// 注意: 这里可能需要生成一个有效的 token 用于测试
-// 	testCases := []struct {
-// 		token       string
-// 		secret      string
-// 		expectError bool
-// 		errorInfo   string
-// 	}{
-// 		{"validToken", "secret", false, "ParseToken should succeed with valid token"},
-// 		// 添加更多测试案例
-// 	}
-
-// 	for _, tc := range testCases {
-// 		jtp := setup()
-// 		claims, err := jtp.ParseToken(tc.token, tc.secret)
-// 		if tc.expectError {
-// 			assert.Error(t, err, tc.errorInfo)
-// 		} else {
-// 			assert.NoError(t, err, tc.errorInfo)
-// 			assert.NotNil(t, claims, tc.errorInfo)
-// 		}
-// 	}
-// }
-
-// func TestIsValidAccessToken(t *testing.T) {
-// 	// 这里需要预先在 Redis 中设置一些 token 用于测试
-// 	testCases := []struct {
-// 		username    string
-// 		accessToken string
-// 		expectValid bool
-// 		errorInfo   string
-// 	}{
-// 		{"user1", "existingToken", true, "IsValidAccessToken should return true for valid token"},
-// 		// 添加更多测试案例
-// 	}
-
-// 	for _, tc := range testCases {
-// 		jtp := setup()
-// 		isValid := jtp.IsValidAccessToken(tc.username, tc.accessToken)
-// 		assert.Equal(t, tc.expectValid, isValid, tc.errorInfo)
-// 	}
-// }
-
-// func TestRefreshTokenPair(t *testing.T) {
-// 	// 这里可能需要预先生成并设置一些 token
-// 	testCases := []struct {
-// 		refreshToken    string
-// 		defaultDuration int
-// 		expectError     bool
-// 		errorInfo       string
-// 	}{
-// 		{"existingRefreshToken", 10, false, "RefreshTokenPair should succeed with valid refresh token"},
-// 		// 添加更多测试案例
-// 	}
-
-// 	for _, tc := range testCases {
-// 		jtp := setup()
-// 		newAccessToken, err := jtp.RefreshTokenPair(tc.refreshToken, tc.defaultDuration)
-// 		if tc.expectError {
-// 			assert.Error(t, err, tc.errorInfo)
-// 		} else {
-// 			assert.NoError(t, err, tc.errorInfo)
-// 			assert.NotEmpty(t, newAccessToken, tc.errorInfo)
-// 		}
-// 	}
-// }
-
-// func TestReleaseTokenPair(t *testing.T) {
-// 	// 这里可能需要预先生成并设置一些 token
-// 	testCases := []struct {
-// 		accessToken   string
-// 		refreshToken  string
-// 		expectSuccess bool
-// 		errorInfo     string
-// 	}{
-// 		{"existingAccessToken", "existingRefreshToken", true, "ReleaseTokenPair should succeed with valid tokens"},
-// 		// 添加更多测试案例
-// 	}
-
-// 	for _, tc := range testCases {
-// 		jtp := setup()
-// 		success, err := jtp.ReleaseTokenPair(tc.accessToken, tc.refreshToken)
-// 		if !tc.expectSuccess {
-// 			assert.Error(t, err, tc.errorInfo)
-// 		} else {
-// 			assert.NoError(t, err, tc.errorInfo)
-// 			assert.True(t, success, tc.errorInfo)
-// 		}
-// 	}
-// }
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/cache/persistence"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/middleware"
+	"github.com/robinmin/gin-starter/pkg/utility"
+)
+
+func setup() *middleware.JWTTokenPair {
+	return &middleware.JWTTokenPair{
+		AccessSecret:    "test_access_secret",
+		RefreshSecret:   "test_refresh_secret",
+		RefreshDelay:    120,
+		ApplicationName: "TestApp",
+		RDB:             persistence.NewInMemoryStore(10 * time.Minute),
+	}
+}
+
+func TestGenerateTokenPair(t *testing.T) {
+	testCases := []struct {
+		username        string
+		defaultDuration int
+		expectError     bool
+		errorInfo       string
+	}{
+		{"testuser1", 10, false, "Failed for testuser1 with duration 10"},
+		{"testuser2", 5, false, "Failed for testuser2 with duration 5"},
+	}
+
+	for _, tc := range testCases {
+		jtp := setup()
+		tokenPair, err := jtp.GenerateTokenPair(tc.username, tc.defaultDuration)
+		if tc.expectError {
+			assert.Error(t, err, tc.errorInfo)
+		} else {
+			assert.NoError(t, err, tc.errorInfo)
+			assert.NotNil(t, tokenPair, tc.errorInfo)
+			assert.NotEmpty(t, tokenPair.AccessToken, tc.errorInfo)
+			assert.NotEmpty(t, tokenPair.RefreshToken, tc.errorInfo)
+		}
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	testCases := []struct {
+		username       string
+		secret         string
+		expiryDuration int
+		expectError    bool
+		errorInfo      string
+	}{
+		{"user1", "secret1", 10, false, "GenerateToken should succeed for user1"},
+	}
+
+	for _, tc := range testCases {
+		jtp := setup()
+		token, err := jtp.GenerateToken(tc.username, tc.secret, tc.expiryDuration)
+		if tc.expectError {
+			assert.Error(t, err, tc.errorInfo)
+		} else {
+			assert.NoError(t, err, tc.errorInfo)
+			assert.NotEmpty(t, token, tc.errorInfo)
+		}
+	}
+}
+
+func TestParseToken(t *testing.T) {
+	sign := func(claims jwt.Claims, method jwt.SigningMethod, key interface{}) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		require.NoError(t, err)
+		return token
+	}
+	now := time.Now()
+	validToken, err := setup().GenerateToken("user1", "secret", 10)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		token       string
+		secret      string
+		expectError bool
+		errorInfo   string
+	}{
+		{validToken, "secret", false, "ParseToken should succeed with valid token"},
+		{validToken, "other", true, "ParseToken should fail with another secret"},
+		{"not.a.token", "secret", true, "ParseToken should fail with a malformed token"},
+		{sign(middleware.Claims{Username: "user1", RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: "TestApp", ExpiresAt: jwt.NewNumericDate(now.Add(-time.Minute)),
+		}}, jwt.SigningMethodHS256, []byte("secret")), "secret", true, "ParseToken should fail with an expired token"},
+		{sign(middleware.Claims{Username: "user1", RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: "TestApp",
+		}}, jwt.SigningMethodHS256, []byte("secret")), "secret", true, "ParseToken should fail without expiration"},
+		{sign(middleware.Claims{Username: "user1", RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: "OtherApp", ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		}}, jwt.SigningMethodHS256, []byte("secret")), "secret", true, "ParseToken should fail with another issuer"},
+		{sign(middleware.Claims{Username: "user1", RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: "TestApp", ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)), NotBefore: jwt.NewNumericDate(now.Add(time.Minute)),
+		}}, jwt.SigningMethodHS256, []byte("secret")), "secret", true, "ParseToken should fail before not-before"},
+		{sign(middleware.Claims{Username: "user1", RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: "TestApp", ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		}}, jwt.SigningMethodHS512, []byte("secret")), "secret", true, "ParseToken should fail with a disallowed algorithm"},
+		{sign(middleware.Claims{Username: "user1", RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: "TestApp", ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		}}, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType), "secret", true, "ParseToken should fail without signature"},
+	}
+
+	for _, tc := range testCases {
+		jtp := setup()
+		claims, err := jtp.ParseToken(tc.token, tc.secret)
+		if tc.expectError {
+			assert.Error(t, err, tc.errorInfo)
+		} else {
+			assert.NoError(t, err, tc.errorInfo)
+			assert.NotNil(t, claims, tc.errorInfo)
+		}
+	}
+}
+
+func TestClaimsValidation(t *testing.T) {
+	jtp := setup()
+	jtp.Validation = middleware.ClaimsValidation{
+		Audience: []string{"api"},
+		Leeway:   time.Minute,
+		Required: []string{"sub", "jti", "tenant"},
+	}
+
+	token, err := jtp.GenerateTokenWithClaims(middleware.Claims{
+		Username: "user1",
+		Roles:    []string{"admin"},
+		Tenant:   "acme",
+		Scopes:   []string{"orders:read"},
+	}, "secret", 10)
+	require.NoError(t, err)
+	claims, err := jtp.ParseToken(token, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, "user1", claims.Subject)
+	assert.Equal(t, []string{"admin"}, claims.Roles)
+	assert.Equal(t, "acme", claims.Tenant)
+	assert.True(t, claims.HasScope("orders:read"))
+	assert.Equal(t, jwt.ClaimStrings{"api"}, claims.Audience)
+
+	// the required tenant is missing
+	token, err = jtp.GenerateToken("user1", "secret", 10)
+	require.NoError(t, err)
+	_, err = jtp.ParseToken(token, "secret")
+	assert.ErrorIs(t, err, jwt.ErrTokenRequiredClaimMissing)
+
+	// another audience
+	other := setup()
+	other.Validation.Audience = []string{"web"}
+	token, err = other.GenerateTokenWithClaims(middleware.Claims{Username: "user1", Tenant: "acme"}, "secret", 10)
+	require.NoError(t, err)
+	_, err = jtp.ParseToken(token, "secret")
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+
+	// expired, but within the leeway
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, middleware.Claims{
+		Username: "user1",
+		Tenant:   "acme",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "TestApp",
+			Subject:   "user1",
+			Audience:  jwt.ClaimStrings{"api"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-30 * time.Second)),
+			ID:        "1",
+		},
+	}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = jtp.ParseToken(token, "secret")
+	assert.NoError(t, err)
+}
+
+func TestIsValidAccessToken(t *testing.T) {
+	jtp := setup()
+	tokenPair, err := jtp.GenerateTokenPair("user1", 10)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		username    string
+		accessToken string
+		expectValid bool
+		errorInfo   string
+	}{
+		{"user1", tokenPair.AccessToken, true, "IsValidAccessToken should return true for valid token"},
+		{"user2", tokenPair.AccessToken, false, "IsValidAccessToken should return false for another user"},
+		{"user1", "unknownToken", false, "IsValidAccessToken should return false for unknown token"},
+	}
+
+	for _, tc := range testCases {
+		isValid := jtp.IsValidAccessToken(tc.username, tc.accessToken)
+		assert.Equal(t, tc.expectValid, isValid, tc.errorInfo)
+	}
+}
+
+func TestRefreshTokenPair(t *testing.T) {
+	jtp := setup()
+	tokenPair, err := jtp.GenerateTokenPairWithClaims(middleware.Claims{Username: "user1", Roles: []string{"admin"}}, middleware.Device{}, 10)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		refreshToken    string
+		defaultDuration int
+		expectError     bool
+		errorInfo       string
+	}{
+		{tokenPair.RefreshToken, 10, false, "RefreshTokenPair should succeed with valid refresh token"},
+		{tokenPair.AccessToken, 10, true, "RefreshTokenPair should fail with an access token"},
+		{"unknownRefreshToken", 10, true, "RefreshTokenPair should fail with unknown refresh token"},
+		{tokenPair.RefreshToken, 10, true, "RefreshTokenPair should fail with a rotated refresh token"},
+	}
+
+	var rotated *middleware.JWTTokenResult
+	for _, tc := range testCases {
+		newTokenPair, err := jtp.RefreshTokenPair(tc.refreshToken, tc.defaultDuration)
+		if tc.expectError {
+			assert.Error(t, err, tc.errorInfo)
+		} else {
+			assert.NoError(t, err, tc.errorInfo)
+			require.NotNil(t, newTokenPair, tc.errorInfo)
+			assert.NotEqual(t, tokenPair.RefreshToken, newTokenPair.RefreshToken, tc.errorInfo)
+			assert.False(t, jtp.IsValidAccessToken("user1", tokenPair.AccessToken), tc.errorInfo)
+
+			claims, err := jtp.ParseToken(newTokenPair.AccessToken, jtp.AccessSecret)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"admin"}, claims.Roles)
+			rotated = newTokenPair
+		}
+	}
+
+	// the reuse revoked the tokens rotated from the reused one
+	require.NotNil(t, rotated)
+	assert.False(t, jtp.IsValidAccessToken("user1", rotated.AccessToken))
+	_, err = jtp.RefreshTokenPair(rotated.RefreshToken, 10)
+	assert.Error(t, err)
+}
+
+func TestReleaseTokenPair(t *testing.T) {
+	jtp := setup()
+	tokenPair, err := jtp.GenerateTokenPair("user1", 10)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		accessToken   string
+		refreshToken  string
+		expectSuccess bool
+		errorInfo     string
+	}{
+		{tokenPair.AccessToken, tokenPair.RefreshToken, true, "ReleaseTokenPair should succeed with valid tokens"},
+		{tokenPair.AccessToken, tokenPair.RefreshToken, true, "ReleaseTokenPair should succeed with released tokens"},
+	}
+
+	for _, tc := range testCases {
+		success, err := jtp.ReleaseTokenPair(tc.accessToken, tc.refreshToken)
+		if !tc.expectSuccess {
+			assert.Error(t, err, tc.errorInfo)
+		} else {
+			assert.NoError(t, err, tc.errorInfo)
+			assert.True(t, success, tc.errorInfo)
+		}
+	}
+	assert.False(t, jtp.IsValidAccessToken("user1", tokenPair.AccessToken))
+}
+
+func TestJWTAuthIdentity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jtp := setup()
+	root, err := jtp.GenerateTokenPairWithClaims(middleware.Claims{Username: "alice", Roles: []string{"root"}}, middleware.Device{}, 10)
+	require.NoError(t, err)
+	user, err := jtp.GenerateTokenPairWithClaims(middleware.Claims{Username: "bob", Roles: []string{"viewer"}}, middleware.Device{}, 10)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(middleware.JWTAuthMiddlewareWithConfig(jtp, middleware.JWTAuthConfig{Optional: true, RootRole: "root"}))
+	router.GET("/", func(ctx *gin.Context) {
+		c := ctx.Request.Context()
+		ctx.JSON(http.StatusOK, gin.H{
+			"id":    utility.FromUserID(c),
+			"token": utility.FromUserToken(c) != "",
+			"roles": utility.FromUserRoles(c),
+			"root":  utility.FromIsRootUser(c),
+		})
+	})
+	serve := func(token string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	assert.JSONEq(t, `{"id":"alice","token":true,"roles":["root"],"root":true}`, serve(root.AccessToken))
+	assert.JSONEq(t, `{"id":"bob","token":true,"roles":["viewer"],"root":false}`, serve(user.AccessToken))
+	// anonymous
+	assert.JSONEq(t, `{"id":"","token":false,"roles":null,"root":false}`, serve(""))
+}
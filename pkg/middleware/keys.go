@@ -0,0 +1,483 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+
+	// JWKSPath is the well-known path of the public keys
+	JWKSPath = "/.well-known/jwks.json"
+
+	// PEM header with the creation time of generated keys
+	pemCreatedHeader = "Created"
+
+	// how often the rotation schedule is checked
+	rotateCheckInterval = time.Minute
+	// how often the keys are reloaded at most for the tokens signed by an unknown key
+	unknownKeyReloadInterval = 10 * time.Second
+)
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// SigningKey is a private key identified by its kid
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	Created   time.Time
+
+	file      string // path of the PEM file
+	generated bool   // generated into the keystore, so it is deleted when retired
+}
+
+// Public returns the public key to verify the signatures with
+func (key *SigningKey) Public() crypto.PublicKey {
+	return key.Private.Public()
+}
+
+// Method returns the jwt signing method of the key
+func (key *SigningKey) Method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(key.Algorithm)
+}
+
+// KeySetOptions tells where the keys come from and how they are rotated
+type KeySetOptions struct {
+	Algorithm string   // algorithm of generated keys, RS256, ES256 or EdDSA
+	Files     []string // PEM private keys, the file name without extension is the kid
+	Dir       string   // keystore directory of generated keys, shared by all instances
+
+	// a new key is generated into Dir when the newest one is older than RotateEvery, a superseded key is kept for
+	// verification during Retain
+	RotateEvery time.Duration
+	Retain      time.Duration
+}
+
+// KeySet holds the keys signing the access tokens, the newest one signs and the others only verify
+type KeySet struct {
+	opts KeySetOptions
+	now  func() time.Time
+
+	mu   sync.RWMutex
+	keys []*SigningKey // ordered by Created
+
+	reloadMu sync.Mutex
+	reloaded time.Time // by lookup
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewKeySet loads the keys from the files and the keystore, a key is generated if the keystore is empty
+func NewKeySet(opts KeySetOptions) (*KeySet, error) {
+	if len(opts.Files) == 0 && opts.Dir == "" {
+		return nil, errors.New("neither key files nor a keystore directory is given")
+	}
+	if opts.Dir != "" && jwt.GetSigningMethod(opts.Algorithm) == nil {
+		return nil, fmt.Errorf("unsupported signing algorithm %s", opts.Algorithm)
+	}
+
+	ks := &KeySet{opts: opts, now: time.Now}
+	if err := ks.reload(); err != nil {
+		return nil, err
+	}
+	if err := ks.rotateIfDue(); err != nil {
+		return nil, err
+	}
+	if len(ks.keys) == 0 {
+		return nil, errors.New("no signing key")
+	}
+	return ks, nil
+}
+
+// Signer returns the key to sign new tokens with
+func (ks *KeySet) Signer() *SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys[len(ks.keys)-1]
+}
+
+// Key looks up the key by its kid
+func (ks *KeySet) Key(kid string) (*SigningKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, key := range ks.keys {
+		if key.ID == kid {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// lookup looks up the key by its kid, the keys are reloaded once if it is unknown, e.g. generated by another instance
+// since the last reload. The reloads are limited to one per unknownKeyReloadInterval.
+func (ks *KeySet) lookup(kid string) (*SigningKey, bool) {
+	if key, ok := ks.Key(kid); ok {
+		return key, true
+	}
+
+	ks.reloadMu.Lock()
+	defer ks.reloadMu.Unlock()
+	// reloaded by a concurrent lookup meanwhile
+	if key, ok := ks.Key(kid); ok {
+		return key, true
+	}
+	now := ks.now()
+	if now.Sub(ks.reloaded) < unknownKeyReloadInterval {
+		return nil, false
+	}
+	ks.reloaded = now
+	if err := ks.reload(); err != nil {
+		return nil, false
+	}
+	return ks.Key(kid)
+}
+
+// Keys returns all keys, the signer is the last one
+func (ks *KeySet) Keys() []*SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return append([]*SigningKey(nil), ks.keys...)
+}
+
+// Algorithms returns the algorithms of all keys
+func (ks *KeySet) Algorithms() []string {
+	var algs []string
+	for _, key := range ks.Keys() {
+		if !contains(algs, key.Algorithm) {
+			algs = append(algs, key.Algorithm)
+		}
+	}
+	return algs
+}
+
+// Rotate generates a new key into the keystore, which signs from now on
+func (ks *KeySet) Rotate() (*SigningKey, error) {
+	if ks.opts.Dir == "" {
+		return nil, errors.New("no keystore directory to rotate the keys in")
+	}
+
+	key, err := generateKey(ks.opts.Algorithm, ks.now())
+	if err != nil {
+		return nil, err
+	}
+	if err = writeKey(ks.opts.Dir, key); err != nil {
+		return nil, err
+	}
+
+	ks.mu.Lock()
+	ks.keys = append(ks.keys, key)
+	ks.mu.Unlock()
+	return key, nil
+}
+
+// Start checks the rotation schedule in the background until Stop
+func (ks *KeySet) Start() {
+	if ks.opts.Dir == "" || ks.opts.RotateEvery <= 0 || ks.stop != nil {
+		return
+	}
+
+	ks.stop = make(chan struct{})
+	ks.wg.Add(1)
+	go func() {
+		defer ks.wg.Done()
+
+		ticker := time.NewTicker(rotateCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ks.stop:
+				return
+			case <-ticker.C:
+				// other instances may have rotated the keystore already
+				if err := ks.reload(); err != nil {
+					continue
+				}
+				_ = ks.rotateIfDue()
+			}
+		}
+	}()
+}
+
+// Stop ends the rotation started by Start
+func (ks *KeySet) Stop() {
+	if ks.stop != nil {
+		close(ks.stop)
+		ks.wg.Wait()
+		ks.stop = nil
+	}
+}
+
+// rotateIfDue generates a key if the newest one is too old, and drops the keys which are superseded for longer than
+// the retention
+func (ks *KeySet) rotateIfDue() error {
+	now := ks.now()
+	ks.mu.RLock()
+	due := ks.opts.Dir != "" && (len(ks.keys) == 0 ||
+		ks.opts.RotateEvery > 0 && !now.Before(ks.keys[len(ks.keys)-1].Created.Add(ks.opts.RotateEvery)))
+	ks.mu.RUnlock()
+
+	if due {
+		if _, err := ks.Rotate(); err != nil {
+			return err
+		}
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	keys := ks.keys[:0]
+	for i, key := range ks.keys {
+		if i < len(ks.keys)-1 && key.generated && !now.Before(ks.keys[i+1].Created.Add(ks.opts.Retain)) {
+			_ = os.Remove(key.file)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	ks.keys = keys
+	return nil
+}
+
+// reload reads all key files and the keystore again
+func (ks *KeySet) reload() error {
+	var keys []*SigningKey
+	for _, file := range ks.opts.Files {
+		key, err := readKey(file)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	if ks.opts.Dir != "" {
+		if err := os.MkdirAll(ks.opts.Dir, 0o700); err != nil {
+			return err
+		}
+		files, err := filepath.Glob(filepath.Join(ks.opts.Dir, "*.pem"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			key, err := readKey(file)
+			if err != nil {
+				return err
+			}
+			key.generated = true
+			keys = append(keys, key)
+		}
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].Created.Before(keys[j].Created)
+	})
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.mu.Unlock()
+	return nil
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func generateKey(alg string, now time.Time) (*SigningKey, error) {
+	var (
+		priv crypto.Signer
+		err  error
+	)
+	switch alg {
+	case AlgRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("unsupported signing algorithm %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(priv.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	return &SigningKey{
+		ID:        base64.RawURLEncoding.EncodeToString(sum[:12]),
+		Algorithm: alg,
+		Private:   priv,
+		Created:   now.UTC(),
+		generated: true,
+	}, nil
+}
+
+func writeKey(dir string, key *SigningKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	key.file = filepath.Join(dir, key.ID+".pem")
+	data := pem.EncodeToMemory(&pem.Block{
+		Type:    "PRIVATE KEY",
+		Headers: map[string]string{pemCreatedHeader: key.Created.Format(time.RFC3339Nano)},
+		Bytes:   der,
+	})
+
+	// write to a temporary file first, so other instances never read a partial key
+	tmp := key.file + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, key.file)
+}
+
+// readKey reads a PKCS#8, PKCS#1 or SEC 1 private key, the algorithm follows the key type
+func readKey(file string) (*SigningKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", file)
+	}
+
+	var priv interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		priv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		priv, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	key := &SigningKey{
+		ID:   strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)),
+		file: file,
+	}
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm, key.Private = AlgRS256, k
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%s: only the P-256 curve is supported", file)
+		}
+		key.Algorithm, key.Private = AlgES256, k
+	case ed25519.PrivateKey:
+		key.Algorithm, key.Private = AlgEdDSA, k
+	default:
+		return nil, fmt.Errorf("%s: unsupported key type %T", file, priv)
+	}
+
+	if created, ok := block.Headers[pemCreatedHeader]; ok {
+		if key.Created, err = time.Parse(time.RFC3339Nano, created); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	} else if info, err := os.Stat(file); err == nil {
+		key.Created = info.ModTime()
+	}
+	return key, nil
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// JSONWebKey is the public part of a signing key, see RFC 7517
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys of all keys
+func (ks *KeySet) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range ks.Keys() {
+		jwk := JSONWebKey{Kid: key.ID, Use: "sig", Alg: key.Algorithm}
+		switch pub := key.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encodeBigInt(pub.N, 0)
+			jwk.E = encodeBigInt(big.NewInt(int64(pub.E)), 0)
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.Kty, jwk.Crv = "EC", pub.Curve.Params().Name
+			jwk.X = encodeBigInt(pub.X, size)
+			jwk.Y = encodeBigInt(pub.Y, size)
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// JWKSHandler serves the public keys, e.g. at JWKSPath
+func JWKSHandler(ks *KeySet) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Header("Cache-Control", "public, max-age=300")
+		ctx.JSON(http.StatusOK, ks.JWKS())
+	}
+}
+
+// encodeBigInt encodes n in base64url, left padded with zeros to size bytes
+func encodeBigInt(n *big.Int, size int) string {
+	data := n.Bytes()
+	if len(data) < size {
+		data = append(make([]byte, size-len(data)), data...)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
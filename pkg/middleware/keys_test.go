@@ -0,0 +1,170 @@
+package middleware_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/cache/persistence"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/middleware"
+)
+
+func newKeyPair(t *testing.T, keys *middleware.KeySet) *middleware.JWTTokenPair {
+	t.Helper()
+	return &middleware.JWTTokenPair{
+		RefreshSecret:   "refresh",
+		RefreshDelay:    60,
+		ApplicationName: "TestApp",
+		RDB:             persistence.NewInMemoryStore(time.Minute),
+		Keys:            keys,
+	}
+}
+
+func TestKeySetAlgorithms(t *testing.T) {
+	for _, alg := range []string{middleware.AlgRS256, middleware.AlgES256, middleware.AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			keys, err := middleware.NewKeySet(middleware.KeySetOptions{Algorithm: alg, Dir: t.TempDir()})
+			require.NoError(t, err)
+			jtp := newKeyPair(t, keys)
+
+			token, err := jtp.GenerateAccessToken(middleware.Claims{Username: "alice"}, 10)
+			require.NoError(t, err)
+			claims, err := jtp.ParseToken(token, jtp.AccessSecret)
+			require.NoError(t, err)
+			assert.Equal(t, "alice", claims.Username)
+
+			jwks := keys.JWKS()
+			require.Len(t, jwks.Keys, 1)
+			assert.Equal(t, keys.Signer().ID, jwks.Keys[0].Kid)
+			assert.Equal(t, alg, jwks.Keys[0].Alg)
+
+			// the allow-list applies to the keys as well
+			jtp.Algorithms = []string{middleware.AlgHS256}
+			_, err = jtp.ParseToken(token, jtp.AccessSecret)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestKeySetRejectsForgedTokens(t *testing.T) {
+	keys, err := middleware.NewKeySet(middleware.KeySetOptions{Algorithm: middleware.AlgRS256, Dir: t.TempDir()})
+	require.NoError(t, err)
+	jtp := newKeyPair(t, keys)
+
+	// HS256 with the public key as the secret
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, middleware.Claims{Username: "root"})
+	forged.Header["kid"] = keys.Signer().ID
+	der, err := x509.MarshalPKIXPublicKey(keys.Signer().Public())
+	require.NoError(t, err)
+	token, err := forged.SignedString(der)
+	require.NoError(t, err)
+	_, err = jtp.ParseToken(token, jtp.AccessSecret)
+	assert.Error(t, err)
+
+	// HS256 without kid and no access secret
+	token, err = jtp.GenerateToken("root", "", 10)
+	require.NoError(t, err)
+	_, err = jtp.ParseToken(token, jtp.AccessSecret)
+	assert.Error(t, err)
+
+	// unknown kid
+	other, err := middleware.NewKeySet(middleware.KeySetOptions{Algorithm: middleware.AlgRS256, Dir: t.TempDir()})
+	require.NoError(t, err)
+	token, err = newKeyPair(t, other).GenerateAccessToken(middleware.Claims{Username: "root"}, 10)
+	require.NoError(t, err)
+	_, err = jtp.ParseToken(token, jtp.AccessSecret)
+	assert.Error(t, err)
+
+	_, err = jtp.ParseToken("not a token", jtp.AccessSecret)
+	assert.Error(t, err)
+}
+
+func TestKeySetRotation(t *testing.T) {
+	dir := t.TempDir()
+	opts := middleware.KeySetOptions{
+		Algorithm:   middleware.AlgEdDSA,
+		Dir:         dir,
+		RotateEvery: time.Second,
+		Retain:      time.Second,
+	}
+	keys, err := middleware.NewKeySet(opts)
+	require.NoError(t, err)
+	jtp := newKeyPair(t, keys)
+	token, err := jtp.GenerateAccessToken(middleware.Claims{Username: "alice"}, 10)
+	require.NoError(t, err)
+	first := keys.Signer()
+
+	// the old key still verifies after the rotation
+	second, err := keys.Rotate()
+	require.NoError(t, err)
+	assert.Equal(t, second, keys.Signer())
+	assert.Len(t, keys.JWKS().Keys, 2)
+	_, err = jtp.ParseToken(token, jtp.AccessSecret)
+	assert.NoError(t, err)
+
+	// another instance sharing the keystore loads both keys, the newest one signs
+	time.Sleep(1100 * time.Millisecond)
+	opts.RotateEvery = time.Hour
+	shared, err := middleware.NewKeySet(opts)
+	require.NoError(t, err)
+	assert.Equal(t, second.ID, shared.Signer().ID)
+	_, ok := shared.Key(first.ID)
+	assert.False(t, ok, "retired key is dropped")
+	_, err = os.Stat(filepath.Join(dir, first.ID+".pem"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestKeySetReloadsUnknownKeys(t *testing.T) {
+	opts := middleware.KeySetOptions{Algorithm: middleware.AlgES256, Dir: t.TempDir()}
+	keys, err := middleware.NewKeySet(opts)
+	require.NoError(t, err)
+	other, err := middleware.NewKeySet(opts)
+	require.NoError(t, err)
+
+	// rotated by another instance, the keys are reloaded for its tokens
+	_, err = other.Rotate()
+	require.NoError(t, err)
+	token, err := newKeyPair(t, other).GenerateAccessToken(middleware.Claims{Username: "alice"}, 10)
+	require.NoError(t, err)
+	jtp := newKeyPair(t, keys)
+	_, err = jtp.ParseToken(token, jtp.AccessSecret)
+	assert.NoError(t, err)
+
+	// but not again right away
+	_, err = other.Rotate()
+	require.NoError(t, err)
+	token, err = newKeyPair(t, other).GenerateAccessToken(middleware.Claims{Username: "alice"}, 10)
+	require.NoError(t, err)
+	_, err = jtp.ParseToken(token, jtp.AccessSecret)
+	assert.Error(t, err)
+}
+
+func TestKeySetFiles(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(priv)
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "2026-key.pem")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600))
+
+	keys, err := middleware.NewKeySet(middleware.KeySetOptions{Files: []string{file}})
+	require.NoError(t, err)
+	assert.Equal(t, "2026-key", keys.Signer().ID)
+	assert.Equal(t, middleware.AlgES256, keys.Signer().Algorithm)
+
+	_, err = keys.Rotate()
+	assert.Error(t, err, "no keystore to rotate in")
+
+	_, err = middleware.NewKeySet(middleware.KeySetOptions{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,291 @@
+package middleware
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/cache/persistence"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	SessionsPrefix = "sys_sessions_"
+	SeenPrefix     = "sys_seen_"
+
+	// the last use of a session is recorded at most once per interval, unless the IP changes
+	touchInterval = time.Minute
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Device describes the client a session was started from
+type Device struct {
+	Name      string
+	IP        string
+	UserAgent string
+}
+
+// Session is a login of a user, i.e. a token family, with the client and its last use
+type Session struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Device    string    `json:"device"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Created   time.Time `json:"created"`
+	LastUsed  time.Time `json:"last_used"`
+	LastIP    string    `json:"last_ip"`
+}
+
+// AccessEntry is stored for every issued access token
+type AccessEntry struct {
+	Username string
+	Family   string
+}
+
+type seenEntry struct {
+	Time time.Time
+	IP   string
+}
+
+// SessionIndex keeps the token families of every user
+type SessionIndex interface {
+	Add(username, family string, expires time.Duration) error
+	Remove(username, family string) error
+	Members(username string) ([]string, error)
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// cacheSessionIndex keeps the families of a user as a list in the cache store, updates are serialized in the process,
+// so it only suits a single instance
+type cacheSessionIndex struct {
+	store persistence.CacheStore
+}
+
+type sessionList struct {
+	Families []string
+	Expires  time.Time
+}
+
+var cacheSessionIndexMu sync.Mutex
+
+func (idx cacheSessionIndex) Add(username, family string, expires time.Duration) error {
+	cacheSessionIndexMu.Lock()
+	defer cacheSessionIndexMu.Unlock()
+
+	list, err := idx.list(username)
+	if err != nil {
+		return err
+	}
+	if !contains(list.Families, family) {
+		list.Families = append(list.Families, family)
+	}
+	// the list lives as long as the latest family
+	if until := time.Now().Add(expires); until.After(list.Expires) {
+		list.Expires = until
+	}
+	return idx.store.Set(SessionsPrefix+username, list, time.Until(list.Expires))
+}
+
+func (idx cacheSessionIndex) Remove(username, family string) error {
+	cacheSessionIndexMu.Lock()
+	defer cacheSessionIndexMu.Unlock()
+
+	list, err := idx.list(username)
+	if err != nil || !contains(list.Families, family) {
+		return err
+	}
+
+	kept := make([]string, 0, len(list.Families)-1)
+	for _, id := range list.Families {
+		if id != family {
+			kept = append(kept, id)
+		}
+	}
+	list.Families = kept
+	ttl := time.Until(list.Expires)
+	if len(kept) == 0 || ttl <= 0 {
+		err = idx.store.Delete(SessionsPrefix + username)
+		if err == persistence.ErrCacheMiss {
+			return nil
+		}
+		return err
+	}
+	return idx.store.Set(SessionsPrefix+username, list, ttl)
+}
+
+func (idx cacheSessionIndex) Members(username string) ([]string, error) {
+	cacheSessionIndexMu.Lock()
+	defer cacheSessionIndexMu.Unlock()
+
+	list, err := idx.list(username)
+	return list.Families, err
+}
+
+func (idx cacheSessionIndex) list(username string) (sessionList, error) {
+	var list sessionList
+	err := idx.store.Get(SessionsPrefix+username, &list)
+	if err == persistence.ErrCacheMiss {
+		return sessionList{}, nil
+	}
+	return list, err
+}
+
+// RedisSessionIndex keeps the families of a user in a redis set, shared by all instances
+type RedisSessionIndex struct {
+	Pool *redis.Pool
+}
+
+func (idx *RedisSessionIndex) Add(username, family string, expires time.Duration) error {
+	conn := idx.Pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("SADD", SessionsPrefix+username, family); err != nil {
+		return err
+	}
+	_, err := conn.Do("PEXPIRE", SessionsPrefix+username, expires.Milliseconds())
+	return err
+}
+
+func (idx *RedisSessionIndex) Remove(username, family string) error {
+	conn := idx.Pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("SREM", SessionsPrefix+username, family)
+	return err
+}
+
+func (idx *RedisSessionIndex) Members(username string) ([]string, error) {
+	conn := idx.Pool.Get()
+	defer conn.Close()
+
+	return redis.Strings(conn.Do("SMEMBERS", SessionsPrefix+username))
+}
+
+// /////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (jtp *JWTTokenPair) index() SessionIndex {
+	if jtp.Index != nil {
+		return jtp.Index
+	}
+	return cacheSessionIndex{store: jtp.RDB}
+}
+
+// Sessions lists the active sessions of the user, the most recently used first
+func (jtp *JWTTokenPair) Sessions(username string) ([]Session, error) {
+	ids, err := jtp.index().Members(username)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(ids))
+	for _, id := range ids {
+		session, err := jtp.Session(id)
+		if err == ErrSessionNotFound {
+			// expired or revoked
+			if err = jtp.index().Remove(username, id); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsed.After(sessions[j].LastUsed)
+	})
+	return sessions, nil
+}
+
+// Session returns the session by its ID
+func (jtp *JWTTokenPair) Session(id string) (*Session, error) {
+	family, err := jtp.Family(id)
+	if err == persistence.ErrCacheMiss {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session := &Session{
+		ID:        family.ID,
+		Username:  family.Username,
+		Device:    family.Device.Name,
+		IP:        family.Device.IP,
+		UserAgent: family.Device.UserAgent,
+		Created:   family.Created,
+		LastUsed:  family.Rotated,
+		LastIP:    family.Device.IP,
+	}
+	var seen seenEntry
+	if err = jtp.RDB.Get(SeenPrefix+id, &seen); err == nil && seen.Time.After(session.LastUsed) {
+		session.LastUsed, session.LastIP = seen.Time, seen.IP
+	}
+	return session, nil
+}
+
+// RevokeSession logs the session of the user out
+func (jtp *JWTTokenPair) RevokeSession(username, id string) error {
+	session, err := jtp.Session(id)
+	if err != nil {
+		return err
+	}
+	// nobody can revoke the sessions of others by their IDs
+	if session.Username != username {
+		return ErrSessionNotFound
+	}
+
+	return jtp.RevokeFamily(id)
+}
+
+// RevokeOtherSessions logs all sessions of the user out except the current one, it returns the number of revoked ones
+func (jtp *JWTTokenPair) RevokeOtherSessions(username, current string) (int, error) {
+	sessions, err := jtp.Sessions(username)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, session := range sessions {
+		if session.ID == current {
+			continue
+		}
+		err = jtp.RevokeSession(username, session.ID)
+		if err == ErrSessionNotFound {
+			// revoked in the meantime
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// RevokeUserSessions logs the user out everywhere, e.g. by an admin
+func (jtp *JWTTokenPair) RevokeUserSessions(username string) (int, error) {
+	return jtp.RevokeOtherSessions(username, "")
+}
+
+// touch records the use of the session by the access token expiring at exp
+func (jtp *JWTTokenPair) touch(family, ip string, exp time.Time) {
+	var seen seenEntry
+	now := time.Now()
+	if err := jtp.RDB.Get(SeenPrefix+family, &seen); err == nil && now.Sub(seen.Time) < touchInterval && seen.IP == ip {
+		return
+	}
+
+	// the family expires with its refresh token, RefreshDelay after the access token
+	ttl := time.Until(exp) + time.Duration(jtp.RefreshDelay)*time.Minute
+	if ttl <= 0 {
+		return
+	}
+	_ = jtp.RDB.Set(SeenPrefix+family, seenEntry{Time: now, IP: ip}, ttl)
+}
@@ -0,0 +1,68 @@
+package middleware_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/robinmin/gin-starter/pkg/middleware"
+)
+
+func TestSessions(t *testing.T) {
+	jtp := setup()
+	phone, err := jtp.GenerateTokenPairWithClaims(middleware.Claims{Username: "user1"}, middleware.Device{
+		Name: "phone", IP: "10.0.0.1", UserAgent: "app/1.0",
+	}, 10)
+	require.NoError(t, err)
+	laptop, err := jtp.GenerateTokenPairWithClaims(middleware.Claims{Username: "user1"}, middleware.Device{Name: "laptop"}, 10)
+	require.NoError(t, err)
+	other, err := jtp.GenerateTokenPair("user2", 10)
+	require.NoError(t, err)
+
+	sessions, err := jtp.Sessions("user1")
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	devices := []string{sessions[0].Device, sessions[1].Device}
+	assert.ElementsMatch(t, []string{"phone", "laptop"}, devices)
+
+	var phoneID string
+	for _, session := range sessions {
+		if session.Device == "phone" {
+			phoneID = session.ID
+			assert.Equal(t, "10.0.0.1", session.IP)
+			assert.Equal(t, "app/1.0", session.UserAgent)
+		}
+	}
+
+	// the session keeps its device over rotations
+	phone, err = jtp.RefreshTokenPair(phone.RefreshToken, 10)
+	require.NoError(t, err)
+	session, err := jtp.Session(phoneID)
+	require.NoError(t, err)
+	assert.Equal(t, "phone", session.Device)
+
+	// nobody revokes the sessions of others
+	assert.ErrorIs(t, jtp.RevokeSession("user2", phoneID), middleware.ErrSessionNotFound)
+
+	n, err := jtp.RevokeOtherSessions("user1", phoneID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.True(t, jtp.IsValidAccessToken("user1", phone.AccessToken))
+	assert.False(t, jtp.IsValidAccessToken("user1", laptop.AccessToken))
+	_, err = jtp.RefreshTokenPair(laptop.RefreshToken, 10)
+	assert.Error(t, err)
+
+	require.NoError(t, jtp.RevokeSession("user1", phoneID))
+	assert.False(t, jtp.IsValidAccessToken("user1", phone.AccessToken))
+	sessions, err = jtp.Sessions("user1")
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+	assert.ErrorIs(t, jtp.RevokeSession("user1", phoneID), middleware.ErrSessionNotFound)
+
+	// force logout
+	n, err = jtp.RevokeUserSessions("user2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.False(t, jtp.IsValidAccessToken("user2", other.AccessToken))
+}
@@ -2,17 +2,21 @@ package utility
 
 import (
 	"context"
+
+	"github.com/jmoiron/sqlx"
 	// "github.com/LyricTian/gin-admin/v10/pkg/encoding/json"
-	// "gorm.io/gorm"
 )
 
 type (
-	traceIDCtx struct{}
-	// transCtx      struct{}
+	traceIDCtx    struct{}
+	transCtx      struct{}
 	rowLockCtx    struct{}
+	primaryCtx    struct{}
 	userIDCtx     struct{}
 	userTokenCtx  struct{}
+	userRolesCtx  struct{}
 	isRootUserCtx struct{}
+	tenantCtx     struct{}
 	// userCacheCtx  struct{}
 )
 
@@ -28,17 +32,17 @@ func FromTraceID(ctx context.Context) string {
 	return ""
 }
 
-// func NewTrans(ctx context.Context, db *gorm.DB) context.Context {
-// 	return context.WithValue(ctx, transCtx{}, db)
-// }
+func NewTrans(ctx context.Context, tx *sqlx.Tx) context.Context {
+	return context.WithValue(ctx, transCtx{}, tx)
+}
 
-// func FromTrans(ctx context.Context) (*gorm.DB, bool) {
-// 	v := ctx.Value(transCtx{})
-// 	if v != nil {
-// 		return v.(*gorm.DB), true
-// 	}
-// 	return nil, false
-// }
+func FromTrans(ctx context.Context) (*sqlx.Tx, bool) {
+	v := ctx.Value(transCtx{})
+	if v != nil {
+		return v.(*sqlx.Tx), true
+	}
+	return nil, false
+}
 
 func NewRowLock(ctx context.Context) context.Context {
 	return context.WithValue(ctx, rowLockCtx{}, true)
@@ -49,6 +53,16 @@ func FromRowLock(ctx context.Context) bool {
 	return v != nil && v.(bool)
 }
 
+// NewPrimaryRead makes the reads of the context go to the primary database, e.g. right after a write
+func NewPrimaryRead(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryCtx{}, true)
+}
+
+func FromPrimaryRead(ctx context.Context) bool {
+	v := ctx.Value(primaryCtx{})
+	return v != nil && v.(bool)
+}
+
 func NewUserID(ctx context.Context, userID string) context.Context {
 	return context.WithValue(ctx, userIDCtx{}, userID)
 }
@@ -73,6 +87,31 @@ func FromUserToken(ctx context.Context) string {
 	return ""
 }
 
+func NewUserRoles(ctx context.Context, roles []string) context.Context {
+	return context.WithValue(ctx, userRolesCtx{}, roles)
+}
+
+func FromUserRoles(ctx context.Context) []string {
+	v := ctx.Value(userRolesCtx{})
+	if v != nil {
+		return v.([]string)
+	}
+	return nil
+}
+
+// NewTenant sets the tenant of the request, the casbin domain of its authorization and the scope of the user roles
+func NewTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantCtx{}, tenant)
+}
+
+func FromTenant(ctx context.Context) string {
+	v := ctx.Value(tenantCtx{})
+	if v != nil {
+		return v.(string)
+	}
+	return ""
+}
+
 func NewIsRootUser(ctx context.Context) context.Context {
 	return context.WithValue(ctx, isRootUserCtx{}, true)
 }
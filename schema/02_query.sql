@@ -8,4 +8,113 @@ SELECT * FROM auth_users WHERE username = @username AND password = @password lim
 SELECT auth_roles.name FROM auth_user_roles
 LEFT JOIN auth_users ON auth_users.id = auth_user_roles.user_id
 LEFT JOIN auth_roles ON auth_roles.id = auth_user_roles.role_id
-WHERE auth_users.username = @username;
+WHERE auth_users.username = @username AND auth_user_roles.tenant = @tenant;
+
+-- name: GetUserByUsername :one
+SELECT * FROM auth_users WHERE username = @username limit 1;
+
+-- name: ListUsers :many
+SELECT * FROM auth_users ORDER BY id;
+
+-- name: CreateUser :exec
+INSERT INTO auth_users (username, password, email) VALUES (@username, @password, @email);
+
+-- name: UpdateUserPassword :execrows
+UPDATE auth_users SET password = @password, updated_at = CURRENT_TIMESTAMP WHERE username = @username;
+
+-- name: DeleteUser :execrows
+DELETE FROM auth_users WHERE username = @username;
+
+-- name: GetRoleByName :one
+SELECT * FROM auth_roles WHERE name = @name limit 1;
+
+-- name: CreateRole :exec
+INSERT INTO auth_roles (name, description) VALUES (@name, @description);
+
+-- name: AddUserRole :exec
+INSERT INTO auth_user_roles (user_id, role_id, tenant) VALUES (@user_id, @role_id, @tenant);
+
+-- name: RemoveUserRole :execrows
+DELETE FROM auth_user_roles WHERE user_id = @user_id AND role_id = @role_id AND tenant = @tenant;
+
+-- name: DeleteUserRoles :exec
+DELETE FROM auth_user_roles WHERE user_id = @user_id;
+
+-- name: CreateAPIKey :exec
+INSERT INTO auth_api_keys (user_id, name, prefix, hash, scopes, rate_limit, expires_at)
+VALUES (@user_id, @name, @prefix, @hash, @scopes, @rate_limit, @expires_at);
+
+-- name: GetAPIKeyByPrefix :one
+SELECT auth_api_keys.*, auth_users.username FROM auth_api_keys
+JOIN auth_users ON auth_users.id = auth_api_keys.user_id
+WHERE auth_api_keys.prefix = @prefix limit 1;
+
+-- name: ListAPIKeys :many
+SELECT auth_api_keys.*, auth_users.username FROM auth_api_keys
+JOIN auth_users ON auth_users.id = auth_api_keys.user_id
+ORDER BY auth_api_keys.id;
+
+-- name: RevokeAPIKey :execrows
+UPDATE auth_api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE prefix = @prefix AND revoked_at IS NULL;
+
+-- name: TouchAPIKey :exec
+UPDATE auth_api_keys SET last_used_at = @last_used_at WHERE id = @id;
+
+-- name: GetUserByIdentity :one
+SELECT auth_users.* FROM auth_identities
+JOIN auth_users ON auth_users.id = auth_identities.user_id
+WHERE auth_identities.provider = @provider AND auth_identities.subject = @subject limit 1;
+
+-- name: CreateIdentity :exec
+INSERT INTO auth_identities (provider, subject, user_id) VALUES (@provider, @subject, @user_id);
+
+-- name: DeleteUserIdentities :exec
+DELETE FROM auth_identities WHERE user_id = @user_id;
+
+-- name: GetMFA :one
+SELECT * FROM auth_mfa WHERE user_id = @user_id limit 1;
+
+-- name: CreateMFA :exec
+INSERT INTO auth_mfa (user_id, secret) VALUES (@user_id, @secret);
+
+-- name: EnableMFA :execrows
+UPDATE auth_mfa SET enabled_at = CURRENT_TIMESTAMP, last_step = @last_step WHERE user_id = @user_id AND enabled_at IS NULL;
+
+-- name: UseMFAStep :execrows
+UPDATE auth_mfa SET last_step = @last_step WHERE user_id = @user_id AND last_step < @last_step;
+
+-- name: DeleteMFA :execrows
+DELETE FROM auth_mfa WHERE user_id = @user_id;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO auth_recovery_codes (user_id, hash) VALUES (@user_id, @hash);
+
+-- name: UseRecoveryCode :execrows
+UPDATE auth_recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = @user_id AND hash = @hash AND used_at IS NULL;
+
+-- name: CountRecoveryCodes :one
+SELECT count(1) as n_count FROM auth_recovery_codes WHERE user_id = @user_id AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM auth_recovery_codes WHERE user_id = @user_id;
+
+-- name: IsMFARequired :one
+SELECT count(1) as n_count FROM auth_user_roles
+JOIN auth_roles ON auth_roles.id = auth_user_roles.role_id
+WHERE auth_user_roles.user_id = @user_id AND auth_roles.mfa_required;
+
+-- name: SetRoleMFARequired :exec
+UPDATE auth_roles SET mfa_required = @mfa_required, updated_at = CURRENT_TIMESTAMP WHERE id = @id;
+
+-- name: GetUserByEmail :one
+SELECT * FROM auth_users WHERE email = @email limit 1;
+
+-- name: VerifyUserEmail :execrows
+UPDATE auth_users SET email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+WHERE username = @username AND email = @email AND email_verified_at IS NULL;
+
+-- name: CreatePolicyAudit :exec
+INSERT INTO auth_policy_audit (actor, action, ptype, rule) VALUES (@actor, @action, @ptype, @rule);
+
+-- name: ListPolicyAudit :many
+SELECT * FROM auth_policy_audit ORDER BY id DESC limit @limit;
@@ -0,0 +1,47 @@
+-- +goose Up
+-- 01, table for storing policy rules
+CREATE TABLE IF NOT EXISTS auth_rules (
+  id BIGINT NOT NULL AUTO_INCREMENT,        -- ID
+  ptype VARCHAR(32) NOT NULL DEFAULT '',    -- 策略类型，例如 p 表示权限检查，g 表示角色继承
+  v0 VARCHAR(255) NOT NULL DEFAULT '',      -- 主体，例如用户或角色
+  v1 VARCHAR(255) NOT NULL DEFAULT '',      -- 资源
+  v2 VARCHAR(255) NOT NULL DEFAULT '',      -- 操作
+  v3 VARCHAR(255) NOT NULL DEFAULT '',      -- 条件
+  v4 VARCHAR(255) NOT NULL DEFAULT '',      -- 其他参数
+  v5 VARCHAR(255) NOT NULL DEFAULT '',      -- 其他参数
+  PRIMARY KEY (id)
+);
+
+-- 02, 用户表
+CREATE TABLE IF NOT EXISTS auth_users (
+  id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  username varchar(64) UNIQUE NOT NULL,
+  password varchar(128) NOT NULL,
+  email varchar(128) UNIQUE NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 03, 角色表
+CREATE TABLE IF NOT EXISTS auth_roles (
+  id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  name varchar(64) UNIQUE NOT NULL,
+  description varchar(128),
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 04, 用户角色关系表
+CREATE TABLE IF NOT EXISTS auth_user_roles (
+  user_id BIGINT NOT NULL,
+  role_id BIGINT NOT NULL,
+  PRIMARY KEY (user_id, role_id),
+  FOREIGN KEY (user_id) REFERENCES auth_users(id),
+  FOREIGN KEY (role_id) REFERENCES auth_roles(id)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS auth_user_roles;
+DROP TABLE IF EXISTS auth_roles;
+DROP TABLE IF EXISTS auth_users;
+DROP TABLE IF EXISTS auth_rules;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- 09, 策略审计表, every change of the casbin rules in auth_rules, rule is the comma separated values of the rule
+CREATE TABLE IF NOT EXISTS auth_policy_audit (
+  id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  actor varchar(64) NOT NULL,               -- the user who changed the rule, or cli
+  action varchar(16) NOT NULL,              -- add or remove
+  ptype varchar(32) NOT NULL,
+  rule varchar(1024) NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS auth_policy_audit;
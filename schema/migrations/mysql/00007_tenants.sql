@@ -0,0 +1,15 @@
+-- +goose Up
+-- the roles are assigned per tenant, the casbin domain of the request, the empty tenant is the one of a single tenant
+-- deployment
+ALTER TABLE auth_user_roles
+  ADD COLUMN tenant varchar(64) NOT NULL DEFAULT '',
+  DROP PRIMARY KEY,
+  ADD PRIMARY KEY (user_id, role_id, tenant);
+
+-- +goose Down
+-- only the roles of the empty tenant are kept
+DELETE FROM auth_user_roles WHERE tenant <> '';
+ALTER TABLE auth_user_roles
+  DROP PRIMARY KEY,
+  ADD PRIMARY KEY (user_id, role_id),
+  DROP COLUMN tenant;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- 05, API key表, only the hash of the key is kept
+CREATE TABLE IF NOT EXISTS auth_api_keys (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,                  -- 所属用户, the identity of the key
+  name varchar(64) NOT NULL,
+  prefix varchar(16) UNIQUE NOT NULL,       -- 用于查找的前缀
+  hash varchar(128) NOT NULL,
+  scopes varchar(255) NOT NULL DEFAULT '',  -- 以空格分隔
+  rate_limit INTEGER NOT NULL DEFAULT 0,    -- requests per minute, 0 for unlimited
+  expires_at TIMESTAMP,
+  last_used_at TIMESTAMP,
+  revoked_at TIMESTAMP,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (user_id) REFERENCES auth_users(id)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS auth_api_keys;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- 06, 外部身份表, links the subjects of the OIDC providers to the users provisioned for them
+CREATE TABLE IF NOT EXISTS auth_identities (
+  id BIGSERIAL PRIMARY KEY,
+  provider varchar(64) NOT NULL,
+  subject varchar(255) NOT NULL,            -- sub claim of the provider
+  user_id BIGINT NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (provider, subject),
+  FOREIGN KEY (user_id) REFERENCES auth_users(id)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS auth_identities;
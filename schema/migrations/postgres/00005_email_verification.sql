@@ -0,0 +1,6 @@
+-- +goose Up
+-- the email of the user is verified by the link sent to it, changing the email clears it
+ALTER TABLE auth_users ADD COLUMN email_verified_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE auth_users DROP COLUMN email_verified_at;
@@ -1,3 +1,4 @@
+-- +goose Up
 -- 01, table for storing policy rules
 CREATE TABLE IF NOT EXISTS auth_rules (
   id INTEGER,                               -- ID
@@ -41,24 +42,12 @@ CREATE TABLE IF NOT EXISTS auth_user_roles (
   user_id INTEGER NOT NULL,
   role_id INTEGER NOT NULL,
   PRIMARY KEY (user_id, role_id),
-  FOREIGN KEY (user_id) REFERENCES users(id),
-  FOREIGN KEY (role_id) REFERENCES roles(id)
+  FOREIGN KEY (user_id) REFERENCES auth_users(id),
+  FOREIGN KEY (role_id) REFERENCES auth_roles(id)
 );
 
--- -- -- 05, 权限表
--- -- CREATE TABLE IF NOT EXISTS permissions (
--- --   id INTEGER PRIMARY KEY AUTOINCREMENT,
--- --   name varchar(128) UNIQUE NOT NULL,
--- --   description varchar(128),
--- --   created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
--- --   updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
--- -- );
-
--- -- -- 角色权限关系表
--- -- CREATE TABLE IF NOT EXISTS role_permissions (
--- --   role_id INTEGER NOT NULL,
--- --   permission_id INTEGER NOT NULL,
--- --   PRIMARY KEY (role_id, permission_id),
--- --   FOREIGN KEY (role_id) REFERENCES roles(id),
--- --   FOREIGN KEY (permission_id) REFERENCES permissions(id)
--- -- );
+-- +goose Down
+DROP TABLE IF EXISTS auth_user_roles;
+DROP TABLE IF EXISTS auth_roles;
+DROP TABLE IF EXISTS auth_users;
+DROP TABLE IF EXISTS auth_rules;
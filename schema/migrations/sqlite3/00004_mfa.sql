@@ -0,0 +1,28 @@
+-- +goose Up
+-- 07, MFA表, the TOTP secret of a user, enrollment is pending until enabled_at is set
+CREATE TABLE IF NOT EXISTS auth_mfa (
+  user_id INTEGER PRIMARY KEY,
+  secret varchar(128) NOT NULL,             -- base32
+  last_step INTEGER NOT NULL DEFAULT 0,     -- time step of the last accepted code, older ones are rejected
+  enabled_at DATETIME,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (user_id) REFERENCES auth_users(id)
+);
+
+-- 08, 恢复码表, only the hashes of the codes are kept
+CREATE TABLE IF NOT EXISTS auth_recovery_codes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL,
+  hash varchar(128) NOT NULL,
+  used_at DATETIME,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (user_id) REFERENCES auth_users(id)
+);
+
+-- the users of the role must login with MFA
+ALTER TABLE auth_roles ADD COLUMN mfa_required BOOLEAN NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE auth_roles DROP COLUMN mfa_required;
+DROP TABLE IF EXISTS auth_recovery_codes;
+DROP TABLE IF EXISTS auth_mfa;
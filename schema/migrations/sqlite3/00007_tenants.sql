@@ -0,0 +1,27 @@
+-- +goose Up
+-- the roles are assigned per tenant, the casbin domain of the request, the empty tenant is the one of a single tenant
+-- deployment. sqlite can't change a primary key, the table is rebuilt.
+CREATE TABLE auth_user_roles_new (
+  user_id INTEGER NOT NULL,
+  role_id INTEGER NOT NULL,
+  tenant varchar(64) NOT NULL DEFAULT '',
+  PRIMARY KEY (user_id, role_id, tenant),
+  FOREIGN KEY (user_id) REFERENCES auth_users(id),
+  FOREIGN KEY (role_id) REFERENCES auth_roles(id)
+);
+INSERT INTO auth_user_roles_new (user_id, role_id) SELECT user_id, role_id FROM auth_user_roles;
+DROP TABLE auth_user_roles;
+ALTER TABLE auth_user_roles_new RENAME TO auth_user_roles;
+
+-- +goose Down
+-- only the roles of the empty tenant are kept
+CREATE TABLE auth_user_roles_old (
+  user_id INTEGER NOT NULL,
+  role_id INTEGER NOT NULL,
+  PRIMARY KEY (user_id, role_id),
+  FOREIGN KEY (user_id) REFERENCES auth_users(id),
+  FOREIGN KEY (role_id) REFERENCES auth_roles(id)
+);
+INSERT INTO auth_user_roles_old (user_id, role_id) SELECT user_id, role_id FROM auth_user_roles WHERE tenant = '';
+DROP TABLE auth_user_roles;
+ALTER TABLE auth_user_roles_old RENAME TO auth_user_roles;
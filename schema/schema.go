@@ -0,0 +1,16 @@
+// Package schema embeds the versioned database migrations, so that the binary can maintain the tables by itself.
+// Migrations of each database type live in migrations/<dbtype>, annotated with `-- +goose Up` and `-- +goose Down`.
+package schema
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed migrations
+var migrations embed.FS
+
+// Migrations returns the migration files of the database type, i.e. sqlite3, mysql or postgres
+func Migrations(dbtype string) (fs.FS, error) {
+	return fs.Sub(migrations, "migrations/"+dbtype)
+}